@@ -20,7 +20,7 @@ cd "$(dirname $0)"
 # any suggestion how to avoid this rename and use just replaces in go.mod is welcome
 HERE=$PWD
 STAG="1.2.0"
-DTAG="v1.2.2"
+DTAG="v1.2.3"
 cd wskdeploy
 git checkout "$STAG" -B openserverless
 cp $HERE/runtimes_extra.go runtimes/runtimes_extra.go
@@ -18,7 +18,6 @@
 package runtimes
 
 import (
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -38,17 +37,11 @@ var Version = "openserverless"
 
 func GetRuntimesByUrl(opURL string, pop *OpenWhiskInfo) error {
 
-	// configure transport
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: true,
-	}
-	var netTransport = &http.Transport{
-		TLSClientConfig: tlsConfig,
-	}
-
+	// use the default transport: ops installs there its TLS trust
+	// (OPS_CA_FILE, client certificate, OPS_INSECURE) and proxy settings
 	var netClient = &http.Client{
 		Timeout:   time.Second * utils.DEFAULT_HTTP_TIMEOUT,
-		Transport: netTransport,
+		Transport: http.DefaultTransport,
 	}
 
 	req, _ := http.NewRequest("GET", opURL, nil)
@@ -0,0 +1,246 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+var errLoginInterrupted = errors.New("login interrupted")
+
+// authResponse is a fully read HTTP response: retries need the body consumed
+// and closed before deciding whether to try again.
+type authResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// authHTTPClient is used for every request made while logging in. It bounds
+// each request with a timeout, retries the transient failures of the requests
+// that can be repeated with exponential backoff honoring Retry-After, and
+// stops as soon as its context is cancelled, e.g. by Ctrl-C.
+type authHTTPClient struct {
+	ctx             context.Context
+	client          *http.Client
+	initialInterval time.Duration
+	maxInterval     time.Duration
+	maxElapsedTime  time.Duration
+}
+
+func newAuthHTTPClient(ctx context.Context) *authHTTPClient {
+	return &authHTTPClient{
+		ctx: ctx,
+		// a nil Transport picks up http.DefaultTransport, where the TLS
+		// settings of config.ConfigureHTTPTransport are installed
+		client:          &http.Client{Timeout: 30 * time.Second},
+		initialInterval: 500 * time.Millisecond,
+		maxInterval:     10 * time.Second,
+		maxElapsedTime:  time.Minute,
+	}
+}
+
+var authClient = newAuthHTTPClient(context.Background())
+
+// useAuthContext binds the auth client to ctx until the returned function is
+// called.
+func useAuthContext(ctx context.Context) func() {
+	previous := authClient
+	client := *authClient
+	client.ctx = ctx
+	authClient = &client
+	return func() { authClient = previous }
+}
+
+// retryPolicy tells which failures of a request are retried.
+type retryPolicy int
+
+const (
+	// noRetry is for requests that must not be repeated, like the single
+	// use authorization code exchange and the password logins
+	noRetry retryPolicy = iota
+	// retryPoll is for the device flow polls: connection errors and gateway
+	// failures are retried, while 429 goes back to the polling loop, which
+	// slows down
+	retryPoll
+	// retryTransient is for idempotent requests: connection errors, 429,
+	// 502, 503 and 504 are retried
+	retryTransient
+)
+
+func (c *authHTTPClient) get(target string) (*authResponse, error) {
+	return c.do(retryTransient, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, target, nil)
+	})
+}
+
+func (c *authHTTPClient) postForm(target string, form url.Values) (*authResponse, error) {
+	return c.postFormWith(noRetry, target, form)
+}
+
+// pollForm posts a device flow poll, see retryPoll.
+func (c *authHTTPClient) pollForm(target string, form url.Values) (*authResponse, error) {
+	return c.postFormWith(retryPoll, target, form)
+}
+
+func (c *authHTTPClient) postFormWith(policy retryPolicy, target string, form url.Values) (*authResponse, error) {
+	return c.do(policy, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	})
+}
+
+func (c *authHTTPClient) postJSON(target string, payload interface{}, headers map[string]string) (*authResponse, error) {
+	return c.postJSONWith(noRetry, target, payload, headers)
+}
+
+// pollJSON posts a device flow poll, see retryPoll.
+func (c *authHTTPClient) pollJSON(target string, payload interface{}) (*authResponse, error) {
+	return c.postJSONWith(retryPoll, target, payload, nil)
+}
+
+func (c *authHTTPClient) postJSONWith(policy retryPolicy, target string, payload interface{}, headers map[string]string) (*authResponse, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(policy, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		return req, nil
+	})
+}
+
+func (c *authHTTPClient) do(policy retryPolicy, newRequest func() (*http.Request, error)) (*authResponse, error) {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = c.initialInterval
+	b.MaxInterval = c.maxInterval
+	b.MaxElapsedTime = c.maxElapsedTime
+	b.Reset()
+
+	for {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		var last *authResponse
+		resp, err := c.client.Do(req.WithContext(c.ctx))
+		if err == nil {
+			body, readErr := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if readErr != nil {
+				err = readErr
+			} else {
+				last = &authResponse{StatusCode: resp.StatusCode, Header: resp.Header, Body: body}
+				if !policy.retries(resp.StatusCode) {
+					return last, nil
+				}
+			}
+		}
+		if c.ctx.Err() != nil {
+			return nil, errLoginInterrupted
+		}
+		if policy == noRetry {
+			return nil, err
+		}
+
+		delay := b.NextBackOff()
+		if delay == backoff.Stop {
+			// out of retries: let the caller report the last status
+			if last != nil {
+				return last, nil
+			}
+			return nil, err
+		}
+		if last != nil {
+			if retryAfter, ok := parseRetryAfter(last.Header.Get("Retry-After")); ok {
+				delay = min(retryAfter, c.maxElapsedTime)
+			}
+			log.Printf("[retry] %s %s returned %d, retrying in %s", req.Method, req.URL.Redacted(), last.StatusCode, delay)
+		} else {
+			log.Printf("[retry] %s %s failed: %v, retrying in %s", req.Method, req.URL.Redacted(), err, delay)
+		}
+		if err := c.sleep(delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sleep waits for d unless the login is interrupted first.
+func (c *authHTTPClient) sleep(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-c.ctx.Done():
+		return errLoginInterrupted
+	}
+}
+
+func (p retryPolicy) retries(status int) bool {
+	switch status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return p != noRetry
+	case http.StatusTooManyRequests:
+		return p == retryTransient
+	default:
+		return false
+	}
+}
+
+// parseRetryAfter accepts both forms of the header: delay-seconds and HTTP-date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
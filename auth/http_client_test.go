@@ -0,0 +1,217 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fastAuthClient swaps in a client with short backoff for the test duration.
+func fastAuthClient(t *testing.T, ctx context.Context) *authHTTPClient {
+	t.Helper()
+	previous := authClient
+	client := newAuthHTTPClient(ctx)
+	client.initialInterval = 10 * time.Millisecond
+	client.maxInterval = 20 * time.Millisecond
+	client.maxElapsedTime = time.Second
+	client.client.Timeout = time.Second
+	authClient = client
+	t.Cleanup(func() { authClient = previous })
+	return client
+}
+
+func TestAuthHTTPClientRetriesTransientErrors(t *testing.T) {
+	fastAuthClient(t, context.Background())
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"issuer":"http://` + r.Host + `","jwks_uri":"after-retry"}`))
+	}))
+	defer server.Close()
+
+	discovery, err := fetchOIDCDiscovery(server.URL)
+	require.NoError(t, err)
+	require.Equal(t, "after-retry", discovery.JWKSURI)
+	require.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestAuthHTTPClientDoesNotRetryLogins(t *testing.T) {
+	fastAuthClient(t, context.Background())
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	_, err := doLogin(server.URL, "a user", "a password")
+	require.ErrorContains(t, err, "login failed (503)")
+	_, err = exchangeOIDCAuthorizationCode(server.URL, "ops", "code", "http://localhost/callback", "verifier")
+	require.Error(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestAuthHTTPClientDoesNotRetryClientErrors(t *testing.T) {
+	fastAuthClient(t, context.Background())
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "bad password", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	_, err := doLogin(server.URL, "a user", "a password")
+	require.ErrorContains(t, err, "login failed (401)")
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestAuthHTTPClientReportsLastStatusWhenRetriesAreExhausted(t *testing.T) {
+	client := fastAuthClient(t, context.Background())
+	client.maxElapsedTime = 50 * time.Millisecond
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "starting", http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	_, err := fetchOIDCDiscovery(server.URL)
+	require.ErrorContains(t, err, "OIDC discovery failed (502)")
+}
+
+func TestAuthHTTPClientHonorsRetryAfter(t *testing.T) {
+	fastAuthClient(t, context.Background())
+
+	var first time.Time
+	var second time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if first.IsZero() {
+			first = time.Now()
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		second = time.Now()
+		_, _ = w.Write([]byte(`{"issuer":"http://` + r.Host + `"}`))
+	}))
+	defer server.Close()
+
+	_, err := fetchOIDCDiscovery(server.URL)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, second.Sub(first), 900*time.Millisecond)
+}
+
+func TestAuthHTTPClientStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	client := fastAuthClient(t, ctx)
+	client.initialInterval = time.Second
+	client.maxInterval = time.Second
+	client.maxElapsedTime = time.Minute
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	start := time.Now()
+	_, err := fetchOIDCDiscovery(server.URL)
+	require.ErrorIs(t, err, errLoginInterrupted)
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestPollBackendManagedOIDCDeviceFlowHonorsInterval(t *testing.T) {
+	fastAuthClient(t, context.Background())
+
+	var polls []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polls = append(polls, time.Now())
+		if len(polls) == 1 {
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte(`{"status":"pending","interval":1}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"AUTH":"polled"}`))
+	}))
+	defer server.Close()
+
+	start := &backendDeviceStartResponse{FlowID: "flow", ExpiresIn: 30}
+	creds, err := pollBackendManagedOIDCDeviceFlow(server.URL, start, "")
+	require.NoError(t, err)
+	require.Equal(t, "polled", creds["AUTH"])
+	require.Len(t, polls, 2)
+	require.GreaterOrEqual(t, polls[1].Sub(polls[0]), 900*time.Millisecond)
+}
+
+func TestPollOIDCDeviceTokenSlowsDownOn429(t *testing.T) {
+	fastAuthClient(t, context.Background())
+
+	var polls []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polls = append(polls, time.Now())
+		switch len(polls) {
+		case 1:
+			// a gateway failure is retried by the client
+			w.WriteHeader(http.StatusBadGateway)
+		case 2:
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"interval":1}`))
+		default:
+			_, _ = w.Write([]byte(`{"access_token":"polled"}`))
+		}
+	}))
+	defer server.Close()
+
+	device := &deviceAuthorizationResponse{DeviceCode: "code", ExpiresIn: 30}
+	token, err := pollOIDCDeviceToken(server.URL, "ops", device, "verifier")
+	require.NoError(t, err)
+	require.Equal(t, "polled", token.AccessToken)
+	require.Len(t, polls, 3)
+	require.GreaterOrEqual(t, polls[2].Sub(polls[1]), 900*time.Millisecond)
+}
+
+func Test_nextPollInterval(t *testing.T) {
+	require.Equal(t, 2*time.Second, nextPollInterval(time.Second, 2, true))
+	require.Equal(t, 6*time.Second, nextPollInterval(time.Second, 0, true))
+	require.Equal(t, time.Second, nextPollInterval(time.Second, 0, false))
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	d, ok := parseRetryAfter("3")
+	require.True(t, ok)
+	require.Equal(t, 3*time.Second, d)
+
+	d, ok = parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	require.True(t, ok)
+	require.Greater(t, d, 59*time.Minute)
+
+	_, ok = parseRetryAfter("soon")
+	require.False(t, ok)
+}
@@ -0,0 +1,213 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apache/openserverless-cli/config"
+)
+
+// identitiesFile keeps every login performed with ops -login, keyed by
+// apihost and namespace. The active identity is also copied into config.json,
+// which is what tasks and the wsk wrapper read.
+const identitiesFile = "logins.json"
+
+type Identity struct {
+	ApiHost     string            `json:"apihost"`
+	Namespace   string            `json:"namespace"`
+	Credentials map[string]string `json:"credentials"`
+	LastLogin   time.Time         `json:"last_login"`
+}
+
+type identityStore struct {
+	Active     string               `json:"active"`
+	Identities map[string]*Identity `json:"identities"`
+	path       string
+}
+
+func identityKey(apihost, namespace string) string {
+	return strings.TrimRight(apihost, "/") + "#" + namespace
+}
+
+func loadIdentityStore(opsHome string) (*identityStore, error) {
+	store := &identityStore{
+		Identities: make(map[string]*Identity),
+		path:       filepath.Join(opsHome, identitiesFile),
+	}
+	content, err := os.ReadFile(store.path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, store); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", store.path, err)
+	}
+	if store.Identities == nil {
+		store.Identities = make(map[string]*Identity)
+	}
+	return store, nil
+}
+
+func (s *identityStore) save() error {
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, content, 0600)
+}
+
+func (s *identityStore) active() *Identity {
+	return s.Identities[s.Active]
+}
+
+// remember records a successful login and makes it the active identity.
+func (s *identityStore) remember(apihost, namespace string, creds map[string]string) *Identity {
+	key := identityKey(apihost, namespace)
+	identity := &Identity{
+		ApiHost:     strings.TrimRight(apihost, "/"),
+		Namespace:   namespace,
+		Credentials: creds,
+		LastLogin:   time.Now().UTC(),
+	}
+	s.Identities[key] = identity
+	s.Active = key
+	return identity
+}
+
+// find looks up an identity by namespace. The apihost is needed only when the
+// same namespace was used on more than one cluster.
+func (s *identityStore) find(namespace, apihost string) (*Identity, error) {
+	if apihost != "" {
+		identity, ok := s.Identities[identityKey(ensureSchema(apihost), namespace)]
+		if !ok {
+			return nil, fmt.Errorf("no login for namespace %s on %s - use ops -login first", namespace, apihost)
+		}
+		return identity, nil
+	}
+
+	var found []*Identity
+	for _, identity := range s.Identities {
+		if identity.Namespace == namespace {
+			found = append(found, identity)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no login for namespace %s - use ops -login first", namespace)
+	case 1:
+		return found[0], nil
+	default:
+		return nil, fmt.Errorf("namespace %s is logged in on several apihosts, specify one with ops -login --switch %s <apihost>", namespace, namespace)
+	}
+}
+
+func (s *identityStore) print() {
+	if len(s.Identities) == 0 {
+		fmt.Println("No logins stored. Use ops -login to add one.")
+		return
+	}
+	keys := make([]string, 0, len(s.Identities))
+	for key := range s.Identities {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		identity := s.Identities[key]
+		marker := " "
+		if key == s.Active {
+			marker = "*"
+		}
+		fmt.Printf("%s %-20s %-40s %s\n", marker, identity.Namespace, identity.ApiHost, identity.LastLogin.Local().Format(time.RFC3339))
+	}
+}
+
+// switchLogin activates a stored identity without asking for credentials.
+func switchLogin(opsHome, namespace, apihost string) (*LoginResult, error) {
+	store, err := loadIdentityStore(opsHome)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := store.find(namespace, apihost)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := identity.Credentials["AUTH"]; !ok {
+		return nil, errors.New("stored login has no AUTH token - use ops -login again")
+	}
+
+	var previous map[string]string
+	if current := store.active(); current != nil {
+		previous = current.Credentials
+	}
+	if err := saveActiveCredentials(opsHome, identity.Credentials, identity.Namespace, previous); err != nil {
+		return nil, err
+	}
+	store.Active = identityKey(identity.ApiHost, identity.Namespace)
+	if err := store.save(); err != nil {
+		return nil, err
+	}
+
+	return &LoginResult{
+		Login:   identity.Namespace,
+		Auth:    identity.Credentials["AUTH"],
+		ApiHost: identity.ApiHost,
+	}, nil
+}
+
+// saveActiveCredentials copies the credentials of the active identity into
+// config.json, first dropping the keys left by the previously active one.
+func saveActiveCredentials(opsHome string, creds map[string]string, user string, previous map[string]string) error {
+	configMap, err := config.NewConfigMapBuilder().
+		WithConfigJson(filepath.Join(opsHome, "config.json")).
+		Build()
+	if err != nil {
+		return err
+	}
+
+	for k := range previous {
+		if _, ok := creds[k]; ok {
+			continue
+		}
+		if err := configMap.Delete(k); err != nil && !strings.Contains(err.Error(), "does not exist") {
+			log.Println("[Warning] Failed to remove", k, "of the previous login")
+		}
+	}
+
+	for k, v := range creds {
+		if err := configMap.Insert(k, v); err != nil {
+			return err
+		}
+	}
+
+	if err := configMap.Insert("STATUS_LOGGED_USER", user); err != nil {
+		log.Println("[Warning] Failed to insert STATUS_LOGGED_USER")
+	}
+
+	return configMap.SaveConfig()
+}
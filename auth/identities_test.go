@@ -0,0 +1,124 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/openserverless-cli/config"
+	"github.com/stretchr/testify/require"
+)
+
+func readConfigValue(t *testing.T, opsHome, key string) (string, error) {
+	t.Helper()
+	configMap, err := config.NewConfigMapBuilder().
+		WithConfigJson(filepath.Join(opsHome, "config.json")).
+		Build()
+	require.NoError(t, err)
+	return configMap.Get(key)
+}
+
+func TestLoginCmdMultipleIdentities(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("OPS_HOME", tmpDir)
+	t.Setenv("SSO_ENABLED", "")
+	t.Setenv("OPS_PASSWORD", "a password")
+	t.Setenv("OPS_USER", "")
+	t.Setenv("OPS_APIHOST", "")
+
+	devServer := setupMockServer(t, "dev", "a password", `{"AUTH":"dev-auth","REDIS_URL":"redis://dev"}`)
+	defer devServer.Close()
+	prodServer := setupMockServer(t, "prod", "a password", `{"AUTH":"prod-auth"}`)
+	defer prodServer.Close()
+
+	os.Args = []string{"login", devServer.URL, "dev"}
+	_, err := LoginCmd()
+	require.NoError(t, err)
+
+	os.Args = []string{"login", prodServer.URL, "prod"}
+	_, err = LoginCmd()
+	require.NoError(t, err)
+
+	store, err := loadIdentityStore(tmpDir)
+	require.NoError(t, err)
+	require.Len(t, store.Identities, 2)
+	require.Equal(t, "prod", store.active().Namespace)
+
+	// prod has no REDIS_URL: the one left by dev must not leak into it
+	_, err = readConfigValue(t, tmpDir, "REDIS_URL")
+	require.Error(t, err)
+
+	t.Run("switch activates a stored login", func(t *testing.T) {
+		os.Args = []string{"login", "--switch", "dev"}
+		result, err := LoginCmd()
+		require.NoError(t, err)
+		require.Equal(t, "dev", result.Login)
+		require.Equal(t, "dev-auth", result.Auth)
+		require.Equal(t, devServer.URL, result.ApiHost)
+
+		v, err := readConfigValue(t, tmpDir, "AUTH")
+		require.NoError(t, err)
+		require.Equal(t, "dev-auth", v)
+		v, err = readConfigValue(t, tmpDir, "STATUS_LOGGED_USER")
+		require.NoError(t, err)
+		require.Equal(t, "dev", v)
+		v, err = readConfigValue(t, tmpDir, "REDIS_URL")
+		require.NoError(t, err)
+		require.Equal(t, "redis://dev", v)
+	})
+
+	t.Run("switch drops credentials of the previous login", func(t *testing.T) {
+		os.Args = []string{"login", "--switch", "prod", prodServer.URL}
+		result, err := LoginCmd()
+		require.NoError(t, err)
+		require.Equal(t, "prod-auth", result.Auth)
+
+		_, err = readConfigValue(t, tmpDir, "REDIS_URL")
+		require.Error(t, err)
+	})
+
+	t.Run("switch to an unknown namespace fails", func(t *testing.T) {
+		os.Args = []string{"login", "--switch", "missing"}
+		result, err := LoginCmd()
+		require.Error(t, err)
+		require.Nil(t, result)
+	})
+
+	t.Run("list does not login", func(t *testing.T) {
+		os.Args = []string{"login", "--list"}
+		result, err := LoginCmd()
+		require.NoError(t, err)
+		require.Equal(t, &LoginResult{Listed: true}, result)
+	})
+}
+
+func Test_identityStoreFindAmbiguous(t *testing.T) {
+	store, err := loadIdentityStore(t.TempDir())
+	require.NoError(t, err)
+	store.remember("https://a.example.test", "dev", map[string]string{"AUTH": "a"})
+	store.remember("https://b.example.test", "dev", map[string]string{"AUTH": "b"})
+
+	_, err = store.find("dev", "")
+	require.ErrorContains(t, err, "several apihosts")
+
+	identity, err := store.find("dev", "b.example.test")
+	require.NoError(t, err)
+	require.Equal(t, "b", identity.Credentials["AUTH"])
+}
@@ -18,7 +18,7 @@
 package auth
 
 import (
-	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
@@ -26,17 +26,15 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"strings"
 	"time"
 
 	"github.com/apache/openserverless-cli/config"
-	"github.com/pkg/browser"
 	"github.com/zalando/go-keyring"
 )
 
@@ -44,9 +42,14 @@ type LoginResult struct {
 	Login   string
 	Auth    string
 	ApiHost string
+	// Listed is set when --list only printed the stored logins.
+	Listed bool
 }
 
 type oidcDiscovery struct {
+	Issuer                      string `json:"issuer"`
+	JWKSURI                     string `json:"jwks_uri"`
+	AuthorizationEndpoint       string `json:"authorization_endpoint"`
 	TokenEndpoint               string `json:"token_endpoint"`
 	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
 }
@@ -64,6 +67,8 @@ type deviceAuthorizationResponse struct {
 
 type oidcTokenResponse struct {
 	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	Interval         int    `json:"interval"`
 	Error            string `json:"error"`
 	ErrorDescription string `json:"error_description"`
 }
@@ -92,11 +97,25 @@ You can set OPS_PASSWORD to avoid entering the password interactively.
 When SSO is enabled, set OPS_SSO_LOGIN_FLOW=password or pass --sso-flow password to use
 OIDC password grant instead of the browser/device flow. OPS_SSO_USERNAME can override the
 identity-provider username when it differs from the OpenServerless namespace.
+Use --sso-flow browser (or OPS_SSO_LOGIN_FLOW=browser) to login with the authorization-code
+flow in the local browser; the redirect is received on a loopback listener, on a random port
+unless OPS_SSO_CALLBACK_PORT is set. Tokens obtained from the identity provider are verified
+locally against its JWKS, issuer, audience, expiry and SSO_OIDC_REQUIRED_GROUP before login.
 For backend-managed device flow, [<user>] explicitly requests workspace binding; omit it
 to use the namespace resolved from the authenticated identity.
 
+TLS: OPS_CA_FILE adds a trusted CA bundle, OPS_CLIENT_CERT and OPS_CLIENT_KEY enable mutual TLS,
+OPS_PROXY (or HTTPS_PROXY/HTTP_PROXY) selects a proxy. --insecure or OPS_INSECURE=true disables
+certificate verification and should only be used for testing.
+
+Each login is remembered per apihost and namespace: use --list to show them and
+--switch <namespace> [<apihost>] to make another one active without logging in again.
+
 Options:
-  --sso-flow FLOW       SSO login flow: device or password. Default: device
+  --list                List stored logins, marking the active one with *
+  --switch NAMESPACE    Activate a stored login for NAMESPACE
+  --insecure            Do not verify the server TLS certificate
+  --sso-flow FLOW       SSO login flow: device, browser or password. Default: device
   --sso-username USER   Identity-provider username for SSO password flow
   -h, --help   Show usage`
 
@@ -121,11 +140,17 @@ func LoginCmd() (*LoginResult, error) {
 	}
 
 	var helpFlag bool
+	var insecureFlag bool
+	var listFlag bool
+	var switchFlag string
 	var ssoFlowFlag string
 	var ssoUsernameFlag string
 	flag.BoolVar(&helpFlag, "h", false, "Show usage")
 	flag.BoolVar(&helpFlag, "help", false, "Show usage")
-	flag.StringVar(&ssoFlowFlag, "sso-flow", "", "SSO login flow: device or password")
+	flag.BoolVar(&insecureFlag, "insecure", false, "Do not verify the server TLS certificate")
+	flag.BoolVar(&listFlag, "list", false, "List stored logins")
+	flag.StringVar(&switchFlag, "switch", "", "Activate a stored login")
+	flag.StringVar(&ssoFlowFlag, "sso-flow", "", "SSO login flow: device, browser or password")
 	flag.StringVar(&ssoUsernameFlag, "sso-username", "", "Identity-provider username for SSO password flow")
 	err := flag.Parse(os.Args[1:])
 	if err != nil {
@@ -139,6 +164,33 @@ func LoginCmd() (*LoginResult, error) {
 
 	args := flag.Args()
 
+	// Ctrl-C cancels pending requests and polling cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	defer useAuthContext(ctx)()
+
+	if insecureFlag {
+		if err := config.ConfigureHTTPTransport(true); err != nil {
+			return nil, err
+		}
+	}
+
+	opsHome := os.Getenv("OPS_HOME")
+	if listFlag || switchFlag != "" {
+		if opsHome == "" {
+			return nil, fmt.Errorf("OPS_HOME not defined")
+		}
+		if switchFlag != "" {
+			return switchLogin(opsHome, switchFlag, firstNonEmpty(args...))
+		}
+		store, err := loadIdentityStore(opsHome)
+		if err != nil {
+			return nil, err
+		}
+		store.print()
+		return &LoginResult{Listed: true}, nil
+	}
+
 	if len(args) == 0 && os.Getenv("OPS_APIHOST") == "" {
 		flag.Usage()
 		return nil, errors.New("missing apihost")
@@ -188,6 +240,11 @@ func LoginCmd() (*LoginResult, error) {
 				return nil, err
 			}
 			user = loginFromCredentials(creds, user)
+		} else if useOIDCBrowserFlow(ssoFlowFlag) {
+			oidcToken, err = oidcBrowserAccessToken()
+			if err != nil {
+				return nil, err
+			}
 		} else if useBackendManagedOIDCDeviceFlow() {
 			fmt.Println("Logging in", apihost, "with backend-managed OIDC")
 			creds, err = backendManagedOIDCDeviceLogin(apihost, requestedNamespace)
@@ -242,31 +299,25 @@ func LoginCmd() (*LoginResult, error) {
 		return nil, errors.New("missing AUTH token from login response")
 	}
 
-	opsHome := os.Getenv("OPS_HOME")
 	if opsHome == "" {
 		return nil, fmt.Errorf("OPS_HOME not defined")
 	}
 
-	configMap, err := config.NewConfigMapBuilder().
-		WithConfigJson(filepath.Join(opsHome, "config.json")).
-		Build()
-
+	store, err := loadIdentityStore(opsHome)
 	if err != nil {
 		return nil, err
 	}
-
-	for k, v := range creds {
-		if err := configMap.Insert(k, v); err != nil {
-			return nil, err
-		}
+	var previous map[string]string
+	if current := store.active(); current != nil {
+		previous = current.Credentials
 	}
 
-	if err := configMap.Insert("STATUS_LOGGED_USER", user); err != nil {
-		log.Println("[Warning] Failed to insert STATUS_LOGGED_USER")
+	if err := saveActiveCredentials(opsHome, creds, user, previous); err != nil {
+		return nil, err
 	}
 
-	err = configMap.SaveConfig()
-	if err != nil {
+	store.remember(apihost, user, creds)
+	if err := store.save(); err != nil {
 		return nil, err
 	}
 
@@ -331,10 +382,14 @@ func oidcDeviceAccessToken() (string, error) {
 	fmt.Println("Waiting for authentication...")
 
 	if verificationURL != "" && !isTruthy(os.Getenv("OPS_SSO_DISABLE_BROWSER")) {
-		_ = browser.OpenURL(verificationURL)
+		_ = openBrowser(verificationURL)
 	}
 
-	return pollOIDCDeviceToken(discovery.TokenEndpoint, clientID, device, codeVerifier)
+	token, err := pollOIDCDeviceToken(discovery.TokenEndpoint, clientID, device, codeVerifier)
+	if err != nil {
+		return "", err
+	}
+	return verifiedOIDCAccessToken(issuer, clientID, discovery, token, "")
 }
 
 func useBackendManagedOIDCDeviceFlow() bool {
@@ -378,7 +433,7 @@ func backendManagedOIDCDeviceLogin(apihost, requestedNamespace string) (map[stri
 	fmt.Println("Waiting for authentication...")
 
 	if verificationURL != "" && !isTruthy(os.Getenv("OPS_SSO_DISABLE_BROWSER")) {
-		_ = browser.OpenURL(verificationURL)
+		_ = openBrowser(verificationURL)
 	}
 
 	return pollBackendManagedOIDCDeviceFlow(pollURL, start, requestedNamespace)
@@ -417,27 +472,18 @@ func doOIDCPasswordLogin(url, username, password, requestedNamespace string) (ma
 	if strings.TrimSpace(requestedNamespace) != "" {
 		payload["namespace"] = strings.TrimSpace(requestedNamespace)
 	}
-	loginJSON, err := json.Marshal(payload)
-	if err != nil {
-		return nil, err
-	}
 
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(loginJSON))
+	resp, err := authClient.postJSON(url, payload, nil)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("OIDC password login failed with status code %d", resp.StatusCode)
-		}
-		return nil, fmt.Errorf("OIDC password login failed (%d): %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("OIDC password login failed (%d): %s", resp.StatusCode, string(resp.Body))
 	}
 
 	var creds map[string]string
-	if err := json.NewDecoder(resp.Body).Decode(&creds); err != nil {
+	if err := json.Unmarshal(resp.Body, &creds); err != nil {
 		return nil, errors.New("failed to decode response from OIDC password login request")
 	}
 	return creds, nil
@@ -448,26 +494,16 @@ func startBackendManagedOIDCDeviceFlow(url, requestedNamespace string) (*backend
 	if strings.TrimSpace(requestedNamespace) != "" {
 		payload["namespace"] = strings.TrimSpace(requestedNamespace)
 	}
-	startJSON, err := json.Marshal(payload)
-	if err != nil {
-		return nil, err
-	}
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(startJSON))
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
+	resp, err := authClient.postJSON(url, payload, nil)
 	if err != nil {
 		return nil, err
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("OIDC device authorization failed (%d): %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("OIDC device authorization failed (%d): %s", resp.StatusCode, string(resp.Body))
 	}
 
 	var start backendDeviceStartResponse
-	if err := json.Unmarshal(body, &start); err != nil {
+	if err := json.Unmarshal(resp.Body, &start); err != nil {
 		return nil, errors.New("failed to decode OIDC device authorization response")
 	}
 	return &start, nil
@@ -481,64 +517,68 @@ func pollBackendManagedOIDCDeviceFlow(url string, start *backendDeviceStartRespo
 		if time.Now().After(deadline) {
 			return nil, errors.New("OIDC device login expired")
 		}
-		time.Sleep(interval)
+		if err := authClient.sleep(interval); err != nil {
+			return nil, err
+		}
 
 		payload := map[string]string{"flow_id": start.FlowID}
 		if strings.TrimSpace(requestedNamespace) != "" {
 			payload["namespace"] = strings.TrimSpace(requestedNamespace)
 		}
-		loginJSON, err := json.Marshal(payload)
-		if err != nil {
-			return nil, err
-		}
 
-		resp, err := http.Post(url, "application/json", bytes.NewBuffer(loginJSON))
+		resp, err := authClient.pollJSON(url, payload)
 		if err != nil {
 			return nil, err
 		}
 
-		body, readErr := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		if readErr != nil {
-			return nil, readErr
-		}
-
-		if resp.StatusCode == http.StatusAccepted {
+		if resp.StatusCode == http.StatusAccepted || resp.StatusCode == http.StatusTooManyRequests {
 			var pending backendDevicePollResponse
-			if err := json.Unmarshal(body, &pending); err == nil && pending.Interval > 0 {
-				interval = time.Duration(pending.Interval) * time.Second
-			}
+			_ = json.Unmarshal(resp.Body, &pending)
+			interval = nextPollInterval(interval, pending.Interval, pending.Status == "slow_down" || resp.StatusCode == http.StatusTooManyRequests)
 			continue
 		}
 
 		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("OIDC token polling failed (%d): %s", resp.StatusCode, string(body))
+			return nil, fmt.Errorf("OIDC token polling failed (%d): %s", resp.StatusCode, string(resp.Body))
 		}
 
 		var creds map[string]string
-		if err := json.Unmarshal(body, &creds); err != nil {
+		if err := json.Unmarshal(resp.Body, &creds); err != nil {
 			return nil, errors.New("failed to decode response from OIDC device login request")
 		}
 		return creds, nil
 	}
 }
 
+// nextPollInterval applies the RFC 8628 polling rules: an interval sent by
+// the server wins, otherwise slow_down adds five seconds.
+func nextPollInterval(current time.Duration, serverInterval int, slowDown bool) time.Duration {
+	if serverInterval > 0 {
+		return time.Duration(serverInterval) * time.Second
+	}
+	if slowDown {
+		return current + 5*time.Second
+	}
+	return current
+}
+
 func fetchOIDCDiscovery(issuer string) (*oidcDiscovery, error) {
-	resp, err := http.Get(issuer + "/.well-known/openid-configuration")
+	resp, err := authClient.get(issuer + "/.well-known/openid-configuration")
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("OIDC discovery failed (%d): %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("OIDC discovery failed (%d): %s", resp.StatusCode, string(resp.Body))
 	}
 
 	var discovery oidcDiscovery
-	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+	if err := json.Unmarshal(resp.Body, &discovery); err != nil {
 		return nil, errors.New("failed to decode OIDC discovery response")
 	}
+	if err := checkDiscoveryIssuer(&discovery, issuer); err != nil {
+		return nil, err
+	}
 	return &discovery, nil
 }
 
@@ -549,14 +589,13 @@ func startOIDCDeviceAuthorization(endpoint, clientID, codeChallenge string) (*de
 	form.Set("code_challenge", codeChallenge)
 	form.Set("code_challenge_method", "S256")
 
-	resp, err := http.PostForm(endpoint, form)
+	resp, err := authClient.postForm(endpoint, form)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
 	var device deviceAuthorizationResponse
-	if err := json.NewDecoder(resp.Body).Decode(&device); err != nil {
+	if err := json.Unmarshal(resp.Body, &device); err != nil {
 		return nil, errors.New("failed to decode OIDC device authorization response")
 	}
 	if resp.StatusCode != http.StatusOK {
@@ -577,15 +616,17 @@ func startOIDCDeviceAuthorization(endpoint, clientID, codeChallenge string) (*de
 	return &device, nil
 }
 
-func pollOIDCDeviceToken(tokenEndpoint, clientID string, device *deviceAuthorizationResponse, codeVerifier string) (string, error) {
+func pollOIDCDeviceToken(tokenEndpoint, clientID string, device *deviceAuthorizationResponse, codeVerifier string) (*oidcTokenResponse, error) {
 	deadline := time.Now().Add(time.Duration(device.ExpiresIn) * time.Second)
 	interval := time.Duration(device.Interval) * time.Second
 
 	for {
 		if time.Now().After(deadline) {
-			return "", errors.New("OIDC device login expired")
+			return nil, errors.New("OIDC device login expired")
+		}
+		if err := authClient.sleep(interval); err != nil {
+			return nil, err
 		}
-		time.Sleep(interval)
 
 		form := url.Values{}
 		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:device_code")
@@ -593,37 +634,41 @@ func pollOIDCDeviceToken(tokenEndpoint, clientID string, device *deviceAuthoriza
 		form.Set("device_code", device.DeviceCode)
 		form.Set("code_verifier", codeVerifier)
 
-		resp, err := http.PostForm(tokenEndpoint, form)
+		resp, err := authClient.pollForm(tokenEndpoint, form)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
 		var token oidcTokenResponse
-		decodeErr := json.NewDecoder(resp.Body).Decode(&token)
-		resp.Body.Close()
-		if decodeErr != nil {
-			return "", errors.New("failed to decode OIDC token response")
+		if resp.StatusCode == http.StatusTooManyRequests {
+			_ = json.Unmarshal(resp.Body, &token)
+			interval = nextPollInterval(interval, token.Interval, true)
+			continue
+		}
+		if err := json.Unmarshal(resp.Body, &token); err != nil {
+			return nil, errors.New("failed to decode OIDC token response")
 		}
 
 		if resp.StatusCode == http.StatusOK && token.AccessToken != "" {
-			return token.AccessToken, nil
+			return &token, nil
 		}
 
 		switch token.Error {
 		case "authorization_pending":
+			interval = nextPollInterval(interval, token.Interval, false)
 			continue
 		case "slow_down":
-			interval += 5 * time.Second
+			interval = nextPollInterval(interval, token.Interval, true)
 			continue
 		case "access_denied":
-			return "", errors.New("OIDC device login denied")
+			return nil, errors.New("OIDC device login denied")
 		case "expired_token":
-			return "", errors.New("OIDC device login expired")
+			return nil, errors.New("OIDC device login expired")
 		default:
 			if token.Error != "" {
-				return "", fmt.Errorf("OIDC token polling failed: %s: %s", token.Error, token.ErrorDescription)
+				return nil, fmt.Errorf("OIDC token polling failed: %s: %s", token.Error, token.ErrorDescription)
 			}
-			return "", fmt.Errorf("OIDC token polling failed with status code %d", resp.StatusCode)
+			return nil, fmt.Errorf("OIDC token polling failed with status code %d", resp.StatusCode)
 		}
 	}
 }
@@ -682,27 +727,18 @@ func doLogin(url, user, password string) (map[string]string, error) {
 		"login":    user,
 		"password": password,
 	}
-	loginJson, err := json.Marshal(data)
-	if err != nil {
-		return nil, err
-	}
 
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(loginJson))
+	resp, err := authClient.postJSON(url, data, nil)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("login failed with status code %d", resp.StatusCode)
-		}
-		return nil, fmt.Errorf("login failed (%d): %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("login failed (%d): %s", resp.StatusCode, string(resp.Body))
 	}
 
 	var creds map[string]string
-	err = json.NewDecoder(resp.Body).Decode(&creds)
+	err = json.Unmarshal(resp.Body, &creds)
 	if err != nil {
 		return nil, errors.New("failed to decode response from login request")
 	}
@@ -719,38 +755,22 @@ func doOIDCLogin(url, accessToken string) (map[string]string, error) {
 	data := map[string]string{
 		"access_token": strings.TrimPrefix(token, "Bearer "),
 	}
-	loginJson, err := json.Marshal(data)
-	if err != nil {
-		return nil, err
+	authorization := token
+	if !strings.HasPrefix(token, "Bearer ") {
+		authorization = "Bearer " + token
 	}
 
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(loginJson))
+	resp, err := authClient.postJSON(url, data, map[string]string{"Authorization": authorization})
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	if strings.HasPrefix(token, "Bearer ") {
-		req.Header.Set("Authorization", token)
-	} else {
-		req.Header.Set("Authorization", "Bearer "+token)
-	}
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("OIDC login failed with status code %d", resp.StatusCode)
-		}
-		return nil, fmt.Errorf("OIDC login failed (%d): %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("OIDC login failed (%d): %s", resp.StatusCode, string(resp.Body))
 	}
 
 	var creds map[string]string
-	err = json.NewDecoder(resp.Body).Decode(&creds)
+	err = json.Unmarshal(resp.Body, &creds)
 	if err != nil {
 		return nil, errors.New("failed to decode response from OIDC login request")
 	}
@@ -195,14 +195,20 @@ func TestLoginCmd(t *testing.T) {
 		t.Setenv("OPS_USER", "")
 		t.Setenv("OPS_APIHOST", "")
 
+		idp := newTestIdP(t)
+		var accessToken string
 		var mockServer *httptest.Server
 		mockServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			switch r.URL.Path {
 			case "/realms/lab/.well-known/openid-configuration":
 				_, _ = w.Write([]byte(fmt.Sprintf(`{
+					"issuer": "%s/realms/lab",
+					"jwks_uri": "%s/realms/lab/protocol/openid-connect/certs",
 					"device_authorization_endpoint": "%s/realms/lab/protocol/openid-connect/auth/device",
 					"token_endpoint": "%s/realms/lab/protocol/openid-connect/token"
-				}`, mockServer.URL, mockServer.URL)))
+				}`, mockServer.URL, mockServer.URL, mockServer.URL, mockServer.URL)))
+			case "/realms/lab/protocol/openid-connect/certs":
+				_, _ = w.Write(idp.jwks())
 			case "/realms/lab/protocol/openid-connect/auth/device":
 				require.NoError(t, r.ParseForm())
 				require.Equal(t, "openserverless-admin-api", r.Form.Get("client_id"))
@@ -222,9 +228,9 @@ func TestLoginCmd(t *testing.T) {
 				require.Equal(t, "openserverless-admin-api", r.Form.Get("client_id"))
 				require.Equal(t, "device-code", r.Form.Get("device_code"))
 				require.NotEmpty(t, r.Form.Get("code_verifier"))
-				_, _ = w.Write([]byte(`{"access_token":"device-access-token"}`))
+				_, _ = w.Write([]byte(fmt.Sprintf(`{"access_token":%q}`, accessToken)))
 			case "/system/api/v1/auth/oidc":
-				require.Equal(t, "Bearer device-access-token", r.Header.Get("Authorization"))
+				require.Equal(t, "Bearer "+accessToken, r.Header.Get("Authorization"))
 				_, _ = w.Write([]byte(`{"AUTH":"oidc-auth","NAMESPACE":"michelem"}`))
 			default:
 				http.NotFound(w, r)
@@ -233,6 +239,7 @@ func TestLoginCmd(t *testing.T) {
 		defer mockServer.Close()
 
 		t.Setenv("SSO_OIDC_ISSUER_URL", mockServer.URL+"/realms/lab")
+		accessToken = idp.sign(t, idp.claims(mockServer.URL+"/realms/lab", nil))
 
 		os.Args = []string{"login", mockServer.URL}
 		loginResult, err := LoginCmd()
@@ -0,0 +1,242 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/browser"
+)
+
+const oidcBrowserCallbackPath = "/callback"
+const oidcBrowserLoginTimeout = 5 * time.Minute
+
+const oidcBrowserDoneHTML = `<!DOCTYPE html>
+<html><body><h3>OpenServerless login completed.</h3><p>You can close this window and return to the terminal.</p></body></html>`
+
+// openBrowser is replaced in tests to simulate the user completing the login.
+var openBrowser = browser.OpenURL
+
+type oidcCallbackResult struct {
+	code string
+	err  error
+}
+
+func useOIDCBrowserFlow(flagValue string) bool {
+	return strings.EqualFold(firstNonEmpty(flagValue, os.Getenv("OPS_SSO_LOGIN_FLOW")), "browser")
+}
+
+// oidcBrowserAccessToken runs the OIDC authorization-code flow with PKCE,
+// receiving the authorization response on a loopback redirect (RFC 8252).
+func oidcBrowserAccessToken() (string, error) {
+	issuer := strings.TrimRight(firstNonEmpty(os.Getenv("SSO_OIDC_ISSUER_URL"), os.Getenv("OIDC_ISSUER_URL")), "/")
+	clientID := firstNonEmpty(os.Getenv("SSO_OIDC_AUDIENCE"), os.Getenv("OIDC_AUDIENCE"))
+	if issuer == "" {
+		return "", errors.New("SSO is enabled but SSO_OIDC_ISSUER_URL is not configured")
+	}
+	if clientID == "" {
+		return "", errors.New("SSO is enabled but SSO_OIDC_AUDIENCE is not configured")
+	}
+
+	discovery, err := fetchOIDCDiscovery(issuer)
+	if err != nil {
+		return "", err
+	}
+	if discovery.AuthorizationEndpoint == "" {
+		return "", errors.New("OIDC provider does not expose authorization_endpoint")
+	}
+	if discovery.TokenEndpoint == "" {
+		return "", errors.New("OIDC provider does not expose token_endpoint")
+	}
+
+	codeVerifier, codeChallenge, err := pkceChallenge()
+	if err != nil {
+		return "", err
+	}
+	state, err := randomURLToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomURLToken()
+	if err != nil {
+		return "", err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:"+firstNonEmpty(os.Getenv("OPS_SSO_CALLBACK_PORT"), "0"))
+	if err != nil {
+		return "", fmt.Errorf("cannot start the local SSO callback listener: %w", err)
+	}
+	redirectURI := fmt.Sprintf("http://%s%s", listener.Addr().String(), oidcBrowserCallbackPath)
+
+	results := make(chan oidcCallbackResult, 1)
+	server := &http.Server{
+		Handler:           oidcCallbackHandler(state, results),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Close()
+
+	authURL, err := oidcAuthorizationURL(discovery.AuthorizationEndpoint, clientID, redirectURI, state, nonce, codeChallenge)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Println()
+	fmt.Println("SSO is enabled for this cluster.")
+	fmt.Println("Open this URL in your browser to login:")
+	fmt.Println(authURL)
+	fmt.Println("Waiting for authentication...")
+
+	if !isTruthy(os.Getenv("OPS_SSO_DISABLE_BROWSER")) {
+		_ = openBrowser(authURL)
+	}
+
+	var result oidcCallbackResult
+	select {
+	case result = <-results:
+	case <-time.After(oidcBrowserLoginTimeout):
+		return "", errors.New("OIDC browser login expired")
+	case <-authClient.ctx.Done():
+		return "", errLoginInterrupted
+	}
+	if result.err != nil {
+		return "", result.err
+	}
+
+	token, err := exchangeOIDCAuthorizationCode(discovery.TokenEndpoint, clientID, result.code, redirectURI, codeVerifier)
+	if err != nil {
+		return "", err
+	}
+	return verifiedOIDCAccessToken(issuer, clientID, discovery, token, nonce)
+}
+
+func oidcAuthorizationURL(endpoint, clientID, redirectURI, state, nonce, codeChallenge string) (string, error) {
+	authURL, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid OIDC authorization_endpoint: %w", err)
+	}
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", clientID)
+	query.Set("redirect_uri", redirectURI)
+	query.Set("scope", "openid email profile")
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+	return authURL.String(), nil
+}
+
+// oidcCallbackHandler accepts a single authorization response and reports it
+// on results. Requests with a wrong state are rejected without consuming the
+// flow, so a stray request cannot abort a login in progress.
+func oidcCallbackHandler(state string, results chan<- oidcCallbackResult) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(oidcBrowserCallbackPath, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("state") != state {
+			http.Error(w, "invalid state", http.StatusBadRequest)
+			return
+		}
+
+		var result oidcCallbackResult
+		if errCode := query.Get("error"); errCode != "" {
+			result.err = fmt.Errorf("OIDC browser login failed: %s: %s", errCode, query.Get("error_description"))
+			http.Error(w, "login failed: "+errCode, http.StatusBadRequest)
+		} else if code := query.Get("code"); code == "" {
+			result.err = errors.New("OIDC browser login response missing code")
+			http.Error(w, "missing code", http.StatusBadRequest)
+		} else {
+			result.code = code
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte(oidcBrowserDoneHTML))
+		}
+
+		select {
+		case results <- result:
+		default:
+		}
+	})
+	return mux
+}
+
+func exchangeOIDCAuthorizationCode(tokenEndpoint, clientID, code, redirectURI, codeVerifier string) (*oidcTokenResponse, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("client_id", clientID)
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("code_verifier", codeVerifier)
+
+	resp, err := authClient.postForm(tokenEndpoint, form)
+	if err != nil {
+		return nil, err
+	}
+
+	var token oidcTokenResponse
+	if err := json.Unmarshal(resp.Body, &token); err != nil {
+		return nil, errors.New("failed to decode OIDC token response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		if token.Error != "" {
+			return nil, fmt.Errorf("OIDC code exchange failed (%d): %s: %s", resp.StatusCode, token.Error, token.ErrorDescription)
+		}
+		return nil, fmt.Errorf("OIDC code exchange failed with status code %d", resp.StatusCode)
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("OIDC token response missing access_token")
+	}
+	return &token, nil
+}
+
+func decodeJWTClaims(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed OIDC token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, errors.New("malformed OIDC token payload")
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("malformed OIDC token claims")
+	}
+	return claims, nil
+}
+
+func randomURLToken() (string, error) {
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
@@ -0,0 +1,159 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func fakeJWT(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	return header + "." + base64.RawURLEncoding.EncodeToString(payload) + ".sig"
+}
+
+// completeBrowserLogin plays the role of the user's browser: it follows the
+// authorization URL straight to the loopback redirect with the given code.
+func completeBrowserLogin(t *testing.T, code string, nonce *string) func(string) error {
+	return func(authURL string) error {
+		parsed, err := url.Parse(authURL)
+		require.NoError(t, err)
+		query := parsed.Query()
+		require.Equal(t, "code", query.Get("response_type"))
+		require.Equal(t, "S256", query.Get("code_challenge_method"))
+		require.NotEmpty(t, query.Get("code_challenge"))
+		*nonce = query.Get("nonce")
+
+		go func() {
+			callback := query.Get("redirect_uri") + "?" + url.Values{
+				"state": {query.Get("state")},
+				"code":  {code},
+			}.Encode()
+			resp, err := http.Get(callback)
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		return nil
+	}
+}
+
+func TestLoginCmdBrowserFlow(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("OPS_HOME", tmpDir)
+	t.Setenv("SSO_ENABLED", "true")
+	t.Setenv("SSO_OIDC_AUDIENCE", "openserverless-admin-api")
+	t.Setenv("OPS_SSO_DISABLE_BROWSER", "")
+	t.Setenv("OPS_PASSWORD", "")
+	t.Setenv("OPS_USER", "")
+	t.Setenv("OPS_APIHOST", "")
+
+	idp := newTestIdP(t)
+	var nonce string
+	oldOpenBrowser := openBrowser
+	openBrowser = completeBrowserLogin(t, "auth-code", &nonce)
+	defer func() { openBrowser = oldOpenBrowser }()
+
+	var mockServer *httptest.Server
+	mockServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/realms/lab/.well-known/openid-configuration":
+			_, _ = w.Write([]byte(fmt.Sprintf(`{
+				"issuer": "%s/realms/lab",
+				"jwks_uri": "%s/realms/lab/protocol/openid-connect/certs",
+				"authorization_endpoint": "%s/realms/lab/protocol/openid-connect/auth",
+				"token_endpoint": "%s/realms/lab/protocol/openid-connect/token"
+			}`, mockServer.URL, mockServer.URL, mockServer.URL, mockServer.URL)))
+		case "/realms/lab/protocol/openid-connect/certs":
+			_, _ = w.Write(idp.jwks())
+		case "/realms/lab/protocol/openid-connect/token":
+			require.NoError(t, r.ParseForm())
+			require.Equal(t, "authorization_code", r.Form.Get("grant_type"))
+			require.Equal(t, "openserverless-admin-api", r.Form.Get("client_id"))
+			require.Equal(t, "auth-code", r.Form.Get("code"))
+			require.Contains(t, r.Form.Get("redirect_uri"), "http://127.0.0.1:")
+			require.NotEmpty(t, r.Form.Get("code_verifier"))
+			token, _ := json.Marshal(map[string]string{
+				"access_token": "browser-access-token",
+				"id_token":     idp.sign(t, idp.claims(mockServer.URL+"/realms/lab", map[string]interface{}{"nonce": nonce})),
+			})
+			_, _ = w.Write(token)
+		case "/system/api/v1/auth/oidc":
+			require.Equal(t, "Bearer browser-access-token", r.Header.Get("Authorization"))
+			_, _ = w.Write([]byte(`{"AUTH":"oidc-auth","NAMESPACE":"michelem"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer mockServer.Close()
+
+	t.Setenv("SSO_OIDC_ISSUER_URL", mockServer.URL+"/realms/lab")
+
+	os.Args = []string{"login", "--sso-flow", "browser", mockServer.URL}
+	loginResult, err := LoginCmd()
+	require.NoError(t, err)
+	require.NotNil(t, loginResult)
+	require.Equal(t, "michelem", loginResult.Login)
+	require.Equal(t, "oidc-auth", loginResult.Auth)
+}
+
+func Test_oidcCallbackHandler(t *testing.T) {
+	t.Run("rejects wrong state without completing the flow", func(t *testing.T) {
+		results := make(chan oidcCallbackResult, 1)
+		handler := oidcCallbackHandler("expected", results)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/callback?state=other&code=x", nil))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		require.Empty(t, results)
+	})
+
+	t.Run("reports provider errors", func(t *testing.T) {
+		results := make(chan oidcCallbackResult, 1)
+		handler := oidcCallbackHandler("expected", results)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/callback?state=expected&error=access_denied", nil))
+		result := <-results
+		require.Error(t, result.err)
+		require.Contains(t, result.err.Error(), "access_denied")
+	})
+
+	t.Run("returns the authorization code", func(t *testing.T) {
+		results := make(chan oidcCallbackResult, 1)
+		handler := oidcCallbackHandler("expected", results)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/callback?state=expected&code=abc", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		result := <-results
+		require.NoError(t, result.err)
+		require.Equal(t, "abc", result.code)
+	})
+}
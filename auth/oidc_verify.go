@@ -0,0 +1,402 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// oidcClockSkew is the tolerance applied to exp and nbf.
+const oidcClockSkew = 60 * time.Second
+
+var oidcNow = time.Now
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// oidcTokenVerifier checks tokens against the identity provider keys and the
+// SSO_* settings written by `ops -config sso`.
+type oidcTokenVerifier struct {
+	issuer        string
+	audience      string
+	clientID      string
+	usernameClaim string
+	groupsClaim   string
+	requiredGroup string
+	keys          []jsonWebKey
+}
+
+// checkDiscoveryIssuer rejects a discovery document without an issuer or
+// of another issuer: the document must not choose the issuer it is trusted
+// for.
+func checkDiscoveryIssuer(discovery *oidcDiscovery, issuer string) error {
+	if discovery.Issuer == "" {
+		return errors.New("OIDC discovery document has no issuer")
+	}
+	if strings.TrimRight(discovery.Issuer, "/") != strings.TrimRight(issuer, "/") {
+		return fmt.Errorf("OIDC discovery issuer %s does not match the configured issuer %s", discovery.Issuer, issuer)
+	}
+	return nil
+}
+
+func newOIDCTokenVerifier(issuer, clientID string, discovery *oidcDiscovery) (*oidcTokenVerifier, error) {
+	issuer = strings.TrimRight(issuer, "/")
+	if err := checkDiscoveryIssuer(discovery, issuer); err != nil {
+		return nil, err
+	}
+	jwksURL := firstNonEmpty(os.Getenv("SSO_OIDC_JWKS_URL"), os.Getenv("OIDC_JWKS_URL"), discovery.JWKSURI)
+	if jwksURL == "" {
+		return nil, errors.New("OIDC provider does not expose jwks_uri and SSO_OIDC_JWKS_URL is not configured")
+	}
+	keys, err := fetchJWKS(jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	return &oidcTokenVerifier{
+		issuer:        issuer,
+		audience:      firstNonEmpty(os.Getenv("SSO_OIDC_AUDIENCE"), os.Getenv("OIDC_AUDIENCE"), clientID),
+		clientID:      clientID,
+		usernameClaim: firstNonEmpty(os.Getenv("SSO_OIDC_USERNAME_CLAIM"), "preferred_username"),
+		groupsClaim:   firstNonEmpty(os.Getenv("SSO_OIDC_GROUPS_CLAIM"), "groups"),
+		requiredGroup: firstNonEmpty(os.Getenv("SSO_OIDC_REQUIRED_GROUP"), os.Getenv("OIDC_REQUIRED_GROUP")),
+		keys:          keys,
+	}, nil
+}
+
+// verifiedOIDCAccessToken validates the token response of a login flow and
+// returns the access token to hand over to the OpenServerless backend.
+// An opaque access token is accepted only together with a valid ID token.
+func verifiedOIDCAccessToken(issuer, clientID string, discovery *oidcDiscovery, token *oidcTokenResponse, nonce string) (string, error) {
+	verifier, err := newOIDCTokenVerifier(issuer, clientID, discovery)
+	if err != nil {
+		return "", err
+	}
+
+	var accessClaims, idClaims map[string]interface{}
+	if isJWT(token.AccessToken) {
+		accessClaims, err = verifier.verify(token.AccessToken, "access token")
+		if err != nil {
+			return "", err
+		}
+		if !claimHasAudience(accessClaims, verifier.audience) && claimString(accessClaims, "azp") != verifier.clientID {
+			return "", fmt.Errorf("OIDC access token audience does not include %q", verifier.audience)
+		}
+	}
+	if token.IDToken != "" {
+		idClaims, err = verifier.verify(token.IDToken, "id_token")
+		if err != nil {
+			return "", err
+		}
+		if !claimHasAudience(idClaims, verifier.clientID) {
+			return "", fmt.Errorf("OIDC id_token audience does not include %q", verifier.clientID)
+		}
+		if nonce != "" && claimString(idClaims, "nonce") != nonce {
+			return "", errors.New("OIDC id_token nonce does not match the login request")
+		}
+	} else if nonce != "" {
+		return "", errors.New("OIDC token response missing id_token")
+	}
+	if accessClaims == nil && idClaims == nil {
+		return "", errors.New("OIDC token response contains no verifiable token")
+	}
+
+	if err := verifier.checkGroup(accessClaims, idClaims); err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+func (v *oidcTokenVerifier) verify(token, kind string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed OIDC %s", kind)
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed OIDC %s header", kind)
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("malformed OIDC %s header", kind)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed OIDC %s signature", kind)
+	}
+
+	key, err := v.keyFor(header)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC %s: %w", kind, err)
+	}
+	if err := verifyJWTSignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, fmt.Errorf("OIDC %s signature verification failed: %w", kind, err)
+	}
+
+	claims, err := decodeJWTClaims(token)
+	if err != nil {
+		return nil, err
+	}
+	if iss := strings.TrimRight(claimString(claims, "iss"), "/"); iss != v.issuer {
+		return nil, fmt.Errorf("OIDC %s issuer %q does not match %q", kind, iss, v.issuer)
+	}
+	now := oidcNow()
+	exp, ok := claimTime(claims, "exp")
+	if !ok {
+		return nil, fmt.Errorf("OIDC %s has no expiry", kind)
+	}
+	if now.After(exp.Add(oidcClockSkew)) {
+		return nil, fmt.Errorf("OIDC %s expired at %s", kind, exp.Format(time.RFC3339))
+	}
+	if nbf, ok := claimTime(claims, "nbf"); ok && now.Add(oidcClockSkew).Before(nbf) {
+		return nil, fmt.Errorf("OIDC %s is not valid before %s", kind, nbf.Format(time.RFC3339))
+	}
+	return claims, nil
+}
+
+func (v *oidcTokenVerifier) keyFor(header jwtHeader) (crypto.PublicKey, error) {
+	if header.Alg == "" || strings.EqualFold(header.Alg, "none") {
+		return nil, errors.New("unsigned tokens are not accepted")
+	}
+	var candidates []jsonWebKey
+	for _, key := range v.keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		if header.Kid != "" && key.Kid != header.Kid {
+			continue
+		}
+		candidates = append(candidates, key)
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no signing key with kid %q in the provider JWKS", header.Kid)
+	}
+	if header.Kid == "" && len(candidates) > 1 {
+		return nil, errors.New("token has no kid and the provider JWKS has several signing keys")
+	}
+	return candidates[0].publicKey()
+}
+
+// checkGroup enforces SSO_OIDC_REQUIRED_GROUP, looking for the groups claim
+// in the access token first and in the ID token otherwise.
+func (v *oidcTokenVerifier) checkGroup(accessClaims, idClaims map[string]interface{}) error {
+	if v.requiredGroup == "" {
+		return nil
+	}
+	claims := accessClaims
+	if _, ok := claims[v.groupsClaim]; !ok {
+		claims = idClaims
+	}
+	username := firstNonEmpty(claimString(claims, v.usernameClaim), claimString(claims, "sub"))
+
+	groups, ok := claimStrings(claims, v.groupsClaim)
+	if !ok {
+		return fmt.Errorf("SSO login rejected: the token for %q has no %q claim; configure the identity provider to include group membership", username, v.groupsClaim)
+	}
+	for _, group := range groups {
+		if group == v.requiredGroup || strings.TrimPrefix(group, "/") == strings.TrimPrefix(v.requiredGroup, "/") {
+			return nil
+		}
+	}
+	return fmt.Errorf("SSO login rejected: user %q is not a member of the required group %q (claim %q)", username, v.requiredGroup, v.groupsClaim)
+}
+
+func fetchJWKS(jwksURL string) ([]jsonWebKey, error) {
+	resp, err := authClient.get(jwksURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OIDC JWKS fetch failed (%d): %s", resp.StatusCode, string(resp.Body))
+	}
+
+	var keySet jsonWebKeySet
+	if err := json.Unmarshal(resp.Body, &keySet); err != nil {
+		return nil, errors.New("failed to decode OIDC JWKS response")
+	}
+	if len(keySet.Keys) == 0 {
+		return nil, errors.New("OIDC JWKS contains no keys")
+	}
+	return keySet.Keys, nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus for key %q", k.Kid)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA exponent for key %q", k.Kid)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported EC curve %q for key %q", k.Crv, k.Kid)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC point for key %q", k.Kid)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC point for key %q", k.Kid)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q for key %q", k.Kty, k.Kid)
+	}
+}
+
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	var hash crypto.Hash
+	var curve elliptic.Curve
+	switch alg[2:] {
+	case "256":
+		hash, curve = crypto.SHA256, elliptic.P256()
+	case "384":
+		hash, curve = crypto.SHA384, elliptic.P384()
+	case "512":
+		hash, curve = crypto.SHA512, elliptic.P521()
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	hasher := hash.New()
+	hasher.Write(signed)
+	digest := hasher.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s requires an RSA key", alg)
+		}
+		if strings.HasPrefix(alg, "PS") {
+			return rsa.VerifyPSS(rsaKey, hash, digest, signature, nil)
+		}
+		return rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature)
+	case strings.HasPrefix(alg, "ES"):
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s requires an EC key", alg)
+		}
+		// ES256 is P-256, ES384 P-384 and ES512 P-521
+		if ecKey.Curve != curve {
+			return fmt.Errorf("algorithm %s requires a %s key", alg, curve.Params().Name)
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid ECDSA signature length")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+}
+
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+func claimString(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+func claimStrings(claims map[string]interface{}, name string) ([]string, bool) {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}, true
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values, true
+	default:
+		return nil, false
+	}
+}
+
+func claimHasAudience(claims map[string]interface{}, audience string) bool {
+	values, _ := claimStrings(claims, "aud")
+	for _, value := range values {
+		if value == audience {
+			return true
+		}
+	}
+	return false
+}
+
+func claimTime(claims map[string]interface{}, name string) (time.Time, bool) {
+	value, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(value), 0), true
+}
@@ -0,0 +1,220 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testIdP signs tokens with a throwaway RSA key and serves the matching JWKS.
+type testIdP struct {
+	key *rsa.PrivateKey
+	kid string
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return &testIdP{key: key, kid: "test-key"}
+}
+
+func (idp *testIdP) jwks() []byte {
+	body, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": idp.kid,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+		}},
+	})
+	return body
+}
+
+func (idp *testIdP) sign(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": idp.kid, "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (idp *testIdP) claims(issuer string, extra map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{
+		"iss":                issuer,
+		"aud":                "openserverless-admin-api",
+		"sub":                "1234",
+		"preferred_username": "michelem",
+		"exp":                time.Now().Add(5 * time.Minute).Unix(),
+		"groups":             []string{"/openserverless-users"},
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	return claims
+}
+
+func newTestVerifier(t *testing.T, idp *testIdP, issuer string) (*httptest.Server, *oidcDiscovery) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(idp.jwks())
+	}))
+	t.Cleanup(server.Close)
+	return server, &oidcDiscovery{Issuer: issuer, JWKSURI: server.URL}
+}
+
+func Test_verifiedOIDCAccessToken(t *testing.T) {
+	const issuer = "https://idp.example.test/realms/lab"
+	idp := newTestIdP(t)
+	_, discovery := newTestVerifier(t, idp, issuer)
+
+	t.Setenv("SSO_OIDC_AUDIENCE", "openserverless-admin-api")
+	t.Setenv("SSO_OIDC_JWKS_URL", "")
+	t.Setenv("SSO_OIDC_GROUPS_CLAIM", "")
+	t.Setenv("SSO_OIDC_USERNAME_CLAIM", "")
+	t.Setenv("SSO_OIDC_REQUIRED_GROUP", "openserverless-users")
+
+	t.Run("accepts a valid access token", func(t *testing.T) {
+		access := idp.sign(t, idp.claims(issuer, nil))
+		token, err := verifiedOIDCAccessToken(issuer, "openserverless-admin-api", discovery, &oidcTokenResponse{AccessToken: access}, "")
+		require.NoError(t, err)
+		require.Equal(t, access, token)
+	})
+
+	t.Run("accepts an opaque access token with a valid id_token", func(t *testing.T) {
+		id := idp.sign(t, idp.claims(issuer, map[string]interface{}{"nonce": "n1"}))
+		token, err := verifiedOIDCAccessToken(issuer, "openserverless-admin-api", discovery, &oidcTokenResponse{AccessToken: "opaque", IDToken: id}, "n1")
+		require.NoError(t, err)
+		require.Equal(t, "opaque", token)
+	})
+
+	t.Run("rejects a nonce mismatch", func(t *testing.T) {
+		id := idp.sign(t, idp.claims(issuer, map[string]interface{}{"nonce": "other"}))
+		_, err := verifiedOIDCAccessToken(issuer, "openserverless-admin-api", discovery, &oidcTokenResponse{AccessToken: "opaque", IDToken: id}, "n1")
+		require.ErrorContains(t, err, "nonce")
+	})
+
+	t.Run("rejects a token signed by another key", func(t *testing.T) {
+		access := newTestIdP(t).sign(t, idp.claims(issuer, nil))
+		_, err := verifiedOIDCAccessToken(issuer, "openserverless-admin-api", discovery, &oidcTokenResponse{AccessToken: access}, "")
+		require.ErrorContains(t, err, "signature verification failed")
+	})
+
+	t.Run("rejects a foreign issuer", func(t *testing.T) {
+		access := idp.sign(t, idp.claims("https://evil.example.test", nil))
+		_, err := verifiedOIDCAccessToken(issuer, "openserverless-admin-api", discovery, &oidcTokenResponse{AccessToken: access}, "")
+		require.ErrorContains(t, err, "issuer")
+	})
+
+	t.Run("rejects a wrong audience", func(t *testing.T) {
+		access := idp.sign(t, idp.claims(issuer, map[string]interface{}{"aud": "other", "azp": "other"}))
+		_, err := verifiedOIDCAccessToken(issuer, "openserverless-admin-api", discovery, &oidcTokenResponse{AccessToken: access}, "")
+		require.ErrorContains(t, err, "audience")
+	})
+
+	t.Run("rejects an expired token", func(t *testing.T) {
+		access := idp.sign(t, idp.claims(issuer, map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}))
+		_, err := verifiedOIDCAccessToken(issuer, "openserverless-admin-api", discovery, &oidcTokenResponse{AccessToken: access}, "")
+		require.ErrorContains(t, err, "expired")
+	})
+
+	t.Run("reports the missing group membership", func(t *testing.T) {
+		access := idp.sign(t, idp.claims(issuer, map[string]interface{}{"groups": []string{"/other"}}))
+		_, err := verifiedOIDCAccessToken(issuer, "openserverless-admin-api", discovery, &oidcTokenResponse{AccessToken: access}, "")
+		require.EqualError(t, err, `SSO login rejected: user "michelem" is not a member of the required group "openserverless-users" (claim "groups")`)
+	})
+
+	t.Run("rejects a discovery document of another issuer", func(t *testing.T) {
+		tampered := *discovery
+		tampered.Issuer = "https://evil.example.test"
+		access := idp.sign(t, idp.claims("https://evil.example.test", nil))
+		_, err := verifiedOIDCAccessToken(issuer+"/", "openserverless-admin-api", &tampered, &oidcTokenResponse{AccessToken: access}, "")
+		require.ErrorContains(t, err, "does not match the configured issuer")
+	})
+
+	t.Run("rejects a discovery document without an issuer", func(t *testing.T) {
+		tampered := *discovery
+		tampered.Issuer = ""
+		access := idp.sign(t, idp.claims(issuer, nil))
+		_, err := verifiedOIDCAccessToken(issuer, "openserverless-admin-api", &tampered, &oidcTokenResponse{AccessToken: access}, "")
+		require.ErrorContains(t, err, "OIDC discovery document has no issuer")
+	})
+
+	t.Run("rejects unsigned tokens", func(t *testing.T) {
+		_, err := verifiedOIDCAccessToken(issuer, "openserverless-admin-api", discovery, &oidcTokenResponse{AccessToken: fakeJWT(t, idp.claims(issuer, nil))}, "")
+		require.ErrorContains(t, err, "unsigned")
+	})
+}
+
+func Test_verifyJWTSignatureECDSA(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signed := []byte("header.payload")
+	digest := sha256.Sum256(signed)
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	require.NoError(t, err)
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	jwk := jsonWebKey{
+		Kty: "EC",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+		Y:   base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+	}
+	publicKey, err := jwk.publicKey()
+	require.NoError(t, err)
+	require.NoError(t, verifyJWTSignature("ES256", publicKey, signed, signature))
+	require.Error(t, verifyJWTSignature("ES256", publicKey, []byte("tampered"), signature))
+	require.Error(t, verifyJWTSignature("HS256", publicKey, signed, signature))
+	require.EqualError(t, verifyJWTSignature("ES256", publicKey, signed, signature[1:]), "invalid ECDSA signature length")
+	require.EqualError(t, verifyJWTSignature("ES256", publicKey, signed, append(signature, 0, 0)), "invalid ECDSA signature length")
+
+	// the curve must be the one of the algorithm
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	r, s, err = ecdsa.Sign(rand.Reader, p384, digest[:])
+	require.NoError(t, err)
+	signature = make([]byte, 96)
+	r.FillBytes(signature[:48])
+	s.FillBytes(signature[48:])
+	require.True(t, ecdsa.Verify(&p384.PublicKey, digest[:], r, s))
+	require.EqualError(t, verifyJWTSignature("ES256", &p384.PublicKey, signed, signature), "algorithm ES256 requires a P-256 key")
+}
@@ -0,0 +1,517 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const kubeFieldManager = "ops-sso"
+
+// kubeClient is a minimal Kubernetes REST client covering what the SSO tool
+// needs: get, server-side apply, JSON patch, delete and watch.
+type kubeClient struct {
+	server string
+	client *http.Client
+	token  string
+	user   string
+	pass   string
+}
+
+type kubeConfigFile struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string      `yaml:"name"`
+		Cluster kubeCluster `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string   `yaml:"name"`
+		User kubeUser `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+type kubeCluster struct {
+	Server                   string `yaml:"server"`
+	CertificateAuthority     string `yaml:"certificate-authority"`
+	CertificateAuthorityData string `yaml:"certificate-authority-data"`
+	InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+	TLSServerName            string `yaml:"tls-server-name"`
+	ProxyURL                 string `yaml:"proxy-url"`
+}
+
+type kubeUser struct {
+	Token                 string `yaml:"token"`
+	TokenFile             string `yaml:"tokenFile"`
+	ClientCertificate     string `yaml:"client-certificate"`
+	ClientCertificateData string `yaml:"client-certificate-data"`
+	ClientKey             string `yaml:"client-key"`
+	ClientKeyData         string `yaml:"client-key-data"`
+	Username              string `yaml:"username"`
+	Password              string `yaml:"password"`
+	Exec                  *struct {
+		Command string   `yaml:"command"`
+		Args    []string `yaml:"args"`
+		Env     []struct {
+			Name  string `yaml:"name"`
+			Value string `yaml:"value"`
+		} `yaml:"env"`
+	} `yaml:"exec"`
+}
+
+// newKubeClient is replaced in tests to point at a fake API server.
+var newKubeClient = loadKubeClient
+
+// loadKubeClient builds a client for kubeContext (the current context when
+// empty) from $KUBECONFIG or ~/.kube/config, falling back to the in-cluster
+// service account.
+func loadKubeClient(kubeContext string) (*kubeClient, error) {
+	paths := kubeConfigPaths()
+	if len(paths) == 0 {
+		if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+			return inClusterKubeClient()
+		}
+		return nil, fmt.Errorf("no kubeconfig found: set KUBECONFIG or create ~/.kube/config")
+	}
+
+	var clusterName, userName string
+	var cluster *kubeCluster
+	var user *kubeUser
+	var clusterDir, userDir string
+	files := make([]kubeConfigFile, len(paths))
+	for index, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, &files[index]); err != nil {
+			return nil, fmt.Errorf("invalid kubeconfig %s: %w", path, err)
+		}
+		if kubeContext == "" {
+			kubeContext = files[index].CurrentContext
+		}
+	}
+	if kubeContext == "" {
+		return nil, fmt.Errorf("no current context in kubeconfig, use --context")
+	}
+
+	// like kubectl, the first file defining a name wins
+	for _, file := range files {
+		for _, entry := range file.Contexts {
+			if entry.Name == kubeContext && clusterName == "" {
+				clusterName = entry.Context.Cluster
+				userName = entry.Context.User
+			}
+		}
+	}
+	if clusterName == "" {
+		return nil, fmt.Errorf("context %q not found in kubeconfig", kubeContext)
+	}
+	for index, file := range files {
+		for _, entry := range file.Clusters {
+			if entry.Name == clusterName && cluster == nil {
+				entryCluster := entry.Cluster
+				cluster = &entryCluster
+				clusterDir = filepath.Dir(paths[index])
+			}
+		}
+		for _, entry := range file.Users {
+			if entry.Name == userName && user == nil {
+				entryUser := entry.User
+				user = &entryUser
+				userDir = filepath.Dir(paths[index])
+			}
+		}
+	}
+	if cluster == nil {
+		return nil, fmt.Errorf("cluster %q of context %q not found in kubeconfig", clusterName, kubeContext)
+	}
+	if user == nil {
+		user = &kubeUser{}
+	}
+	return newKubeClientFromConfig(cluster, clusterDir, user, userDir)
+}
+
+func kubeConfigPaths() []string {
+	paths := make([]string, 0)
+	if env := os.Getenv("KUBECONFIG"); env != "" {
+		for _, path := range filepath.SplitList(env) {
+			if _, err := os.Stat(path); err == nil {
+				paths = append(paths, path)
+			}
+		}
+		return paths
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		path := filepath.Join(home, ".kube", "config")
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func inClusterKubeClient() (*kubeClient, error) {
+	const dir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	server := "https://" + os.Getenv("KUBERNETES_SERVICE_HOST") + ":" + os.Getenv("KUBERNETES_SERVICE_PORT")
+	return newKubeClientFromConfig(
+		&kubeCluster{Server: server, CertificateAuthority: filepath.Join(dir, "ca.crt")}, dir,
+		&kubeUser{TokenFile: filepath.Join(dir, "token")}, dir,
+	)
+}
+
+// readKubeData returns inline base64 data, or the file content otherwise.
+func readKubeData(data, file, dir string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if file == "" {
+		return nil, nil
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	return os.ReadFile(file)
+}
+
+func newKubeClientFromConfig(cluster *kubeCluster, clusterDir string, user *kubeUser, userDir string) (*kubeClient, error) {
+	if cluster.Server == "" {
+		return nil, fmt.Errorf("kubeconfig cluster has no server")
+	}
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cluster.InsecureSkipTLSVerify,
+		ServerName:         cluster.TLSServerName,
+	}
+	ca, err := readKubeData(cluster.CertificateAuthorityData, cluster.CertificateAuthority, clusterDir)
+	if err != nil {
+		return nil, fmt.Errorf("kubeconfig certificate authority: %w", err)
+	}
+	if ca != nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("kubeconfig certificate authority contains no PEM certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	kube := &kubeClient{server: strings.TrimRight(cluster.Server, "/"), token: user.Token, user: user.Username, pass: user.Password}
+	if user.TokenFile != "" {
+		token, err := readKubeData("", user.TokenFile, userDir)
+		if err != nil {
+			return nil, fmt.Errorf("kubeconfig token file: %w", err)
+		}
+		kube.token = strings.TrimSpace(string(token))
+	}
+
+	certPEM, err := readKubeData(user.ClientCertificateData, user.ClientCertificate, userDir)
+	if err != nil {
+		return nil, fmt.Errorf("kubeconfig client certificate: %w", err)
+	}
+	keyPEM, err := readKubeData(user.ClientKeyData, user.ClientKey, userDir)
+	if err != nil {
+		return nil, fmt.Errorf("kubeconfig client key: %w", err)
+	}
+	if user.Exec != nil {
+		credential, err := runKubeExecCredential(user)
+		if err != nil {
+			return nil, err
+		}
+		if credential.Token != "" {
+			kube.token = credential.Token
+		}
+		if credential.ClientCertificateData != "" {
+			certPEM = []byte(credential.ClientCertificateData)
+			keyPEM = []byte(credential.ClientKeyData)
+		}
+	}
+	if certPEM != nil {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("kubeconfig client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if cluster.ProxyURL != "" {
+		proxyURL, err := url.Parse(cluster.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid kubeconfig proxy-url: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	kube.client = &http.Client{Transport: transport}
+	return kube, nil
+}
+
+type kubeExecCredentialStatus struct {
+	Token                 string `json:"token"`
+	ClientCertificateData string `json:"clientCertificateData"`
+	ClientKeyData         string `json:"clientKeyData"`
+}
+
+// runKubeExecCredential runs a client-go credential plugin (e.g. the cloud
+// provider CLIs) and returns the ExecCredential status it prints.
+func runKubeExecCredential(user *kubeUser) (*kubeExecCredentialStatus, error) {
+	cmd := exec.Command(user.Exec.Command, user.Exec.Args...)
+	cmd.Env = os.Environ()
+	for _, env := range user.Exec.Env {
+		cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
+	}
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("kubeconfig exec credential plugin %s failed: %w", user.Exec.Command, err)
+	}
+	var credential struct {
+		Status kubeExecCredentialStatus `json:"status"`
+	}
+	if err := json.Unmarshal(output, &credential); err != nil {
+		return nil, fmt.Errorf("kubeconfig exec credential plugin %s returned invalid output: %w", user.Exec.Command, err)
+	}
+	return &credential.Status, nil
+}
+
+func kubeCorePath(namespace, resource, name string) string {
+	return fmt.Sprintf("/api/v1/namespaces/%s/%s/%s", url.PathEscape(namespace), resource, url.PathEscape(name))
+}
+
+func kubeStatefulSetPath(namespace, name string) string {
+	return fmt.Sprintf("/apis/apps/v1/namespaces/%s/statefulsets/%s", url.PathEscape(namespace), url.PathEscape(name))
+}
+
+func (k *kubeClient) do(method, path, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, k.server+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	switch {
+	case k.token != "":
+		req.Header.Set("Authorization", "Bearer "+k.token)
+	case k.user != "":
+		req.SetBasicAuth(k.user, k.pass)
+	}
+	return k.client.Do(req)
+}
+
+// request performs a call and returns the body; a 404 returns nil when
+// allowMissing is set.
+func (k *kubeClient) request(method, path, contentType string, body []byte, allowMissing bool) ([]byte, error) {
+	resp, err := k.do(method, path, contentType, body)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s failed: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound && allowMissing {
+		return nil, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s %s failed (%d): %s", method, path, resp.StatusCode, kubeStatusMessage(data))
+	}
+	return data, nil
+}
+
+func kubeStatusMessage(data []byte) string {
+	var status struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(data, &status) == nil && status.Message != "" {
+		return status.Message
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// get returns nil when the object does not exist.
+func (k *kubeClient) get(path string) ([]byte, error) {
+	return k.request(http.MethodGet, path, "", nil, true)
+}
+
+// apply creates or updates obj with server-side apply, taking ownership of
+// the fields it sets.
+func (k *kubeClient) apply(path string, obj interface{}) error {
+	payload, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	query := url.Values{"fieldManager": {kubeFieldManager}, "force": {"true"}}
+	_, err = k.request(http.MethodPatch, path+"?"+query.Encode(), "application/apply-patch+yaml", payload, false)
+	return err
+}
+
+func (k *kubeClient) patch(path, contentType string, payload []byte) error {
+	_, err := k.request(http.MethodPatch, path, contentType, payload, false)
+	return err
+}
+
+// delete ignores objects that are already gone.
+func (k *kubeClient) delete(path string) error {
+	_, err := k.request(http.MethodDelete, path, "", nil, true)
+	return err
+}
+
+type kubeStatefulSetStatus struct {
+	Metadata struct {
+		Generation      int64  `json:"generation"`
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Spec struct {
+		Replicas *int32 `json:"replicas"`
+	} `json:"spec"`
+	Status struct {
+		ObservedGeneration int64  `json:"observedGeneration"`
+		Replicas           int32  `json:"replicas"`
+		ReadyReplicas      int32  `json:"readyReplicas"`
+		UpdatedReplicas    int32  `json:"updatedReplicas"`
+		CurrentRevision    string `json:"currentRevision"`
+		UpdateRevision     string `json:"updateRevision"`
+	} `json:"status"`
+}
+
+// rolledOut applies the same checks as kubectl rollout status.
+func (s kubeStatefulSetStatus) rolledOut() bool {
+	replicas := int32(1)
+	if s.Spec.Replicas != nil {
+		replicas = *s.Spec.Replicas
+	}
+	return s.Status.ObservedGeneration >= s.Metadata.Generation &&
+		s.Status.ReadyReplicas >= replicas &&
+		s.Status.UpdatedReplicas >= replicas &&
+		s.Status.CurrentRevision == s.Status.UpdateRevision
+}
+
+// restartStatefulSet triggers a rolling restart the way kubectl does, by
+// stamping the pod template.
+func (k *kubeClient) restartStatefulSet(namespace, name string) error {
+	patch := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{
+						"kubectl.kubernetes.io/restartedAt": time.Now().Format(time.RFC3339),
+					},
+				},
+			},
+		},
+	}
+	payload, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	return k.patch(kubeStatefulSetPath(namespace, name), "application/strategic-merge-patch+json", payload)
+}
+
+// waitForStatefulSetRollout watches the StatefulSet until its rollout is
+// complete or timeout expires.
+func (k *kubeClient) waitForStatefulSetRollout(namespace, name string, timeout time.Duration) error {
+	data, err := k.get(kubeStatefulSetPath(namespace, name))
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return fmt.Errorf("statefulset %s/%s not found", namespace, name)
+	}
+	var current kubeStatefulSetStatus
+	if err := json.Unmarshal(data, &current); err != nil {
+		return fmt.Errorf("decode statefulset %s/%s: %w", namespace, name, err)
+	}
+	if current.rolledOut() {
+		return nil
+	}
+
+	fmt.Printf("Waiting for statefulset %s/%s rollout...\n", namespace, name)
+	query := url.Values{
+		"watch":           {"true"},
+		"fieldSelector":   {"metadata.name=" + name},
+		"resourceVersion": {current.Metadata.ResourceVersion},
+		"timeoutSeconds":  {strconv.Itoa(int(timeout.Seconds()))},
+	}
+	path := fmt.Sprintf("/apis/apps/v1/namespaces/%s/statefulsets?%s", url.PathEscape(namespace), query.Encode())
+	resp, err := k.do(http.MethodGet, path, "", nil)
+	if err != nil {
+		return fmt.Errorf("watch statefulset %s/%s: %w", namespace, name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("watch statefulset %s/%s failed (%d): %s", namespace, name, resp.StatusCode, kubeStatusMessage(body))
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+		for scanner.Scan() {
+			var event struct {
+				Type   string                `json:"type"`
+				Object kubeStatefulSetStatus `json:"object"`
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				done <- fmt.Errorf("decode watch event: %w", err)
+				return
+			}
+			if event.Type == "DELETED" {
+				done <- fmt.Errorf("statefulset %s/%s was deleted during rollout", namespace, name)
+				return
+			}
+			if event.Object.rolledOut() {
+				done <- nil
+				return
+			}
+		}
+		done <- fmt.Errorf("statefulset %s/%s rollout did not complete within %s", namespace, name, timeout)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("statefulset %s/%s rollout did not complete within %s", namespace, name, timeout)
+	}
+}
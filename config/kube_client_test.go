@@ -0,0 +1,255 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	fakeStatefulSetPath = "/apis/apps/v1/namespaces/nuvolaris/statefulsets/nuvolaris-system-api"
+	fakeConfigMapPath   = "/api/v1/namespaces/nuvolaris/configmaps/openserverless-sso-config"
+	fakeSecretPath      = "/api/v1/namespaces/nuvolaris/secrets/openserverless-sso-secret"
+)
+
+// fakeKubeAPI is a minimal API server holding the admin-api StatefulSet and
+// the objects written by the SSO tool.
+type fakeKubeAPI struct {
+	mu       sync.Mutex
+	workload *fakeSSOWorkloadState
+	objects  map[string]map[string]interface{}
+	requests []string
+	patches  []string
+	restarts int
+	// rolloutPending makes the StatefulSet report an unfinished rollout
+	// until the next watch event.
+	rolloutPending bool
+}
+
+// startFakeKubeAPI starts the server and points newKubeClient at it until
+// the returned function is called.
+func startFakeKubeAPI(workload *fakeSSOWorkloadState) (*fakeKubeAPI, func()) {
+	api := &fakeKubeAPI{workload: workload, objects: map[string]map[string]interface{}{}}
+	server := httptest.NewServer(api)
+	oldClient := newKubeClient
+	newKubeClient = func(string) (*kubeClient, error) {
+		return &kubeClient{server: server.URL, client: server.Client()}, nil
+	}
+	return api, func() {
+		newKubeClient = oldClient
+		server.Close()
+	}
+}
+
+func newFakeKubeAPI(t *testing.T, workload *fakeSSOWorkloadState) *fakeKubeAPI {
+	t.Helper()
+	api, stop := startFakeKubeAPI(workload)
+	t.Cleanup(stop)
+	return api
+}
+
+func (api *fakeKubeAPI) seed(path string, data map[string]string) {
+	api.objects[path] = map[string]interface{}{"data": data}
+}
+
+func (api *fakeKubeAPI) calls() []string {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return append([]string(nil), api.requests...)
+}
+
+func (api *fakeKubeAPI) object(path string) map[string]interface{} {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return api.objects[path]
+}
+
+func (api *fakeKubeAPI) statefulSetJSON(pending bool) []byte {
+	var workload map[string]interface{}
+	_ = json.Unmarshal(api.workload.workloadJSON(), &workload)
+	observed := 1
+	if pending {
+		observed = 0
+	}
+	workload["metadata"] = map[string]interface{}{"generation": 1, "resourceVersion": "42"}
+	workload["status"] = map[string]interface{}{
+		"observedGeneration": observed,
+		"readyReplicas":      1,
+		"updatedReplicas":    1,
+		"currentRevision":    "r1",
+		"updateRevision":     "r1",
+	}
+	payload, _ := json.Marshal(workload)
+	return payload
+}
+
+func (api *fakeKubeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.requests = append(api.requests, r.Method+" "+r.URL.Path)
+	body, _ := io.ReadAll(r.Body)
+
+	switch {
+	case r.URL.Path == "/apis/apps/v1/namespaces/nuvolaris/statefulsets" && r.URL.Query().Get("watch") == "true":
+		api.rolloutPending = false
+		_, _ = fmt.Fprintf(w, "{\"type\":\"MODIFIED\",\"object\":%s}\n", api.statefulSetJSON(false))
+	case r.URL.Path == fakeStatefulSetPath && r.Method == http.MethodGet:
+		_, _ = w.Write(api.statefulSetJSON(api.rolloutPending))
+	case r.URL.Path == fakeStatefulSetPath && r.Method == http.MethodPatch:
+		switch r.Header.Get("Content-Type") {
+		case "application/json-patch+json":
+			api.patches = append(api.patches, string(body))
+			if err := api.workload.applyJSONPatch(string(body)); err != nil {
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+		case "application/strategic-merge-patch+json":
+			if !strings.Contains(string(body), "kubectl.kubernetes.io/restartedAt") {
+				http.Error(w, "unexpected strategic merge patch", http.StatusBadRequest)
+				return
+			}
+			api.restarts++
+		default:
+			http.Error(w, "unsupported patch", http.StatusUnsupportedMediaType)
+			return
+		}
+		_, _ = w.Write(api.statefulSetJSON(false))
+	case r.Method == http.MethodPatch:
+		var obj map[string]interface{}
+		if r.Header.Get("Content-Type") != "application/apply-patch+yaml" ||
+			r.URL.Query().Get("fieldManager") != kubeFieldManager ||
+			json.Unmarshal(body, &obj) != nil {
+			http.Error(w, "expected a server-side apply", http.StatusBadRequest)
+			return
+		}
+		// like the API server, keep stringData only as base64 data
+		if stringData, ok := obj["stringData"].(map[string]interface{}); ok {
+			data := map[string]interface{}{}
+			for key, value := range stringData {
+				data[key] = base64.StdEncoding.EncodeToString([]byte(value.(string)))
+			}
+			obj["data"] = data
+			delete(obj, "stringData")
+		}
+		api.objects[r.URL.Path] = obj
+		_ = json.NewEncoder(w).Encode(obj)
+	case r.Method == http.MethodGet || r.Method == http.MethodDelete:
+		obj, ok := api.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"kind":"Status","message":"not found"}`))
+			return
+		}
+		if r.Method == http.MethodDelete {
+			delete(api.objects, r.URL.Path)
+		}
+		_ = json.NewEncoder(w).Encode(obj)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func TestKubeClientWaitsForRolloutWithWatch(t *testing.T) {
+	api := newFakeKubeAPI(t, &fakeSSOWorkloadState{})
+	api.rolloutPending = true
+
+	kube, err := newKubeClient("")
+	require.NoError(t, err)
+	require.NoError(t, kube.waitForStatefulSetRollout("nuvolaris", "nuvolaris-system-api", time.Second))
+	require.Equal(t, []string{
+		"GET " + fakeStatefulSetPath,
+		"GET /apis/apps/v1/namespaces/nuvolaris/statefulsets",
+	}, api.calls())
+}
+
+func TestKubeClientReportsAPIErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"kind":"Status","message":"configmaps is forbidden"}`))
+	}))
+	defer server.Close()
+
+	kube := &kubeClient{server: server.URL, client: server.Client()}
+	err := kube.apply(kubeCorePath("nuvolaris", "configmaps", "x"), map[string]string{})
+	require.ErrorContains(t, err, "(403): configmaps is forbidden")
+}
+
+func TestLoadKubeClientFromKubeconfig(t *testing.T) {
+	var authorization string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	ca := base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "token"), []byte("file-token\n"), 0600))
+	kubeconfig := filepath.Join(dir, "config")
+	require.NoError(t, os.WriteFile(kubeconfig, []byte(strings.Join([]string{
+		"apiVersion: v1",
+		"kind: Config",
+		"current-context: dev",
+		"clusters:",
+		"- name: lab",
+		"  cluster:",
+		"    server: " + server.URL,
+		"    certificate-authority-data: " + ca,
+		"- name: unreachable",
+		"  cluster:",
+		"    server: https://127.0.0.1:1",
+		"users:",
+		"- name: admin",
+		"  user:",
+		"    tokenFile: token",
+		"contexts:",
+		"- name: dev",
+		"  context: {cluster: unreachable, user: admin}",
+		"- name: lab",
+		"  context: {cluster: lab, user: admin}",
+	}, "\n")), 0600))
+	t.Setenv("KUBECONFIG", kubeconfig)
+
+	kube, err := loadKubeClient("")
+	require.NoError(t, err)
+	require.Equal(t, "https://127.0.0.1:1", kube.server)
+
+	kube, err = loadKubeClient("lab")
+	require.NoError(t, err)
+	data, err := kube.get(kubeCorePath("nuvolaris", "configmaps", "x"))
+	require.NoError(t, err)
+	require.Equal(t, "{}", string(data))
+	require.Equal(t, "Bearer file-token", authorization)
+
+	_, err = loadKubeClient("missing")
+	require.ErrorContains(t, err, `context "missing" not found`)
+}
@@ -0,0 +1,443 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxSSOSnapshots is how many snapshots are kept; older ones are pruned.
+const maxSSOSnapshots = 10
+
+// ssoSnapshot is the state of the SSO resources before a change. Absent
+// resources are stored as nil so that rollback deletes them again.
+type ssoSnapshot struct {
+	ID            string             `json:"id"`
+	CreatedAt     time.Time          `json:"created_at"`
+	Reason        string             `json:"reason"`
+	Namespace     string             `json:"namespace"`
+	ConfigMapName string             `json:"configmap"`
+	SecretName    string             `json:"secret"`
+	WorkloadName  string             `json:"statefulset"`
+	ContainerName string             `json:"container"`
+	ConfigMap     map[string]string  `json:"configmap_data"`
+	Secret        map[string]string  `json:"secret_data"`
+	EnvFrom       []ssoEnvFromSource `json:"env_from"`
+	LocalConfig   map[string]string  `json:"local_config"`
+}
+
+// ssoBackupDir is OPS_HOME/sso-backups, OPS_HOME defaulting to the directory
+// of config.json.
+func ssoBackupDir(configMap ConfigMap) (string, error) {
+	home := os.Getenv("OPS_HOME")
+	if home == "" && configMap.configPath != "" {
+		home = filepath.Dir(configMap.configPath)
+	}
+	if home == "" {
+		return "", fmt.Errorf("OPS_HOME not defined")
+	}
+	return filepath.Join(home, "sso-backups"), nil
+}
+
+// snapshotSSO saves the live resources and the local SSO_* keys before they
+// are changed and returns the snapshot id.
+func snapshotSSO(configMap ConfigMap, kube *kubeClient, opts ssoOptions, reason string) (string, error) {
+	dir, err := ssoBackupDir(configMap)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	snapshot := ssoSnapshot{
+		ID:            now.Format("20060102-150405.000"),
+		CreatedAt:     now,
+		Reason:        reason,
+		Namespace:     opts.Namespace,
+		ConfigMapName: opts.ConfigMapName,
+		SecretName:    opts.SecretName,
+		WorkloadName:  opts.WorkloadName,
+		ContainerName: opts.ContainerName,
+		LocalConfig:   map[string]string{},
+	}
+	liveConfigMap, err := getSSOLiveResource(kube, opts, "configmap", opts.ConfigMapName)
+	if err != nil {
+		return "", err
+	}
+	if liveConfigMap != nil {
+		snapshot.ConfigMap = map[string]string{}
+		for key, value := range liveConfigMap.Data {
+			snapshot.ConfigMap[key] = value
+		}
+	}
+	liveSecret, err := getSSOLiveResource(kube, opts, "secret", opts.SecretName)
+	if err != nil {
+		return "", err
+	}
+	if liveSecret != nil {
+		snapshot.Secret = map[string]string{}
+		for key, value := range liveSecret.Data {
+			snapshot.Secret[key] = value
+		}
+	}
+	if _, snapshot.EnvFrom, err = getSSOContainerEnvFrom(kube, opts); err != nil {
+		return "", err
+	}
+	values := configMap.Flatten()
+	for _, key := range managedLocalSSOKeys {
+		if value, ok := values[key]; ok {
+			snapshot.LocalConfig[key] = value
+		}
+	}
+
+	payload, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	id := snapshot.ID
+	for attempt := 1; ; attempt++ {
+		// the snapshot holds the client secret
+		file, err := os.OpenFile(filepath.Join(dir, id+".json"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			id = fmt.Sprintf("%s-%d", snapshot.ID, attempt)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		_, err = file.Write(payload)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return "", err
+		}
+		break
+	}
+	pruneSSOSnapshots(dir)
+	return id, nil
+}
+
+func listSSOSnapshots(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if id, ok := strings.CutSuffix(entry.Name(), ".json"); ok && !entry.IsDir() {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func pruneSSOSnapshots(dir string) {
+	ids, err := listSSOSnapshots(dir)
+	if err != nil {
+		return
+	}
+	for len(ids) > maxSSOSnapshots {
+		_ = os.Remove(filepath.Join(dir, ids[0]+".json"))
+		ids = ids[1:]
+	}
+}
+
+func loadSSOSnapshot(dir, id string) (*ssoSnapshot, error) {
+	if id == "" {
+		ids, err := listSSOSnapshots(dir)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return nil, fmt.Errorf("no SSO snapshots in %s", dir)
+		}
+		id = ids[len(ids)-1]
+	}
+	data, err := os.ReadFile(filepath.Join(dir, filepath.Base(id)+".json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("SSO snapshot %s not found, see ops -config sso rollback --list", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snapshot ssoSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("invalid SSO snapshot %s: %w", id, err)
+	}
+	return &snapshot, nil
+}
+
+type ssoRollbackOptions struct {
+	To     string
+	List   bool
+	Verify bool
+	// SkipUnreachable restores without verifying when the IdP cannot be
+	// connected to, e.g. because the issuer is cluster-internal.
+	SkipUnreachable bool
+	NoRollout       bool
+	KubeContext     string
+}
+
+func parseSSORollbackArgs(args []string) (ssoRollbackOptions, error) {
+	var opts ssoRollbackOptions
+	flags := flag.NewFlagSet("sso rollback", flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	flags.StringVar(&opts.To, "to", "", "snapshot id, default the latest")
+	flags.BoolVar(&opts.List, "list", false, "list the snapshots")
+	flags.BoolVar(&opts.Verify, "verify", false, "check OIDC discovery and JWKS before restoring")
+	flags.BoolVar(&opts.SkipUnreachable, "skip-unreachable", false, "with --verify, do not stop when the IdP cannot be connected to")
+	flags.BoolVar(&opts.NoRollout, "no-rollout", false, "skip rollout restart/status")
+	flags.StringVar(&opts.KubeContext, "context", "", "kubeconfig context")
+
+	if err := flags.Parse(args); err != nil {
+		return opts, err
+	}
+	if flags.NArg() > 0 {
+		return opts, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+	return opts, nil
+}
+
+func rollbackSSO(configMap ConfigMap, args []string) error {
+	rollback, err := parseSSORollbackArgs(args)
+	if err != nil {
+		return err
+	}
+	dir, err := ssoBackupDir(configMap)
+	if err != nil {
+		return err
+	}
+	if rollback.List {
+		return printSSOSnapshots(dir)
+	}
+
+	snapshot, err := loadSSOSnapshot(dir, rollback.To)
+	if err != nil {
+		return err
+	}
+	opts := ssoOptions{
+		Namespace:     snapshot.Namespace,
+		ConfigMapName: snapshot.ConfigMapName,
+		SecretName:    snapshot.SecretName,
+		WorkloadName:  snapshot.WorkloadName,
+		ContainerName: snapshot.ContainerName,
+		KubeContext:   rollback.KubeContext,
+	}
+	if rollback.Verify {
+		issuer := snapshot.ConfigMap["OIDC_ISSUER_URL"]
+		if issuer == "" {
+			fmt.Println("Snapshot has SSO disabled, nothing to verify.")
+		} else if err := checkSSOEndpoints(issuer, snapshot.ConfigMap["OIDC_JWKS_URL"], rollback.SkipUnreachable); err != nil {
+			return fmt.Errorf("snapshot %s not restored: %w", snapshot.ID, err)
+		}
+	}
+
+	kube, err := newKubeClient(opts.KubeContext)
+	if err != nil {
+		return err
+	}
+	// keep the state being replaced, so the rollback can be undone too
+	current, err := snapshotSSO(configMap, kube, opts, "rollback to "+snapshot.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := restoreSSOResources(kube, opts, snapshot); err != nil {
+		return err
+	}
+	if err := restoreLocalSSOConfig(configMap, snapshot.LocalConfig); err != nil {
+		return err
+	}
+	if !rollback.NoRollout {
+		if err := rolloutSSOWorkload(kube, opts); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("SSO configuration restored from snapshot %s.\n", snapshot.ID)
+	fmt.Printf("The replaced configuration was saved as snapshot %s.\n", current)
+	return nil
+}
+
+func restoreSSOResources(kube *kubeClient, opts ssoOptions, snapshot *ssoSnapshot) error {
+	configMapPath := kubeCorePath(opts.Namespace, "configmaps", opts.ConfigMapName)
+	if snapshot.ConfigMap == nil {
+		if err := kube.delete(configMapPath); err != nil {
+			return err
+		}
+	} else if err := kube.apply(configMapPath, map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]string{"name": opts.ConfigMapName, "namespace": opts.Namespace},
+		"data":       snapshot.ConfigMap,
+	}); err != nil {
+		return err
+	}
+
+	secretPath := kubeCorePath(opts.Namespace, "secrets", opts.SecretName)
+	if snapshot.Secret == nil {
+		if err := kube.delete(secretPath); err != nil {
+			return err
+		}
+	} else if err := kube.apply(secretPath, map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   map[string]string{"name": opts.SecretName, "namespace": opts.Namespace},
+		"type":       "Opaque",
+		// already base64 encoded as read from the API
+		"data": snapshot.Secret,
+	}); err != nil {
+		return err
+	}
+
+	// only the managed references are restored, other envFrom entries may
+	// have changed since the snapshot
+	enabled := false
+	for _, source := range snapshot.EnvFrom {
+		key, managed := managedSSOEnvFromKey(source, opts)
+		switch {
+		case !managed:
+		case strings.HasPrefix(key, "configmap:"):
+			enabled = true
+		default:
+			opts.ClientSecret = configuredSecretPlaceholder
+		}
+	}
+	_, err := reconcileSSOEnvFrom(kube, opts, enabled)
+	return err
+}
+
+func restoreLocalSSOConfig(configMap ConfigMap, values map[string]string) error {
+	if err := removeLocalSSOConfig(configMap); err != nil {
+		return err
+	}
+	for key, value := range values {
+		if err := configMap.Insert(key, value); err != nil {
+			return err
+		}
+	}
+	return configMap.SaveConfig()
+}
+
+func printSSOSnapshots(dir string) error {
+	ids, err := listSSOSnapshots(dir)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		fmt.Println("No SSO snapshots.")
+		return nil
+	}
+	for _, id := range ids {
+		snapshot, err := loadSSOSnapshot(dir, id)
+		if err != nil {
+			return err
+		}
+		state := "sso disabled"
+		if issuer := snapshot.ConfigMap["OIDC_ISSUER_URL"]; issuer != "" {
+			state = "issuer " + issuer
+		}
+		fmt.Printf("%s  before %s (%s)\n", snapshot.ID, snapshot.Reason, state)
+	}
+	return nil
+}
+
+// errSSOUnreachable marks a verification that could not connect to the
+// IdP, because its name does not resolve or the connection is refused, as
+// opposed to an IdP answering with an invalid configuration.
+var errSSOUnreachable = errors.New("unreachable")
+
+// verifySSOEndpoints checks the issuer discovery document and that the JWKS
+// URL serves at least one key, so that a wrong IdP setting is caught before
+// the admin-api starts rejecting every login. When an endpoint cannot be
+// connected to the error wraps errSSOUnreachable.
+func verifySSOEndpoints(issuer, jwksURL string) error {
+	document, err := fetchSSODiscovery(issuer)
+	if err != nil {
+		return fmt.Errorf("verify: %w", ssoVerifyError(err))
+	}
+	if document.Issuer != "" && strings.TrimRight(document.Issuer, "/") != strings.TrimRight(issuer, "/") {
+		return fmt.Errorf("verify: discovery issuer %s does not match %s", document.Issuer, issuer)
+	}
+	if jwksURL == "" {
+		jwksURL = document.JWKSURI
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(jwksURL)
+	if err != nil {
+		return fmt.Errorf("verify: JWKS %s: %w", jwksURL, ssoVerifyError(err))
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("verify: JWKS %s failed (%d)", jwksURL, resp.StatusCode)
+	}
+	var jwks struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &jwks); err != nil || len(jwks.Keys) == 0 {
+		return fmt.Errorf("verify: JWKS %s has no keys", jwksURL)
+	}
+	fmt.Printf("Verified OIDC discovery for %s and %d signing keys.\n", issuer, len(jwks.Keys))
+	return nil
+}
+
+// ssoVerifyError wraps the DNS and dial errors with errSSOUnreachable. A
+// TLS failure, a timeout after connecting or a malformed URL stay errors of
+// the configuration.
+func ssoVerifyError(err error) error {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	if errors.As(err, &dnsErr) || (errors.As(err, &opErr) && opErr.Op == "dial") {
+		return fmt.Errorf("%w: %w", errSSOUnreachable, err)
+	}
+	return err
+}
+
+// checkSSOEndpoints runs verifySSOEndpoints for --verify; with
+// skipUnreachable an IdP that cannot be connected to is only reported.
+func checkSSOEndpoints(issuer, jwksURL string, skipUnreachable bool) error {
+	err := verifySSOEndpoints(issuer, jwksURL)
+	if skipUnreachable && errors.Is(err, errSSOUnreachable) {
+		fmt.Printf("Warning: %v, continuing without verification (--skip-unreachable).\n", err)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,231 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigSSOToolRollback(t *testing.T) {
+	opsHome := t.TempDir()
+	t.Setenv("OPS_HOME", opsHome)
+	configPath := filepath.Join(opsHome, "config.json")
+	cm, err := NewConfigMapBuilder().WithConfigJson(configPath).Build()
+	require.NoError(t, err)
+
+	foreign := ssoEnvFromSource{ConfigMapRef: &ssoLocalObjectReference{Name: "application-config"}}
+	state := fakeSSOWorkloadState{EnvFrom: []ssoEnvFromSource{foreign}}
+	api := newFakeKubeAPI(t, &state)
+
+	require.NoError(t, ConfigSSOTool(cm, []string{
+		"keycloak",
+		"--enable",
+		"--issuer-url", "https://keycloak.example.test/realms/openserverless",
+		"--jwks-url", "https://keycloak.example.test/realms/openserverless/certs",
+		"--client-id", "openserverless-admin-api",
+		"--client-secret", "test-secret",
+		"--required-group", "openserverless-users",
+		"--no-rollout",
+	}))
+	require.Len(t, state.EnvFrom, 3)
+	require.NotNil(t, api.object(fakeSecretPath))
+
+	ids, err := listSSOSnapshots(filepath.Join(opsHome, "sso-backups"))
+	require.NoError(t, err)
+	require.Len(t, ids, 1)
+	info, err := os.Stat(filepath.Join(opsHome, "sso-backups", ids[0]+".json"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// back to the state before enabling: nothing managed existed
+	require.NoError(t, ConfigSSOTool(cm, []string{"rollback", "--no-rollout"}))
+	require.Equal(t, []ssoEnvFromSource{foreign}, state.EnvFrom)
+	require.Nil(t, api.object(fakeConfigMapPath))
+	require.Nil(t, api.object(fakeSecretPath))
+	require.NotContains(t, cm.Flatten(), "SSO_ENABLED")
+	require.Zero(t, api.restarts)
+
+	// the rollback saved the enabled state, so it can be undone
+	ids, err = listSSOSnapshots(filepath.Join(opsHome, "sso-backups"))
+	require.NoError(t, err)
+	require.Len(t, ids, 2)
+	require.NoError(t, ConfigSSOTool(cm, []string{"rollback", "--to", ids[1]}))
+	require.Equal(t, []ssoEnvFromSource{foreign, managedConfigMapSource(), managedSecretSource()}, state.EnvFrom)
+	require.Equal(t, "https://keycloak.example.test/realms/openserverless", api.object(fakeConfigMapPath)["data"].(map[string]interface{})["OIDC_ISSUER_URL"])
+	require.NotNil(t, api.object(fakeSecretPath))
+	require.Equal(t, "true", cm.Flatten()["SSO_ENABLED"])
+	require.Equal(t, 1, api.restarts)
+}
+
+func TestConfigSSOToolRollbackUnknownSnapshot(t *testing.T) {
+	t.Setenv("OPS_HOME", t.TempDir())
+	cm, err := NewConfigMapBuilder().Build()
+	require.NoError(t, err)
+
+	require.ErrorContains(t, ConfigSSOTool(cm, []string{"rollback"}), "no SSO snapshots")
+	require.NoError(t, os.MkdirAll(filepath.Join(os.Getenv("OPS_HOME"), "sso-backups"), 0700))
+	require.ErrorContains(t, ConfigSSOTool(cm, []string{"rollback", "--to", "20200101-000000.000"}), "not found")
+}
+
+func TestSSOSnapshotsArePruned(t *testing.T) {
+	opsHome := t.TempDir()
+	t.Setenv("OPS_HOME", opsHome)
+	cm, err := NewConfigMapBuilder().Build()
+	require.NoError(t, err)
+	newFakeKubeAPI(t, &fakeSSOWorkloadState{})
+	kube, err := newKubeClient("")
+	require.NoError(t, err)
+
+	opts := ssoOptions{
+		Namespace:     defaultSSONamespace,
+		ConfigMapName: defaultSSOConfigMap,
+		SecretName:    defaultSSOSecret,
+		WorkloadName:  defaultSSOWorkload,
+		ContainerName: defaultSSOContainer,
+	}
+	var last string
+	for i := 0; i < maxSSOSnapshots+2; i++ {
+		last, err = snapshotSSO(cm, kube, opts, "test")
+		require.NoError(t, err)
+	}
+	ids, err := listSSOSnapshots(filepath.Join(opsHome, "sso-backups"))
+	require.NoError(t, err)
+	require.Len(t, ids, maxSSOSnapshots)
+	require.Equal(t, last, ids[len(ids)-1])
+}
+
+func TestVerifySSOEndpoints(t *testing.T) {
+	keys := `{"keys":[{"kty":"RSA","kid":"k1"}]}`
+	var issuer string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(map[string]string{"issuer": issuer, "jwks_uri": issuer + "/certs"})
+		case "/certs":
+			_, _ = w.Write([]byte(keys))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	issuer = server.URL
+
+	require.NoError(t, verifySSOEndpoints(server.URL, ""))
+	require.NoError(t, verifySSOEndpoints(server.URL, server.URL+"/certs"))
+	require.ErrorContains(t, verifySSOEndpoints(server.URL, server.URL+"/missing"), "failed (404)")
+
+	keys = `{"keys":[]}`
+	err := verifySSOEndpoints(server.URL, "")
+	require.ErrorContains(t, err, "has no keys")
+	require.NotErrorIs(t, err, errSSOUnreachable)
+
+	server.Close()
+	require.ErrorIs(t, verifySSOEndpoints(server.URL, ""), errSSOUnreachable)
+
+	// a certificate that is not trusted or a mistyped URL is not unreachable
+	tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsServer.Close()
+	err = verifySSOEndpoints(tlsServer.URL, "")
+	require.ErrorContains(t, err, "certificate")
+	require.NotErrorIs(t, err, errSSOUnreachable)
+	err = verifySSOEndpoints("htps://keycloak.example.test", "")
+	require.ErrorContains(t, err, "unsupported protocol scheme")
+	require.NotErrorIs(t, err, errSSOUnreachable)
+}
+
+func TestConfigSSOToolRollbackVerify(t *testing.T) {
+	opsHome := t.TempDir()
+	t.Setenv("OPS_HOME", opsHome)
+	cm, err := NewConfigMapBuilder().WithConfigJson(filepath.Join(opsHome, "config.json")).Build()
+	require.NoError(t, err)
+	api := newFakeKubeAPI(t, &fakeSSOWorkloadState{})
+
+	// the issuer answers with the discovery document of another issuer
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"issuer": "https://other.example.test"})
+	}))
+	defer server.Close()
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	enable := func(issuer string) {
+		require.NoError(t, ConfigSSOTool(cm, []string{
+			"keycloak", "--enable", "--no-rollout",
+			"--issuer-url", issuer,
+			"--jwks-url", issuer + "/certs",
+			"--client-id", "ops",
+			"--required-group", "ops-users",
+		}))
+	}
+	issuer := func() interface{} {
+		return api.object(fakeConfigMapPath)["data"].(map[string]interface{})["OIDC_ISSUER_URL"]
+	}
+
+	enable(server.URL)
+	enable("https://keycloak.example.test/realms/lab")
+	err = ConfigSSOTool(cm, []string{"rollback", "--verify", "--skip-unreachable", "--no-rollout"})
+	require.ErrorContains(t, err, "does not match")
+	require.NotErrorIs(t, err, errSSOUnreachable)
+	require.Equal(t, "https://keycloak.example.test/realms/lab", issuer())
+
+	enable(unreachable.URL)
+	enable("https://keycloak.example.test/realms/lab")
+	err = ConfigSSOTool(cm, []string{"rollback", "--verify", "--no-rollout"})
+	require.ErrorIs(t, err, errSSOUnreachable)
+	require.Equal(t, "https://keycloak.example.test/realms/lab", issuer())
+	// an issuer that cannot be reached from here is skipped only on request
+	require.NoError(t, ConfigSSOTool(cm, []string{"rollback", "--verify", "--skip-unreachable", "--no-rollout"}))
+	require.Equal(t, unreachable.URL, issuer())
+
+	// configuring verifies the same way
+	args := []string{
+		"keycloak", "--enable", "--no-rollout", "--verify",
+		"--issuer-url", unreachable.URL,
+		"--jwks-url", unreachable.URL + "/certs",
+		"--client-id", "ops",
+		"--required-group", "ops-users",
+	}
+	require.ErrorIs(t, ConfigSSOTool(cm, args), errSSOUnreachable)
+	require.NoError(t, ConfigSSOTool(cm, append(args, "--skip-unreachable")))
+}
+
+func TestConfigSSOToolVerifyFailureChangesNothing(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	cm, err := NewConfigMapBuilder().WithConfigJson(configPath).Build()
+	require.NoError(t, err)
+	stubSSODiscovery(t, nil)
+	api := newFakeKubeAPI(t, &fakeSSOWorkloadState{})
+
+	err = ConfigSSOTool(cm, []string{
+		"keycloak", "--enable", "--verify",
+		"--issuer-url", "https://keycloak.example.test/realms/lab",
+		"--jwks-url", "https://keycloak.example.test/realms/lab/certs",
+		"--client-id", "ops",
+		"--required-group", "ops-users",
+	})
+	require.ErrorContains(t, err, "verify: OIDC discovery")
+	require.Empty(t, api.calls())
+	require.NoFileExists(t, configPath)
+}
@@ -0,0 +1,146 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ssoProvider holds the defaults of an identity provider preset.
+type ssoProvider struct {
+	Name          string
+	UsernameClaim string
+	GroupsClaim   string
+	// Issuer derives the issuer URL from --tenant when --issuer-url is omitted.
+	Issuer func(tenant string) string
+	// Discover allows reading jwks_uri from the discovery document when
+	// --jwks-url is omitted.
+	Discover bool
+}
+
+var ssoProviders = map[string]ssoProvider{
+	"keycloak": {
+		Name:          "keycloak",
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+	},
+	"dex": {
+		Name:          "dex",
+		UsernameClaim: "email",
+		GroupsClaim:   "groups",
+		Discover:      true,
+	},
+	"azure": {
+		Name:          "azure",
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+		Issuer: func(tenant string) string {
+			if tenant == "" {
+				return ""
+			}
+			return "https://login.microsoftonline.com/" + tenant + "/v2.0"
+		},
+		Discover: true,
+	},
+	"okta": {
+		Name:          "okta",
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+		Discover:      true,
+	},
+	// Google ID tokens carry no groups: membership is checked on the
+	// Workspace domain (hd claim), so --required-group is the domain name.
+	"google": {
+		Name:          "google",
+		UsernameClaim: "email",
+		GroupsClaim:   "hd",
+		Issuer:        func(string) string { return "https://accounts.google.com" },
+		Discover:      true,
+	},
+	"generic": {
+		Name:          "generic",
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+		Discover:      true,
+	},
+}
+
+// ssoProviderAliases maps alternative names to the presets above.
+var ssoProviderAliases = map[string]string{
+	"entra":   "azure",
+	"azuread": "azure",
+}
+
+func lookupSSOProvider(name string) (ssoProvider, bool) {
+	if alias, ok := ssoProviderAliases[name]; ok {
+		name = alias
+	}
+	provider, ok := ssoProviders[name]
+	return provider, ok
+}
+
+type ssoDiscoveryDocument struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+var fetchSSODiscovery = httpFetchSSODiscovery
+
+func httpFetchSSODiscovery(issuer string) (*ssoDiscoveryDocument, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(strings.TrimRight(issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, fmt.Errorf("OIDC discovery for %s failed: %w", issuer, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OIDC discovery for %s failed (%d): %s", issuer, resp.StatusCode, string(body))
+	}
+	var document ssoDiscoveryDocument
+	if err := json.Unmarshal(body, &document); err != nil {
+		return nil, fmt.Errorf("failed to decode OIDC discovery document of %s", issuer)
+	}
+	return &document, nil
+}
+
+// discoverSSOJWKS fills the JWKS URL from the issuer discovery document and
+// checks the document really belongs to the configured issuer.
+func discoverSSOJWKS(opts *ssoOptions) error {
+	document, err := fetchSSODiscovery(opts.IssuerURL)
+	if err != nil {
+		return err
+	}
+	if document.Issuer != "" && strings.TrimRight(document.Issuer, "/") != strings.TrimRight(opts.IssuerURL, "/") {
+		return fmt.Errorf("OIDC discovery issuer %s does not match --issuer-url %s", document.Issuer, opts.IssuerURL)
+	}
+	if document.JWKSURI == "" {
+		return fmt.Errorf("OIDC discovery document of %s has no jwks_uri, use --jwks-url", opts.IssuerURL)
+	}
+	opts.JWKSURL = document.JWKSURI
+	return nil
+}
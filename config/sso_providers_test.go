@@ -0,0 +1,161 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func stubSSODiscovery(t *testing.T, documents map[string]ssoDiscoveryDocument) {
+	t.Helper()
+	previous := fetchSSODiscovery
+	fetchSSODiscovery = func(issuer string) (*ssoDiscoveryDocument, error) {
+		document, ok := documents[issuer]
+		if !ok {
+			return nil, fmt.Errorf("OIDC discovery for %s failed (404)", issuer)
+		}
+		return &document, nil
+	}
+	t.Cleanup(func() { fetchSSODiscovery = previous })
+}
+
+func TestParseProviderSSOArgsPresets(t *testing.T) {
+	stubSSODiscovery(t, map[string]ssoDiscoveryDocument{
+		"https://dex.example.test": {
+			Issuer:  "https://dex.example.test",
+			JWKSURI: "https://dex.example.test/keys",
+		},
+		"https://login.microsoftonline.com/contoso.onmicrosoft.com/v2.0": {
+			Issuer:  "https://login.microsoftonline.com/contoso.onmicrosoft.com/v2.0",
+			JWKSURI: "https://login.microsoftonline.com/contoso.onmicrosoft.com/discovery/v2.0/keys",
+		},
+		"https://accounts.google.com": {
+			Issuer:  "https://accounts.google.com",
+			JWKSURI: "https://www.googleapis.com/oauth2/v3/certs",
+		},
+	})
+
+	common := []string{"--enable", "--client-id", "ops", "--required-group", "ops-users"}
+
+	opts, err := parseProviderSSOArgs(ssoProviders["dex"], append([]string{"--issuer-url", "https://dex.example.test"}, common...))
+	require.NoError(t, err)
+	require.Equal(t, "dex", opts.Provider)
+	require.Equal(t, "https://dex.example.test/keys", opts.JWKSURL)
+	require.Equal(t, "email", opts.UsernameClaim)
+	require.Equal(t, "groups", opts.GroupsClaim)
+
+	azure, ok := lookupSSOProvider("entra")
+	require.True(t, ok)
+	opts, err = parseProviderSSOArgs(azure, append([]string{"--tenant", "contoso.onmicrosoft.com"}, common...))
+	require.NoError(t, err)
+	require.Equal(t, "azure", opts.Provider)
+	require.Equal(t, "https://login.microsoftonline.com/contoso.onmicrosoft.com/v2.0", opts.IssuerURL)
+	require.Equal(t, "https://login.microsoftonline.com/contoso.onmicrosoft.com/discovery/v2.0/keys", opts.JWKSURL)
+
+	_, err = parseProviderSSOArgs(azure, common)
+	require.ErrorContains(t, err, "missing --tenant or --issuer-url")
+
+	opts, err = parseProviderSSOArgs(ssoProviders["google"], []string{"--enable", "--client-id", "ops", "--required-group", "example.com"})
+	require.NoError(t, err)
+	require.Equal(t, "https://accounts.google.com", opts.IssuerURL)
+	require.Equal(t, "email", opts.UsernameClaim)
+	require.Equal(t, "hd", opts.GroupsClaim)
+
+	_, err = parseProviderSSOArgs(ssoProviders["okta"], append([]string{"--tenant", "x"}, common...))
+	require.ErrorContains(t, err, "--tenant is not supported by the okta provider")
+
+	_, err = parseProviderSSOArgs(ssoProviders["generic"], common)
+	require.ErrorContains(t, err, "missing --issuer-url")
+}
+
+func TestParseProviderSSOArgsExplicitJWKSSkipsDiscovery(t *testing.T) {
+	stubSSODiscovery(t, nil)
+
+	opts, err := parseProviderSSOArgs(ssoProviders["okta"], []string{
+		"--enable",
+		"--issuer-url", "https://example.okta.com/oauth2/default",
+		"--jwks-url", "https://example.okta.com/oauth2/default/v1/keys",
+		"--audience", "api://default",
+		"--required-group", "ops-users",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "https://example.okta.com/oauth2/default/v1/keys", opts.JWKSURL)
+}
+
+func TestDiscoverSSOJWKS(t *testing.T) {
+	var issuer string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/.well-known/openid-configuration", r.URL.Path)
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   issuer,
+			"jwks_uri": issuer + "/jwks",
+		})
+	}))
+	defer server.Close()
+
+	issuer = server.URL
+	opts := ssoOptions{IssuerURL: server.URL + "/"}
+	require.NoError(t, discoverSSOJWKS(&opts))
+	require.Equal(t, server.URL+"/jwks", opts.JWKSURL)
+
+	issuer = "https://other.example.test"
+	opts = ssoOptions{IssuerURL: server.URL}
+	require.ErrorContains(t, discoverSSOJWKS(&opts), "does not match --issuer-url")
+}
+
+func TestConfigSSOToolGenericProvider(t *testing.T) {
+	stubSSODiscovery(t, map[string]ssoDiscoveryDocument{
+		"https://idp.example.test": {
+			Issuer:  "https://idp.example.test",
+			JWKSURI: "https://idp.example.test/certs",
+		},
+	})
+
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	cm, err := NewConfigMapBuilder().WithConfigJson(configPath).Build()
+	require.NoError(t, err)
+
+	api := newFakeKubeAPI(t, &fakeSSOWorkloadState{})
+
+	err = ConfigSSOTool(cm, []string{
+		"generic",
+		"--enable",
+		"--issuer-url", "https://idp.example.test",
+		"--audience", "openserverless-admin-api",
+		"--required-group", "openserverless-users",
+		"--username-claim", "sub",
+		"--no-rollout",
+	})
+	require.NoError(t, err)
+
+	flat := cm.Flatten()
+	require.Equal(t, "generic", flat["SSO_PROVIDER"])
+	require.Equal(t, "https://idp.example.test/certs", flat["SSO_OIDC_JWKS_URL"])
+
+	data := api.object(fakeConfigMapPath)["data"].(map[string]interface{})
+	require.Equal(t, "https://idp.example.test/certs", data["OIDC_JWKS_URL"])
+	require.Equal(t, "sub", data["OIDC_USERNAME_CLAIM"])
+	require.Equal(t, "groups", data["OIDC_GROUPS_CLAIM"])
+}
@@ -0,0 +1,249 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// configuredSecretPlaceholder stands in for the client secret, which is never
+// stored locally, when only its presence matters.
+const configuredSecretPlaceholder = "<configured>"
+
+type ssoLiveResource struct {
+	Data map[string]string `json:"data"`
+}
+
+// printSSOPlan shows what configureSSO would apply. The cluster is only read.
+func printSSOPlan(kube *kubeClient, opts ssoOptions) error {
+	fmt.Printf("Plan for sso %s (nothing is applied):\n", opts.Provider)
+
+	fmt.Printf("\nConfigMap %s/%s:\n", opts.Namespace, opts.ConfigMapName)
+	printSSOData(ssoConfigMapData(opts))
+
+	if opts.ClientSecret != "" {
+		fmt.Printf("\nSecret %s/%s:\n", opts.Namespace, opts.SecretName)
+		printSSOData(map[string]string{"OIDC_CLIENT_SECRET": opts.ClientSecret})
+	}
+
+	operations, err := planSSOEnvFrom(kube, opts, true)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nStatefulSet %s/%s envFrom:\n", opts.Namespace, opts.WorkloadName)
+	if len(operations) == 0 {
+		fmt.Println("  already up to date")
+	} else {
+		payload, err := json.MarshalIndent(operations, "  ", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  %s\n", payload)
+	}
+
+	if opts.NoRollout {
+		fmt.Println("\nRollout: skipped (--no-rollout)")
+	} else {
+		fmt.Printf("\nRollout: restart statefulset/%s\n", opts.WorkloadName)
+	}
+	return nil
+}
+
+func printSSOData(data map[string]string) {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("  %s=%v\n", key, printableSSOValue(key, data[key]))
+	}
+}
+
+// ssoOptionsFromConfig rebuilds the options of the last configure run from the
+// SSO_* keys saved in config.json.
+func ssoOptionsFromConfig(values map[string]string) ssoOptions {
+	valueOr := func(key, fallback string) string {
+		if value, ok := values[key]; ok && value != "" {
+			return value
+		}
+		return fallback
+	}
+	opts := ssoOptions{
+		Provider:               values["SSO_PROVIDER"],
+		IssuerURL:              values["SSO_OIDC_ISSUER_URL"],
+		JWKSURL:                values["SSO_OIDC_JWKS_URL"],
+		Audience:               values["SSO_OIDC_AUDIENCE"],
+		ClientID:               values["SSO_OIDC_CLIENT_ID"],
+		RequiredGroup:          values["SSO_OIDC_REQUIRED_GROUP"],
+		UsernameClaim:          values["SSO_OIDC_USERNAME_CLAIM"],
+		GroupsClaim:            values["SSO_OIDC_GROUPS_CLAIM"],
+		AutoProvision:          values["SSO_AUTOPROVISION_ON_LOGIN"] == "true",
+		AutoProvisionTimeout:   values["SSO_AUTOPROVISION_TIMEOUT_SECONDS"],
+		AutoProvisionPoll:      values["SSO_AUTOPROVISION_POLL_SECONDS"],
+		AutoProvisionServices:  values["SSO_AUTOPROVISION_DEFAULT_SERVICES"],
+		NamespacePreserveValid: values["SSO_NAMESPACE_PRESERVE_VALID"] == "true",
+		NamespaceHashLength:    values["SSO_NAMESPACE_HASH_LENGTH"],
+		NamespaceMaxLength:     values["SSO_NAMESPACE_MAX_LENGTH"],
+		Namespace:              valueOr("SSO_KUBE_NAMESPACE", defaultSSONamespace),
+		ConfigMapName:          valueOr("SSO_KUBE_CONFIGMAP", defaultSSOConfigMap),
+		SecretName:             valueOr("SSO_KUBE_SECRET", defaultSSOSecret),
+		WorkloadName:           valueOr("SSO_KUBE_STATEFULSET", defaultSSOWorkload),
+		ContainerName:          valueOr("SSO_KUBE_CONTAINER", defaultSSOContainer),
+	}
+	if values["SSO_OIDC_CLIENT_SECRET_CONFIGURED"] == "true" {
+		opts.ClientSecret = configuredSecretPlaceholder
+	}
+	return opts
+}
+
+func parseSSOStatusArgs(opts ssoOptions, args []string) (ssoOptions, error) {
+	flags := flag.NewFlagSet("sso status", flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	flags.StringVar(&opts.Namespace, "namespace", opts.Namespace, "Kubernetes namespace")
+	flags.StringVar(&opts.ConfigMapName, "configmap", opts.ConfigMapName, "Kubernetes ConfigMap name")
+	flags.StringVar(&opts.SecretName, "secret", opts.SecretName, "Kubernetes Secret name")
+	flags.StringVar(&opts.WorkloadName, "statefulset", opts.WorkloadName, "admin-api StatefulSet name")
+	flags.StringVar(&opts.ContainerName, "container", opts.ContainerName, "admin-api container name")
+	flags.StringVar(&opts.KubeContext, "context", "", "kubeconfig context")
+
+	if err := flags.Parse(args); err != nil {
+		return opts, err
+	}
+	if flags.NArg() > 0 {
+		return opts, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+	return opts, nil
+}
+
+// getSSOLiveResource returns nil when the resource does not exist.
+func getSSOLiveResource(kube *kubeClient, opts ssoOptions, kind, name string) (*ssoLiveResource, error) {
+	output, err := kube.get(kubeCorePath(opts.Namespace, kind+"s", name))
+	if err != nil || output == nil {
+		return nil, err
+	}
+	var resource ssoLiveResource
+	if err := json.Unmarshal(output, &resource); err != nil {
+		return nil, fmt.Errorf("decode %s %s/%s: %w", kind, opts.Namespace, name, err)
+	}
+	return &resource, nil
+}
+
+// ssoStatus compares the cluster with config.json and fails when they drifted.
+func ssoStatus(configMap ConfigMap, args []string) error {
+	values := configMap.Flatten()
+	opts, err := parseSSOStatusArgs(ssoOptionsFromConfig(values), args)
+	if err != nil {
+		return err
+	}
+	kube, err := newKubeClient(opts.KubeContext)
+	if err != nil {
+		return err
+	}
+	enabled := values["SSO_ENABLED"] == "true"
+	if enabled {
+		fmt.Printf("SSO enabled in config.json (provider %s)\n", opts.Provider)
+	} else {
+		fmt.Println("SSO not enabled in config.json")
+	}
+
+	drift := make([]string, 0)
+
+	configMapRef := fmt.Sprintf("ConfigMap %s/%s", opts.Namespace, opts.ConfigMapName)
+	liveConfigMap, err := getSSOLiveResource(kube, opts, "configmap", opts.ConfigMapName)
+	if err != nil {
+		return err
+	}
+	switch {
+	case enabled && liveConfigMap == nil:
+		drift = append(drift, configMapRef+" is missing")
+	case !enabled && liveConfigMap != nil:
+		drift = append(drift, configMapRef+" exists but SSO is not enabled")
+	case enabled:
+		drift = append(drift, diffSSOData(configMapRef, ssoConfigMapData(opts), liveConfigMap.Data)...)
+	}
+
+	secretRef := fmt.Sprintf("Secret %s/%s", opts.Namespace, opts.SecretName)
+	wantSecret := enabled && opts.ClientSecret != ""
+	liveSecret, err := getSSOLiveResource(kube, opts, "secret", opts.SecretName)
+	if err != nil {
+		return err
+	}
+	switch {
+	case wantSecret && liveSecret == nil:
+		drift = append(drift, secretRef+" is missing")
+	case wantSecret && liveSecret.Data["OIDC_CLIENT_SECRET"] == "":
+		drift = append(drift, secretRef+" has no OIDC_CLIENT_SECRET")
+	case !wantSecret && liveSecret != nil:
+		drift = append(drift, secretRef+" exists but no client secret is configured")
+	}
+
+	operations, err := planSSOEnvFrom(kube, opts, enabled)
+	if err != nil {
+		return err
+	}
+	if len(operations) > 0 {
+		payload, err := json.Marshal(operations)
+		if err != nil {
+			return err
+		}
+		drift = append(drift, fmt.Sprintf("StatefulSet %s/%s envFrom differs, pending patch: %s", opts.Namespace, opts.WorkloadName, payload))
+	}
+
+	if len(drift) == 0 {
+		fmt.Println("No drift: the cluster matches config.json.")
+		return nil
+	}
+	for _, line := range drift {
+		fmt.Printf("drift: %s\n", line)
+	}
+	return fmt.Errorf("SSO configuration drift detected (%d differences)", len(drift))
+}
+
+func diffSSOData(ref string, expected, live map[string]string) []string {
+	keys := make([]string, 0, len(expected)+len(live))
+	for key := range expected {
+		keys = append(keys, key)
+	}
+	for key := range live {
+		if _, ok := expected[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	drift := make([]string, 0)
+	for _, key := range keys {
+		want, wanted := expected[key]
+		got, present := live[key]
+		switch {
+		case !present:
+			drift = append(drift, fmt.Sprintf("%s: %s is missing, config.json has %q", ref, key, want))
+		case !wanted:
+			drift = append(drift, fmt.Sprintf("%s: unexpected key %s", ref, key))
+		case got != want:
+			drift = append(drift, fmt.Sprintf("%s: %s is %q, config.json has %q", ref, key, got, want))
+		}
+	}
+	return drift
+}
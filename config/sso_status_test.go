@@ -0,0 +1,169 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func ExampleConfigSSOTool_plan() {
+	tmpDir, _ := os.MkdirTemp("", "ops")
+	defer os.RemoveAll(tmpDir)
+	cm, _ := NewConfigMapBuilder().WithConfigJson(filepath.Join(tmpDir, "config.json")).Build()
+
+	_, stop := startFakeKubeAPI(&fakeSSOWorkloadState{})
+	defer stop()
+
+	err := ConfigSSOTool(cm, []string{
+		"keycloak",
+		"--enable",
+		"--issuer-url", "http://localhost:8080/realms/lab",
+		"--jwks-url", "http://localhost:8080/realms/lab/protocol/openid-connect/certs",
+		"--client-id", "ops",
+		"--client-secret", "super-secret",
+		"--required-group", "ops-users",
+		"--plan",
+	})
+	if err != nil {
+		fmt.Println("error:", err)
+	}
+	// Output:
+	// Plan for sso keycloak (nothing is applied):
+	//
+	// ConfigMap nuvolaris/openserverless-sso-config:
+	//   OIDC_AUDIENCE=ops
+	//   OIDC_CLIENT_ID=ops
+	//   OIDC_GROUPS_CLAIM=groups
+	//   OIDC_ISSUER_URL=http://localhost:8080/realms/lab
+	//   OIDC_JWKS_URL=http://localhost:8080/realms/lab/protocol/openid-connect/certs
+	//   OIDC_REQUIRED_GROUP=ops-users
+	//   OIDC_USERNAME_CLAIM=preferred_username
+	//   SSO_AUTOPROVISION_DEFAULT_SERVICES=all
+	//   SSO_AUTOPROVISION_ON_LOGIN=true
+	//   SSO_AUTOPROVISION_POLL_SECONDS=2
+	//   SSO_AUTOPROVISION_TIMEOUT_SECONDS=120
+	//   SSO_NAMESPACE_HASH_LENGTH=8
+	//   SSO_NAMESPACE_MAX_LENGTH=61
+	//   SSO_NAMESPACE_PRESERVE_VALID=true
+	//
+	// Secret nuvolaris/openserverless-sso-secret:
+	//   OIDC_CLIENT_SECRET=<redacted>
+	//
+	// StatefulSet nuvolaris/nuvolaris-system-api envFrom:
+	//   [
+	//     {
+	//       "op": "add",
+	//       "path": "/spec/template/spec/containers/0/envFrom",
+	//       "value": [
+	//         {
+	//           "configMapRef": {
+	//             "name": "openserverless-sso-config"
+	//           }
+	//         },
+	//         {
+	//           "secretRef": {
+	//             "name": "openserverless-sso-secret"
+	//           }
+	//         }
+	//       ]
+	//     }
+	//   ]
+	//
+	// Rollout: restart statefulset/nuvolaris-system-api
+}
+
+func TestConfigSSOToolPlanDoesNotSaveConfig(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	cm, err := NewConfigMapBuilder().WithConfigJson(configPath).Build()
+	require.NoError(t, err)
+
+	api := newFakeKubeAPI(t, &fakeSSOWorkloadState{EnvFrom: []ssoEnvFromSource{managedConfigMapSource()}})
+
+	err = ConfigSSOTool(cm, []string{
+		"keycloak", "--enable", "--plan",
+		"--issuer-url", "http://localhost:8080/realms/lab",
+		"--jwks-url", "http://localhost:8080/realms/lab/certs",
+		"--client-id", "ops",
+		"--required-group", "ops-users",
+	})
+	require.NoError(t, err)
+	require.NoFileExists(t, configPath)
+	require.Equal(t, []string{"GET " + fakeStatefulSetPath}, api.calls())
+}
+
+// fakeSSOCluster serves the live state read by status.
+func fakeSSOCluster(t *testing.T, configMap, secret map[string]string, envFrom ...ssoEnvFromSource) *fakeKubeAPI {
+	t.Helper()
+	api := newFakeKubeAPI(t, &fakeSSOWorkloadState{EnvFrom: envFrom})
+	if configMap != nil {
+		api.seed(fakeConfigMapPath, configMap)
+	}
+	if secret != nil {
+		api.seed(fakeSecretPath, secret)
+	}
+	return api
+}
+
+func enabledSSOConfig(t *testing.T) (ConfigMap, ssoOptions) {
+	t.Helper()
+	cm, err := NewConfigMapBuilder().WithConfigJson(filepath.Join(t.TempDir(), "config.json")).Build()
+	require.NoError(t, err)
+	opts, err := parseProviderSSOArgs(ssoProviders["keycloak"], []string{
+		"--enable",
+		"--issuer-url", "http://localhost:8080/realms/lab",
+		"--jwks-url", "http://localhost:8080/realms/lab/certs",
+		"--client-id", "ops",
+		"--required-group", "ops-users",
+	})
+	require.NoError(t, err)
+	require.NoError(t, saveSSOConfig(cm, opts))
+	return cm, opts
+}
+
+func TestConfigSSOToolStatusInSync(t *testing.T) {
+	cm, opts := enabledSSOConfig(t)
+	fakeSSOCluster(t, ssoConfigMapData(opts), nil, managedConfigMapSource())
+
+	require.NoError(t, ConfigSSOTool(cm, []string{"status"}))
+}
+
+func TestConfigSSOToolStatusReportsDrift(t *testing.T) {
+	cm, opts := enabledSSOConfig(t)
+	live := ssoConfigMapData(opts)
+	live["OIDC_REQUIRED_GROUP"] = "everyone"
+	fakeSSOCluster(t, live, map[string]string{"OIDC_CLIENT_SECRET": "c2VjcmV0"})
+
+	err := ConfigSSOTool(cm, []string{"status"})
+	require.ErrorContains(t, err, "SSO configuration drift detected (3 differences)")
+}
+
+func TestConfigSSOToolStatusDisabled(t *testing.T) {
+	cm, err := NewConfigMapBuilder().WithConfigJson(filepath.Join(t.TempDir(), "config.json")).Build()
+	require.NoError(t, err)
+
+	fakeSSOCluster(t, nil, nil)
+	require.NoError(t, ConfigSSOTool(cm, []string{"status"}))
+
+	fakeSSOCluster(t, map[string]string{"OIDC_ISSUER_URL": "x"}, nil, managedConfigMapSource())
+	require.ErrorContains(t, ConfigSSOTool(cm, []string{"status"}), "(2 differences)")
+}
@@ -18,14 +18,13 @@
 package config
 
 import (
-	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
-	"os/exec"
 	"sort"
 	"strings"
+	"time"
 )
 
 const (
@@ -36,9 +35,7 @@ const (
 	defaultSSOContainer = "nuvolaris-system-api"
 )
 
-type commandRunner func(name string, args []string, stdin []byte) ([]byte, error)
-
-var runSSOCommand commandRunner = realCommandRunner
+const ssoRolloutTimeout = 180 * time.Second
 
 type ssoEnvFromSource struct {
 	Prefix       string                   `json:"prefix,omitempty"`
@@ -97,6 +94,8 @@ var managedLocalSSOKeys = []string{
 }
 
 type ssoOptions struct {
+	Provider               string
+	Tenant                 string
 	IssuerURL              string
 	JWKSURL                string
 	Audience               string
@@ -118,17 +117,34 @@ type ssoOptions struct {
 	WorkloadName           string
 	ContainerName          string
 	NoRollout              bool
+	Plan                   bool
+	Verify                 bool
+	SkipUnreachable        bool
+	KubeContext            string
 }
 
 func printSSOUsage() {
 	fmt.Print(`Usage:
 ops -config sso keycloak --enable --issuer-url URL --jwks-url URL (--audience AUDIENCE|--client-id CLIENT_ID) --required-group GROUP [options]
+ops -config sso (dex|okta|generic) --enable --issuer-url URL (--audience AUDIENCE|--client-id CLIENT_ID) --required-group GROUP [options]
+ops -config sso azure --enable (--tenant TENANT|--issuer-url URL) (--audience AUDIENCE|--client-id CLIENT_ID) --required-group GROUP [options]
+ops -config sso google --enable (--audience AUDIENCE|--client-id CLIENT_ID) --required-group DOMAIN [options]
 ops -config sso show
+ops -config sso status [options]
 ops -config sso disable [options]
+ops -config sso rollback [--to SNAPSHOT] [--verify [--skip-unreachable]] [--no-rollout] [--context NAME]
+ops -config sso rollback --list
 
 Legacy compatibility tool for OpenServerless SSO/OIDC integration.
 The public command surface is provided by the ops config sso task.
 
+Providers:
+  keycloak                 Needs --jwks-url, often a cluster-internal address
+  dex, okta, generic       JWKS URL read from the issuer discovery document
+  azure (entra)            Issuer derived from --tenant: login.microsoftonline.com/TENANT/v2.0
+  google                   Issuer https://accounts.google.com; --required-group is the
+                           Workspace domain, matched against the hd claim
+
 Managed Kubernetes resources:
   ConfigMap NAME            OIDC_* and SSO_* values created by this command
   Secret NAME               OIDC_CLIENT_SECRET, when --client-secret is used
@@ -137,9 +153,16 @@ Managed Kubernetes resources:
 The command does not manage direct env entries, other envFrom references,
 volumes, volumeMounts, or workload annotations. Disable leaves them unchanged.
 
+The cluster is reached through $KUBECONFIG (or ~/.kube/config), or the
+in-cluster service account; kubectl is not required.
+
 Options:
-  --username-claim CLAIM   OIDC username claim. Default: preferred_username
-  --groups-claim CLAIM     OIDC groups claim. Default: groups
+  --username-claim CLAIM   OIDC username claim. Default: email for dex and google,
+                           preferred_username otherwise
+  --groups-claim CLAIM     OIDC groups claim. Default: hd for google, groups otherwise
+  --jwks-url URL           OIDC JWKS URL. Discovered from --issuer-url when omitted,
+                           except for keycloak
+  --tenant TENANT          Azure AD/Entra tenant id or domain
   --client-id CLIENT_ID    OIDC client id. Defaults to --audience when omitted
   --client-secret SECRET   OIDC confidential client secret stored only in Kubernetes Secret
   --namespace NS           Kubernetes namespace. Default: nuvolaris
@@ -147,7 +170,24 @@ Options:
   --secret NAME            Kubernetes Secret name. Default: openserverless-sso-secret
   --statefulset NAME       admin-api StatefulSet name. Default: nuvolaris-system-api
   --container NAME         admin-api container name. Default: nuvolaris-system-api
+  --context NAME           kubeconfig context. Default: the current context
   --no-rollout             Do not restart or wait for admin-api rollout
+  --plan                   Print the resources and the envFrom patch without applying them
+  --verify                 Fetch the OIDC discovery document and JWKS before applying
+  --skip-unreachable       With --verify, go on when the IdP cannot be connected to
+
+Every change first saves the previous resources and SSO_* keys as a snapshot
+in $OPS_HOME/sso-backups (the last 10 are kept). Rollback restores the latest
+snapshot, or the one given with --to, and rolls out admin-api again.
+
+With --verify an IdP that fails the checks, or cannot be connected to, stops
+the change or the rollback. Add --skip-unreachable when the issuer is only
+reachable from the cluster: an unknown host or a refused connection is then
+reported and the change goes on, while TLS errors and invalid documents still
+stop it.
+
+Status compares the live ConfigMap, Secret and admin-api envFrom with the
+values recorded in config.json and exits with an error when they drifted.
 `)
 }
 
@@ -157,12 +197,18 @@ func ConfigSSOTool(configMap ConfigMap, args []string) error {
 		return nil
 	}
 
+	if provider, ok := lookupSSOProvider(args[0]); ok {
+		return configureSSO(configMap, provider, args[1:])
+	}
+
 	switch args[0] {
-	case "keycloak":
-		return configureKeycloakSSO(configMap, args[1:])
 	case "show":
 		printSSOConfig(configMap)
 		return nil
+	case "status":
+		return ssoStatus(configMap, args[1:])
+	case "rollback":
+		return rollbackSSO(configMap, args[1:])
 	case "disable":
 		return disableSSO(configMap, args[1:])
 	case "-h", "--help", "help":
@@ -173,32 +219,50 @@ func ConfigSSOTool(configMap ConfigMap, args []string) error {
 	}
 }
 
-func configureKeycloakSSO(configMap ConfigMap, args []string) error {
-	opts, err := parseKeycloakSSOArgs(args)
+func configureSSO(configMap ConfigMap, provider ssoProvider, args []string) error {
+	opts, err := parseProviderSSOArgs(provider, args)
 	if err != nil {
 		return err
 	}
+	kube, err := newKubeClient(opts.KubeContext)
+	if err != nil {
+		return err
+	}
+	if opts.Plan {
+		return printSSOPlan(kube, opts)
+	}
+	if opts.Verify {
+		if err := checkSSOEndpoints(opts.IssuerURL, opts.JWKSURL, opts.SkipUnreachable); err != nil {
+			return err
+		}
+	}
+
+	snapshot, err := snapshotSSO(configMap, kube, opts, "sso "+opts.Provider)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Previous SSO configuration saved as snapshot %s.\n", snapshot)
 
 	if err := saveSSOConfig(configMap, opts); err != nil {
 		return err
 	}
 
-	if err := applySSOConfigMap(opts); err != nil {
+	if err := applySSOConfigMap(kube, opts); err != nil {
 		return err
 	}
 
 	if opts.ClientSecret != "" {
-		if err := applySSOSecret(opts); err != nil {
+		if err := applySSOSecret(kube, opts); err != nil {
 			return err
 		}
 	}
 
-	if _, err := reconcileSSOEnvFrom(opts, true); err != nil {
+	if _, err := reconcileSSOEnvFrom(kube, opts, true); err != nil {
 		return err
 	}
 
 	if !opts.NoRollout {
-		if err := rolloutSSOWorkload(opts); err != nil {
+		if err := rolloutSSOWorkload(kube, opts); err != nil {
 			return err
 		}
 	}
@@ -211,10 +275,11 @@ func configureKeycloakSSO(configMap ConfigMap, args []string) error {
 	return nil
 }
 
-func parseKeycloakSSOArgs(args []string) (ssoOptions, error) {
+func parseProviderSSOArgs(provider ssoProvider, args []string) (ssoOptions, error) {
 	opts := ssoOptions{
-		UsernameClaim:          "preferred_username",
-		GroupsClaim:            "groups",
+		Provider:               provider.Name,
+		UsernameClaim:          provider.UsernameClaim,
+		GroupsClaim:            provider.GroupsClaim,
 		AutoProvision:          true,
 		AutoProvisionTimeout:   "120",
 		AutoProvisionPoll:      "2",
@@ -229,11 +294,12 @@ func parseKeycloakSSOArgs(args []string) (ssoOptions, error) {
 		ContainerName:          defaultSSOContainer,
 	}
 
-	flags := flag.NewFlagSet("sso keycloak", flag.ContinueOnError)
+	flags := flag.NewFlagSet("sso "+provider.Name, flag.ContinueOnError)
 	flags.SetOutput(os.Stderr)
 	enable := flags.Bool("enable", false, "enable SSO")
 	flags.StringVar(&opts.IssuerURL, "issuer-url", "", "OIDC issuer URL")
 	flags.StringVar(&opts.JWKSURL, "jwks-url", "", "OIDC JWKS URL")
+	flags.StringVar(&opts.Tenant, "tenant", "", "Azure AD/Entra tenant")
 	flags.StringVar(&opts.Audience, "audience", "", "OIDC audience")
 	flags.StringVar(&opts.ClientID, "client-id", "", "OIDC client id")
 	flags.StringVar(&opts.ClientSecret, "client-secret", "", "OIDC confidential client secret")
@@ -246,6 +312,10 @@ func parseKeycloakSSOArgs(args []string) (ssoOptions, error) {
 	flags.StringVar(&opts.WorkloadName, "statefulset", opts.WorkloadName, "admin-api StatefulSet name")
 	flags.StringVar(&opts.ContainerName, "container", opts.ContainerName, "admin-api container name")
 	flags.BoolVar(&opts.NoRollout, "no-rollout", false, "skip rollout restart/status")
+	flags.BoolVar(&opts.Plan, "plan", false, "print the changes without applying them")
+	flags.BoolVar(&opts.Verify, "verify", false, "check OIDC discovery and JWKS before applying")
+	flags.BoolVar(&opts.SkipUnreachable, "skip-unreachable", false, "with --verify, do not stop when the IdP cannot be connected to")
+	flags.StringVar(&opts.KubeContext, "context", "", "kubeconfig context")
 
 	if err := flags.Parse(args); err != nil {
 		return opts, err
@@ -256,10 +326,19 @@ func parseKeycloakSSOArgs(args []string) (ssoOptions, error) {
 	if flags.NArg() > 0 {
 		return opts, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
 	}
+	if opts.Tenant != "" && provider.Issuer == nil {
+		return opts, fmt.Errorf("--tenant is not supported by the %s provider", provider.Name)
+	}
+	if opts.IssuerURL == "" && provider.Issuer != nil {
+		opts.IssuerURL = provider.Issuer(opts.Tenant)
+	}
 	if opts.IssuerURL == "" {
+		if provider.Issuer != nil {
+			return opts, fmt.Errorf("missing --tenant or --issuer-url")
+		}
 		return opts, fmt.Errorf("missing --issuer-url")
 	}
-	if opts.JWKSURL == "" {
+	if opts.JWKSURL == "" && !provider.Discover {
 		return opts, fmt.Errorf("missing --jwks-url")
 	}
 	if opts.ClientID == "" {
@@ -283,6 +362,11 @@ func parseKeycloakSSOArgs(args []string) (ssoOptions, error) {
 	if opts.SecretName == "" {
 		return opts, fmt.Errorf("missing --secret")
 	}
+	if opts.JWKSURL == "" {
+		if err := discoverSSOJWKS(&opts); err != nil {
+			return opts, err
+		}
+	}
 	return opts, nil
 }
 
@@ -303,6 +387,7 @@ func parseDisableSSOArgs(args []string) (ssoOptions, error) {
 	flags.StringVar(&opts.WorkloadName, "statefulset", opts.WorkloadName, "admin-api StatefulSet name")
 	flags.StringVar(&opts.ContainerName, "container", opts.ContainerName, "admin-api container name")
 	flags.BoolVar(&opts.NoRollout, "no-rollout", false, "skip rollout restart/status")
+	flags.StringVar(&opts.KubeContext, "context", "", "kubeconfig context")
 
 	if err := flags.Parse(args); err != nil {
 		return opts, err
@@ -316,7 +401,7 @@ func parseDisableSSOArgs(args []string) (ssoOptions, error) {
 func saveSSOConfig(configMap ConfigMap, opts ssoOptions) error {
 	values := map[string]string{
 		"SSO_ENABLED":                        "true",
-		"SSO_PROVIDER":                       "keycloak",
+		"SSO_PROVIDER":                       opts.Provider,
 		"SSO_OIDC_ISSUER_URL":                opts.IssuerURL,
 		"SSO_OIDC_JWKS_URL":                  opts.JWKSURL,
 		"SSO_OIDC_AUDIENCE":                  opts.Audience,
@@ -366,22 +451,31 @@ func disableSSO(configMap ConfigMap, args []string) error {
 	if err != nil {
 		return err
 	}
+	kube, err := newKubeClient(opts.KubeContext)
+	if err != nil {
+		return err
+	}
+	snapshot, err := snapshotSSO(configMap, kube, opts, "sso disable")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Previous SSO configuration saved as snapshot %s.\n", snapshot)
 
 	if err := removeLocalSSOConfig(configMap); err != nil {
 		return err
 	}
-	workloadChanged, err := reconcileSSOEnvFrom(opts, false)
+	workloadChanged, err := reconcileSSOEnvFrom(kube, opts, false)
 	if err != nil {
 		return err
 	}
-	if err := deleteSSOConfigMap(opts); err != nil {
+	if err := kube.delete(kubeCorePath(opts.Namespace, "configmaps", opts.ConfigMapName)); err != nil {
 		return err
 	}
-	if err := deleteSSOSecret(opts); err != nil {
+	if err := kube.delete(kubeCorePath(opts.Namespace, "secrets", opts.SecretName)); err != nil {
 		return err
 	}
 	if !opts.NoRollout && workloadChanged {
-		if err := waitForSSOWorkloadRollout(opts); err != nil {
+		if err := kube.waitForStatefulSetRollout(opts.Namespace, opts.WorkloadName, ssoRolloutTimeout); err != nil {
 			return err
 		}
 	}
@@ -403,8 +497,8 @@ func removeLocalSSOConfig(configMap ConfigMap) error {
 	return configMap.SaveConfig()
 }
 
-func applySSOConfigMap(opts ssoOptions) error {
-	data := map[string]string{
+func ssoConfigMapData(opts ssoOptions) map[string]string {
+	return map[string]string{
 		"OIDC_ISSUER_URL":                    opts.IssuerURL,
 		"OIDC_JWKS_URL":                      opts.JWKSURL,
 		"OIDC_AUDIENCE":                      opts.Audience,
@@ -420,6 +514,9 @@ func applySSOConfigMap(opts ssoOptions) error {
 		"SSO_NAMESPACE_HASH_LENGTH":          opts.NamespaceHashLength,
 		"SSO_NAMESPACE_MAX_LENGTH":           opts.NamespaceMaxLength,
 	}
+}
+
+func applySSOConfigMap(kube *kubeClient, opts ssoOptions) error {
 	obj := map[string]interface{}{
 		"apiVersion": "v1",
 		"kind":       "ConfigMap",
@@ -427,17 +524,12 @@ func applySSOConfigMap(opts ssoOptions) error {
 			"name":      opts.ConfigMapName,
 			"namespace": opts.Namespace,
 		},
-		"data": data,
-	}
-	payload, err := json.Marshal(obj)
-	if err != nil {
-		return err
+		"data": ssoConfigMapData(opts),
 	}
-	_, err = runSSOCommand("kubectl", []string{"apply", "-f", "-"}, payload)
-	return err
+	return kube.apply(kubeCorePath(opts.Namespace, "configmaps", opts.ConfigMapName), obj)
 }
 
-func applySSOSecret(opts ssoOptions) error {
+func applySSOSecret(kube *kubeClient, opts ssoOptions) error {
 	obj := map[string]interface{}{
 		"apiVersion": "v1",
 		"kind":       "Secret",
@@ -450,40 +542,52 @@ func applySSOSecret(opts ssoOptions) error {
 			"OIDC_CLIENT_SECRET": opts.ClientSecret,
 		},
 	}
-	payload, err := json.Marshal(obj)
+	return kube.apply(kubeCorePath(opts.Namespace, "secrets", opts.SecretName), obj)
+}
+
+func reconcileSSOEnvFrom(kube *kubeClient, opts ssoOptions, enabled bool) (bool, error) {
+	operations, err := planSSOEnvFrom(kube, opts, enabled)
+	if err != nil || len(operations) == 0 {
+		return false, err
+	}
+
+	payload, err := json.Marshal(operations)
 	if err != nil {
-		return err
+		return false, err
 	}
-	_, err = runSSOCommand("kubectl", []string{"apply", "-f", "-"}, payload)
-	return err
+	err = kube.patch(kubeStatefulSetPath(opts.Namespace, opts.WorkloadName), "application/json-patch+json", payload)
+	return err == nil, err
 }
 
-func reconcileSSOEnvFrom(opts ssoOptions, enabled bool) (bool, error) {
-	output, err := runSSOCommand("kubectl", []string{
-		"-n", opts.Namespace,
-		"get", "statefulset", opts.WorkloadName,
-		"-o", "json",
-	}, nil)
+// getSSOContainerEnvFrom returns the index and envFrom of the admin-api
+// container in its StatefulSet.
+func getSSOContainerEnvFrom(kube *kubeClient, opts ssoOptions) (int, []ssoEnvFromSource, error) {
+	output, err := kube.get(kubeStatefulSetPath(opts.Namespace, opts.WorkloadName))
 	if err != nil {
-		return false, err
+		return -1, nil, err
+	}
+	if output == nil {
+		return -1, nil, fmt.Errorf("statefulset %s/%s not found", opts.Namespace, opts.WorkloadName)
 	}
 
 	var workload ssoWorkload
 	if err := json.Unmarshal(output, &workload); err != nil {
-		return false, fmt.Errorf("decode statefulset %s/%s: %w", opts.Namespace, opts.WorkloadName, err)
+		return -1, nil, fmt.Errorf("decode statefulset %s/%s: %w", opts.Namespace, opts.WorkloadName, err)
 	}
-
-	containerIndex := -1
-	var current []ssoEnvFromSource
 	for index, container := range workload.Spec.Template.Spec.Containers {
 		if container.Name == opts.ContainerName {
-			containerIndex = index
-			current = container.EnvFrom
-			break
+			return index, container.EnvFrom, nil
 		}
 	}
-	if containerIndex < 0 {
-		return false, fmt.Errorf("container %s not found in statefulset %s/%s", opts.ContainerName, opts.Namespace, opts.WorkloadName)
+	return -1, nil, fmt.Errorf("container %s not found in statefulset %s/%s", opts.ContainerName, opts.Namespace, opts.WorkloadName)
+}
+
+// planSSOEnvFrom reads the admin-api workload and returns the JSON patch
+// operations that bring its envFrom to the desired state, if any.
+func planSSOEnvFrom(kube *kubeClient, opts ssoOptions, enabled bool) ([]jsonPatchOperation, error) {
+	containerIndex, current, err := getSSOContainerEnvFrom(kube, opts)
+	if err != nil {
+		return nil, err
 	}
 
 	desired := make([]ssoEnvFromSource, 0, 2)
@@ -520,7 +624,7 @@ func reconcileSSOEnvFrom(opts ssoOptions, enabled bool) (bool, error) {
 		}
 	}
 	if len(removeIndexes) == 0 && len(missing) == 0 {
-		return false, nil
+		return nil, nil
 	}
 
 	basePath := fmt.Sprintf("/spec/template/spec/containers/%d/envFrom", containerIndex)
@@ -552,17 +656,7 @@ func reconcileSSOEnvFrom(opts ssoOptions, enabled bool) (bool, error) {
 			})
 		}
 	}
-
-	payload, err := json.Marshal(operations)
-	if err != nil {
-		return false, err
-	}
-	_, err = runSSOCommand("kubectl", []string{
-		"-n", opts.Namespace,
-		"patch", "statefulset", opts.WorkloadName,
-		"--type=json", "-p", string(payload),
-	}, nil)
-	return err == nil, err
+	return operations, nil
 }
 
 func managedSSOEnvFromKey(source ssoEnvFromSource, opts ssoOptions) (string, bool) {
@@ -592,27 +686,11 @@ func desiredSSOEnvFromKey(key string, desired []ssoEnvFromSource) bool {
 	return false
 }
 
-func deleteSSOConfigMap(opts ssoOptions) error {
-	_, err := runSSOCommand("kubectl", []string{"-n", opts.Namespace, "delete", "configmap", opts.ConfigMapName, "--ignore-not-found"}, nil)
-	return err
-}
-
-func deleteSSOSecret(opts ssoOptions) error {
-	_, err := runSSOCommand("kubectl", []string{"-n", opts.Namespace, "delete", "secret", opts.SecretName, "--ignore-not-found"}, nil)
-	return err
-}
-
-func rolloutSSOWorkload(opts ssoOptions) error {
-	if _, err := runSSOCommand("kubectl", []string{"-n", opts.Namespace, "rollout", "restart", "statefulset/" + opts.WorkloadName}, nil); err != nil {
+func rolloutSSOWorkload(kube *kubeClient, opts ssoOptions) error {
+	if err := kube.restartStatefulSet(opts.Namespace, opts.WorkloadName); err != nil {
 		return err
 	}
-	_, err := runSSOCommand("kubectl", []string{"-n", opts.Namespace, "rollout", "status", "statefulset/" + opts.WorkloadName, "--timeout=180s"}, nil)
-	return err
-}
-
-func waitForSSOWorkloadRollout(opts ssoOptions) error {
-	_, err := runSSOCommand("kubectl", []string{"-n", opts.Namespace, "rollout", "status", "statefulset/" + opts.WorkloadName, "--timeout=180s"}, nil)
-	return err
+	return kube.waitForStatefulSetRollout(opts.Namespace, opts.WorkloadName, ssoRolloutTimeout)
 }
 
 func ssoClientMode(opts ssoOptions) string {
@@ -638,52 +716,3 @@ func isSecretLikeKey(key string) bool {
 	}
 	return false
 }
-
-func realCommandRunner(name string, args []string, stdin []byte) ([]byte, error) {
-	cmdName := name
-	if _, err := exec.LookPath(cmdName); err != nil && name == "kubectl" {
-		if home, homeErr := os.UserHomeDir(); homeErr == nil {
-			opsKubectl := home + "/.ops/linux-amd64/bin/kubectl"
-			if _, statErr := os.Stat(opsKubectl); statErr == nil {
-				cmdName = opsKubectl
-			}
-		}
-	}
-
-	cmd := exec.Command(cmdName, args...)
-	if stdin != nil {
-		cmd.Stdin = bytes.NewReader(stdin)
-	}
-	var stdout bytes.Buffer
-	var stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	err := cmd.Run()
-	output := append(stdout.Bytes(), stderr.Bytes()...)
-	if err != nil {
-		if len(output) > 0 {
-			return output, fmt.Errorf("%s %s failed: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
-		}
-		return output, fmt.Errorf("%s %s failed: %w", name, strings.Join(args, " "), err)
-	}
-	if len(output) > 0 && !isSSOWorkloadJSONGet(name, args) {
-		fmt.Print(string(output))
-	}
-	return output, nil
-}
-
-func isSSOWorkloadJSONGet(name string, args []string) bool {
-	if name != "kubectl" {
-		return false
-	}
-	for index := 0; index+1 < len(args); index++ {
-		if args[index] == "get" && index+2 < len(args) && args[index+1] == "statefulset" {
-			for outputIndex := index + 2; outputIndex+1 < len(args); outputIndex++ {
-				if args[outputIndex] == "-o" && args[outputIndex+1] == "json" {
-					return true
-				}
-			}
-		}
-	}
-	return false
-}
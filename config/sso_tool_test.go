@@ -18,6 +18,7 @@
 package config
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"os"
 	"path/filepath"
@@ -28,35 +29,6 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-type recordedCommand struct {
-	name  string
-	args  []string
-	stdin string
-}
-
-func ssoWorkloadJSON(envFrom ...ssoEnvFromSource) []byte {
-	workload := map[string]interface{}{
-		"spec": map[string]interface{}{
-			"template": map[string]interface{}{
-				"spec": map[string]interface{}{
-					"containers": []map[string]interface{}{
-						{
-							"name":    defaultSSOContainer,
-							"envFrom": envFrom,
-						},
-					},
-				},
-			},
-		},
-	}
-	payload, _ := json.Marshal(workload)
-	return payload
-}
-
-func isSSOWorkloadGet(args []string) bool {
-	return len(args) >= 7 && args[2] == "get" && args[3] == "statefulset" && args[6] == "json"
-}
-
 func managedConfigMapSource() ssoEnvFromSource {
 	return ssoEnvFromSource{ConfigMapRef: &ssoLocalObjectReference{Name: defaultSSOConfigMap}}
 }
@@ -137,16 +109,7 @@ func TestConfigSSOToolKeycloak(t *testing.T) {
 	cm, err := NewConfigMapBuilder().WithConfigJson(configPath).Build()
 	require.NoError(t, err)
 
-	var commands []recordedCommand
-	oldRunner := runSSOCommand
-	runSSOCommand = func(name string, args []string, stdin []byte) ([]byte, error) {
-		commands = append(commands, recordedCommand{name: name, args: args, stdin: string(stdin)})
-		if isSSOWorkloadGet(args) {
-			return ssoWorkloadJSON(), nil
-		}
-		return []byte("ok"), nil
-	}
-	defer func() { runSSOCommand = oldRunner }()
+	api := newFakeKubeAPI(t, &fakeSSOWorkloadState{})
 
 	err = ConfigSSOTool(cm, []string{
 		"keycloak",
@@ -166,13 +129,17 @@ func TestConfigSSOToolKeycloak(t *testing.T) {
 	require.Equal(t, true, gotConfig["sso"].(map[string]interface{})["autoprovision"].(map[string]interface{})["on"].(map[string]interface{})["login"])
 	require.Equal(t, float64(120), gotConfig["sso"].(map[string]interface{})["autoprovision"].(map[string]interface{})["timeout"].(map[string]interface{})["seconds"])
 
-	require.Len(t, commands, 3)
-	require.Equal(t, []string{"apply", "-f", "-"}, commands[0].args)
-	require.Equal(t, []string{"-n", "nuvolaris", "get", "statefulset", "nuvolaris-system-api", "-o", "json"}, commands[1].args)
-	require.Equal(t, []string{"-n", "nuvolaris", "patch", "statefulset", "nuvolaris-system-api", "--type=json", "-p", commands[2].args[7]}, commands[2].args)
-
-	var cmObj map[string]interface{}
-	require.NoError(t, json.Unmarshal([]byte(commands[0].stdin), &cmObj))
+	require.Equal(t, []string{
+		// snapshot
+		"GET " + fakeConfigMapPath,
+		"GET " + fakeSecretPath,
+		"GET " + fakeStatefulSetPath,
+		"PATCH " + fakeConfigMapPath,
+		"GET " + fakeStatefulSetPath,
+		"PATCH " + fakeStatefulSetPath,
+	}, api.calls())
+
+	cmObj := api.object(fakeConfigMapPath)
 	require.Equal(t, "ConfigMap", cmObj["kind"])
 	data := cmObj["data"].(map[string]interface{})
 	require.Equal(t, "openserverless-admin-api", data["OIDC_AUDIENCE"])
@@ -194,16 +161,7 @@ func TestConfigSSOToolKeycloakRollsOutAdminAPIByDefault(t *testing.T) {
 	cm, err := NewConfigMapBuilder().WithConfigJson(configPath).Build()
 	require.NoError(t, err)
 
-	var commands []recordedCommand
-	oldRunner := runSSOCommand
-	runSSOCommand = func(name string, args []string, stdin []byte) ([]byte, error) {
-		commands = append(commands, recordedCommand{name: name, args: args, stdin: string(stdin)})
-		if isSSOWorkloadGet(args) {
-			return ssoWorkloadJSON(), nil
-		}
-		return []byte("ok"), nil
-	}
-	defer func() { runSSOCommand = oldRunner }()
+	api := newFakeKubeAPI(t, &fakeSSOWorkloadState{})
 
 	err = ConfigSSOTool(cm, []string{
 		"keycloak",
@@ -215,12 +173,19 @@ func TestConfigSSOToolKeycloakRollsOutAdminAPIByDefault(t *testing.T) {
 	})
 	require.NoError(t, err)
 
-	require.Len(t, commands, 5)
-	require.Equal(t, []string{"apply", "-f", "-"}, commands[0].args)
-	require.Equal(t, []string{"-n", "nuvolaris", "get", "statefulset", "nuvolaris-system-api", "-o", "json"}, commands[1].args)
-	require.Equal(t, []string{"-n", "nuvolaris", "patch", "statefulset", "nuvolaris-system-api", "--type=json", "-p", commands[2].args[7]}, commands[2].args)
-	require.Equal(t, []string{"-n", "nuvolaris", "rollout", "restart", "statefulset/nuvolaris-system-api"}, commands[3].args)
-	require.Equal(t, []string{"-n", "nuvolaris", "rollout", "status", "statefulset/nuvolaris-system-api", "--timeout=180s"}, commands[4].args)
+	require.Equal(t, []string{
+		// snapshot
+		"GET " + fakeConfigMapPath,
+		"GET " + fakeSecretPath,
+		"GET " + fakeStatefulSetPath,
+		"PATCH " + fakeConfigMapPath,
+		"GET " + fakeStatefulSetPath,
+		"PATCH " + fakeStatefulSetPath,
+		// rollout restart, then rollout status
+		"PATCH " + fakeStatefulSetPath,
+		"GET " + fakeStatefulSetPath,
+	}, api.calls())
+	require.Equal(t, 1, api.restarts)
 }
 
 func TestConfigSSOToolKeycloakWithClientSecret(t *testing.T) {
@@ -229,16 +194,7 @@ func TestConfigSSOToolKeycloakWithClientSecret(t *testing.T) {
 	cm, err := NewConfigMapBuilder().WithConfigJson(configPath).Build()
 	require.NoError(t, err)
 
-	var commands []recordedCommand
-	oldRunner := runSSOCommand
-	runSSOCommand = func(name string, args []string, stdin []byte) ([]byte, error) {
-		commands = append(commands, recordedCommand{name: name, args: args, stdin: string(stdin)})
-		if isSSOWorkloadGet(args) {
-			return ssoWorkloadJSON(), nil
-		}
-		return []byte("ok"), nil
-	}
-	defer func() { runSSOCommand = oldRunner }()
+	api := newFakeKubeAPI(t, &fakeSSOWorkloadState{})
 
 	err = ConfigSSOTool(cm, []string{
 		"keycloak",
@@ -264,26 +220,35 @@ func TestConfigSSOToolKeycloakWithClientSecret(t *testing.T) {
 	require.Equal(t, "true", flat["SSO_OIDC_CLIENT_SECRET_CONFIGURED"])
 	require.Equal(t, "custom-sso-secret", flat["SSO_KUBE_SECRET"])
 
-	require.Len(t, commands, 4)
-
-	var cmObj map[string]interface{}
-	require.NoError(t, json.Unmarshal([]byte(commands[0].stdin), &cmObj))
+	customSecretPath := "/api/v1/namespaces/nuvolaris/secrets/custom-sso-secret"
+	require.Equal(t, []string{
+		// snapshot
+		"GET " + fakeConfigMapPath,
+		"GET " + customSecretPath,
+		"GET " + fakeStatefulSetPath,
+		"PATCH " + fakeConfigMapPath,
+		"PATCH " + customSecretPath,
+		"GET " + fakeStatefulSetPath,
+		"PATCH " + fakeStatefulSetPath,
+	}, api.calls())
+
+	cmObj := api.object(fakeConfigMapPath)
 	require.Equal(t, "ConfigMap", cmObj["kind"])
 	cmData := cmObj["data"].(map[string]interface{})
 	require.Equal(t, "openserverless-admin-api", cmData["OIDC_AUDIENCE"])
 	require.Equal(t, "openserverless-admin-api", cmData["OIDC_CLIENT_ID"])
-	require.NotContains(t, commands[0].stdin, "super-secret")
+	cmJSON, err := json.Marshal(cmObj)
+	require.NoError(t, err)
+	require.NotContains(t, string(cmJSON), "super-secret")
 
-	var secretObj map[string]interface{}
-	require.NoError(t, json.Unmarshal([]byte(commands[1].stdin), &secretObj))
+	secretObj := api.object(customSecretPath)
 	require.Equal(t, "Secret", secretObj["kind"])
 	require.Equal(t, "custom-sso-secret", secretObj["metadata"].(map[string]interface{})["name"])
-	secretData := secretObj["stringData"].(map[string]interface{})
-	require.Equal(t, "super-secret", secretData["OIDC_CLIENT_SECRET"])
+	secretData := secretObj["data"].(map[string]interface{})
+	require.Equal(t, base64.StdEncoding.EncodeToString([]byte("super-secret")), secretData["OIDC_CLIENT_SECRET"])
 
-	require.Equal(t, []string{"-n", "nuvolaris", "get", "statefulset", "nuvolaris-system-api", "-o", "json"}, commands[2].args)
-	require.Equal(t, []string{"-n", "nuvolaris", "patch", "statefulset", "nuvolaris-system-api", "--type=json", "-p", commands[3].args[7]}, commands[3].args)
-	require.Contains(t, commands[3].args[7], "custom-sso-secret")
+	require.Len(t, api.patches, 1)
+	require.Contains(t, api.patches[0], "custom-sso-secret")
 }
 
 func TestConfigSSOToolKeycloakRequiresValues(t *testing.T) {
@@ -314,16 +279,9 @@ func TestConfigSSOToolDisable(t *testing.T) {
 	cm, err := NewConfigMapBuilder().WithConfigJson(configPath).Build()
 	require.NoError(t, err)
 
-	var commands []recordedCommand
-	oldRunner := runSSOCommand
-	runSSOCommand = func(name string, args []string, stdin []byte) ([]byte, error) {
-		commands = append(commands, recordedCommand{name: name, args: args, stdin: string(stdin)})
-		if isSSOWorkloadGet(args) {
-			return ssoWorkloadJSON(managedConfigMapSource(), managedSecretSource()), nil
-		}
-		return []byte("ok"), nil
-	}
-	defer func() { runSSOCommand = oldRunner }()
+	api := newFakeKubeAPI(t, &fakeSSOWorkloadState{EnvFrom: []ssoEnvFromSource{managedConfigMapSource(), managedSecretSource()}})
+	api.seed(fakeConfigMapPath, map[string]string{"OIDC_ISSUER_URL": "http://issuer"})
+	api.seed(fakeSecretPath, map[string]string{"OIDC_CLIENT_SECRET": "c2VjcmV0"})
 
 	err = ConfigSSOTool(cm, []string{"disable", "--no-rollout"})
 	require.NoError(t, err)
@@ -335,11 +293,18 @@ func TestConfigSSOToolDisable(t *testing.T) {
 			"external": "preserve-me",
 		},
 	}, gotConfig)
-	require.Len(t, commands, 4)
-	require.Equal(t, []string{"-n", "nuvolaris", "get", "statefulset", "nuvolaris-system-api", "-o", "json"}, commands[0].args)
-	require.Equal(t, []string{"-n", "nuvolaris", "patch", "statefulset", "nuvolaris-system-api", "--type=json", "-p", commands[1].args[7]}, commands[1].args)
-	require.Equal(t, []string{"-n", "nuvolaris", "delete", "configmap", "openserverless-sso-config", "--ignore-not-found"}, commands[2].args)
-	require.Equal(t, []string{"-n", "nuvolaris", "delete", "secret", "openserverless-sso-secret", "--ignore-not-found"}, commands[3].args)
+	require.Equal(t, []string{
+		// snapshot
+		"GET " + fakeConfigMapPath,
+		"GET " + fakeSecretPath,
+		"GET " + fakeStatefulSetPath,
+		"GET " + fakeStatefulSetPath,
+		"PATCH " + fakeStatefulSetPath,
+		"DELETE " + fakeConfigMapPath,
+		"DELETE " + fakeSecretPath,
+	}, api.calls())
+	require.Nil(t, api.object(fakeConfigMapPath))
+	require.Nil(t, api.object(fakeSecretPath))
 }
 
 func TestConfigSSOToolDisableWaitsForAdminAPIRolloutByDefault(t *testing.T) {
@@ -348,26 +313,24 @@ func TestConfigSSOToolDisableWaitsForAdminAPIRolloutByDefault(t *testing.T) {
 	cm, err := NewConfigMapBuilder().WithConfigJson(configPath).Build()
 	require.NoError(t, err)
 
-	var commands []recordedCommand
-	oldRunner := runSSOCommand
-	runSSOCommand = func(name string, args []string, stdin []byte) ([]byte, error) {
-		commands = append(commands, recordedCommand{name: name, args: args, stdin: string(stdin)})
-		if isSSOWorkloadGet(args) {
-			return ssoWorkloadJSON(managedConfigMapSource(), managedSecretSource()), nil
-		}
-		return []byte("ok"), nil
-	}
-	defer func() { runSSOCommand = oldRunner }()
+	api := newFakeKubeAPI(t, &fakeSSOWorkloadState{EnvFrom: []ssoEnvFromSource{managedConfigMapSource(), managedSecretSource()}})
+	api.rolloutPending = true
 
 	err = ConfigSSOTool(cm, []string{"disable"})
 	require.NoError(t, err)
 
-	require.Len(t, commands, 5)
-	require.Equal(t, []string{"-n", "nuvolaris", "get", "statefulset", "nuvolaris-system-api", "-o", "json"}, commands[0].args)
-	require.Equal(t, []string{"-n", "nuvolaris", "patch", "statefulset", "nuvolaris-system-api", "--type=json", "-p", commands[1].args[7]}, commands[1].args)
-	require.Equal(t, []string{"-n", "nuvolaris", "delete", "configmap", "openserverless-sso-config", "--ignore-not-found"}, commands[2].args)
-	require.Equal(t, []string{"-n", "nuvolaris", "delete", "secret", "openserverless-sso-secret", "--ignore-not-found"}, commands[3].args)
-	require.Equal(t, []string{"-n", "nuvolaris", "rollout", "status", "statefulset/nuvolaris-system-api", "--timeout=180s"}, commands[4].args)
+	require.Equal(t, []string{
+		// snapshot
+		"GET " + fakeConfigMapPath,
+		"GET " + fakeSecretPath,
+		"GET " + fakeStatefulSetPath,
+		"GET " + fakeStatefulSetPath,
+		"PATCH " + fakeStatefulSetPath,
+		"DELETE " + fakeConfigMapPath,
+		"DELETE " + fakeSecretPath,
+		"GET " + fakeStatefulSetPath,
+		"GET /apis/apps/v1/namespaces/nuvolaris/statefulsets",
+	}, api.calls())
 }
 
 func TestConfigSSOToolDisableAlreadyAbsentDoesNotPatchOrRollOut(t *testing.T) {
@@ -379,22 +342,18 @@ func TestConfigSSOToolDisableAlreadyAbsentDoesNotPatchOrRollOut(t *testing.T) {
 	foreign := ssoEnvFromSource{
 		ConfigMapRef: &ssoLocalObjectReference{Name: "application-config"},
 	}
-	var commands []recordedCommand
-	oldRunner := runSSOCommand
-	runSSOCommand = func(name string, args []string, stdin []byte) ([]byte, error) {
-		commands = append(commands, recordedCommand{name: name, args: args, stdin: string(stdin)})
-		if isSSOWorkloadGet(args) {
-			return ssoWorkloadJSON(foreign), nil
-		}
-		return []byte("ok"), nil
-	}
-	defer func() { runSSOCommand = oldRunner }()
+	api := newFakeKubeAPI(t, &fakeSSOWorkloadState{EnvFrom: []ssoEnvFromSource{foreign}})
 
 	require.NoError(t, ConfigSSOTool(cm, []string{"disable"}))
-	require.Len(t, commands, 3)
-	require.Equal(t, []string{"-n", "nuvolaris", "get", "statefulset", "nuvolaris-system-api", "-o", "json"}, commands[0].args)
-	require.Equal(t, []string{"-n", "nuvolaris", "delete", "configmap", "openserverless-sso-config", "--ignore-not-found"}, commands[1].args)
-	require.Equal(t, []string{"-n", "nuvolaris", "delete", "secret", "openserverless-sso-secret", "--ignore-not-found"}, commands[2].args)
+	require.Equal(t, []string{
+		// snapshot
+		"GET " + fakeConfigMapPath,
+		"GET " + fakeSecretPath,
+		"GET " + fakeStatefulSetPath,
+		"GET " + fakeStatefulSetPath,
+		"DELETE " + fakeConfigMapPath,
+		"DELETE " + fakeSecretPath,
+	}, api.calls())
 }
 
 func TestConfigSSOToolPreservesForeignWorkloadFieldsAcrossEnableDisableEnable(t *testing.T) {
@@ -425,23 +384,7 @@ func TestConfigSSOToolPreservesForeignWorkloadFieldsAcrossEnableDisableEnable(t
 	originalVolumes, err := json.Marshal(state.Volumes)
 	require.NoError(t, err)
 
-	var commands []recordedCommand
-	var patchPayloads []string
-	oldRunner := runSSOCommand
-	runSSOCommand = func(name string, args []string, stdin []byte) ([]byte, error) {
-		commands = append(commands, recordedCommand{name: name, args: args, stdin: string(stdin)})
-		if isSSOWorkloadGet(args) {
-			return state.workloadJSON(), nil
-		}
-		if len(args) >= 8 && args[2] == "patch" && args[5] == "--type=json" {
-			patchPayloads = append(patchPayloads, args[7])
-			if err := state.applyJSONPatch(args[7]); err != nil {
-				return nil, err
-			}
-		}
-		return []byte("ok"), nil
-	}
-	defer func() { runSSOCommand = oldRunner }()
+	api := newFakeKubeAPI(t, &state)
 
 	enableArgs := []string{
 		"keycloak",
@@ -466,18 +409,18 @@ func TestConfigSSOToolPreservesForeignWorkloadFieldsAcrossEnableDisableEnable(t
 		{ConfigMapRef: &ssoLocalObjectReference{Name: "application-config"}},
 		{SecretRef: &ssoLocalObjectReference{Name: "database-credentials"}},
 	}, state.EnvFrom)
-	require.Len(t, patchPayloads, 2)
+	require.Len(t, api.patches, 2)
 	require.JSONEq(t, `[
   {"op":"test","path":"/spec/template/spec/containers/0/envFrom/3","value":{"secretRef":{"name":"openserverless-sso-secret"}}},
   {"op":"remove","path":"/spec/template/spec/containers/0/envFrom/3"},
   {"op":"test","path":"/spec/template/spec/containers/0/envFrom/2","value":{"configMapRef":{"name":"openserverless-sso-config"}}},
   {"op":"remove","path":"/spec/template/spec/containers/0/envFrom/2"}
-]`, patchPayloads[1])
+]`, api.patches[1])
 
-	// Repeating disable is idempotent: resources are deleted with
-	// --ignore-not-found and no StatefulSet patch or rollout is produced.
+	// Repeating disable is idempotent: missing resources are not errors and
+	// no StatefulSet patch or rollout is produced.
 	require.NoError(t, ConfigSSOTool(cm, []string{"disable", "--no-rollout"}))
-	require.Len(t, patchPayloads, 2)
+	require.Len(t, api.patches, 2)
 
 	require.NoError(t, ConfigSSOTool(cm, enableArgs))
 	require.Equal(t, []ssoEnvFromSource{
@@ -486,7 +429,7 @@ func TestConfigSSOToolPreservesForeignWorkloadFieldsAcrossEnableDisableEnable(t
 		managedConfigMapSource(),
 		managedSecretSource(),
 	}, state.EnvFrom)
-	require.Len(t, patchPayloads, 3)
+	require.Len(t, api.patches, 3)
 
 	currentEnv, err := json.Marshal(state.Env)
 	require.NoError(t, err)
@@ -497,7 +440,8 @@ func TestConfigSSOToolPreservesForeignWorkloadFieldsAcrossEnableDisableEnable(t
 	require.JSONEq(t, string(originalEnv), string(currentEnv))
 	require.JSONEq(t, string(originalMounts), string(currentMounts))
 	require.JSONEq(t, string(originalVolumes), string(currentVolumes))
-	for _, command := range commands {
-		require.NotContains(t, command.args, "rollout")
+	require.Zero(t, api.restarts)
+	for _, call := range api.calls() {
+		require.NotEqual(t, "GET /apis/apps/v1/namespaces/nuvolaris/statefulsets", call)
 	}
 }
@@ -0,0 +1,143 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// TLSOptions describes how ops talks to OpenServerless and identity provider
+// endpoints. They are read from the environment, so they can be set either
+// in the shell or in config.json:
+//
+//	OPS_CA_FILE       PEM bundle of additional trusted certificate authorities
+//	OPS_CLIENT_CERT   PEM client certificate for mutual TLS
+//	OPS_CLIENT_KEY    PEM private key of the client certificate
+//	OPS_INSECURE      skip server certificate verification (not recommended)
+//	OPS_PROXY         proxy URL; HTTPS_PROXY, HTTP_PROXY and NO_PROXY are used otherwise
+type TLSOptions struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	Insecure bool
+	Proxy    string
+}
+
+var insecureWarning sync.Once
+
+func TLSOptionsFromEnv() TLSOptions {
+	insecure := strings.ToLower(strings.TrimSpace(os.Getenv("OPS_INSECURE")))
+	return TLSOptions{
+		CAFile:   os.Getenv("OPS_CA_FILE"),
+		CertFile: os.Getenv("OPS_CLIENT_CERT"),
+		KeyFile:  os.Getenv("OPS_CLIENT_KEY"),
+		Insecure: insecure == "1" || insecure == "true" || insecure == "yes",
+		Proxy:    os.Getenv("OPS_PROXY"),
+	}
+}
+
+// TLSConfig builds the client TLS configuration. The system roots are always
+// trusted; OPS_CA_FILE adds to them instead of replacing them.
+func (o TLSOptions) TLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: o.Insecure, //nolint:gosec
+	}
+
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read OPS_CA_FILE: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in OPS_CA_FILE %s", o.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		if o.CertFile == "" || o.KeyFile == "" {
+			return nil, fmt.Errorf("OPS_CLIENT_CERT and OPS_CLIENT_KEY must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load the client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// Transport returns a transport with the TLS and proxy settings applied.
+func (o TLSOptions) Transport() (*http.Transport, error) {
+	tlsConfig, err := o.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.Proxy = http.ProxyFromEnvironment
+	if o.Proxy != "" {
+		proxyURL, err := url.Parse(o.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OPS_PROXY: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return transport, nil
+}
+
+// ConfigureHTTPTransport installs the TLS options from the environment as the
+// process default transport, so the login, the wsk wrapper (which uses
+// http.DefaultClient) and runtimes discovery all share them. Passing insecure
+// forces certificate verification off, as the --insecure flags do.
+//
+// The whisk client of the wsk wrapper uses its own transport, without
+// OPS_CA_FILE and OPS_PROXY, when it has a client certificate (wsk --cert
+// and --key, or CERT and KEY in .wskprops): use OPS_CLIENT_CERT and
+// OPS_CLIENT_KEY instead.
+func ConfigureHTTPTransport(insecure bool) error {
+	opts := TLSOptionsFromEnv()
+	opts.Insecure = opts.Insecure || insecure
+
+	transport, err := opts.Transport()
+	if err != nil {
+		return err
+	}
+	if opts.Insecure {
+		insecureWarning.Do(func() {
+			fmt.Fprintln(os.Stderr, "WARNING: TLS certificate verification is disabled (--insecure or OPS_INSECURE). Connections are not secure.")
+		})
+	}
+
+	http.DefaultTransport = transport
+	http.DefaultClient.Transport = transport
+	return nil
+}
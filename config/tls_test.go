@@ -0,0 +1,134 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeServerCA(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(block), 0600))
+	return caFile
+}
+
+func localhostKeyPEM(t *testing.T, server *httptest.Server) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(server.TLS.Certificates[0].PrivateKey)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func getWith(t *testing.T, opts TLSOptions, target string) error {
+	t.Helper()
+	transport, err := opts.Transport()
+	require.NoError(t, err)
+	resp, err := (&http.Client{Transport: transport}).Get(target)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return err
+}
+
+func TestTLSOptions(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	t.Run("untrusted server is rejected by default", func(t *testing.T) {
+		require.Error(t, getWith(t, TLSOptions{}, server.URL))
+	})
+
+	t.Run("OPS_CA_FILE makes the server trusted", func(t *testing.T) {
+		require.NoError(t, getWith(t, TLSOptions{CAFile: writeServerCA(t, server)}, server.URL))
+	})
+
+	t.Run("insecure skips verification", func(t *testing.T) {
+		require.NoError(t, getWith(t, TLSOptions{Insecure: true}, server.URL))
+	})
+
+	t.Run("invalid CA file is an error", func(t *testing.T) {
+		caFile := filepath.Join(t.TempDir(), "ca.pem")
+		require.NoError(t, os.WriteFile(caFile, []byte("not a certificate"), 0600))
+		_, err := TLSOptions{CAFile: caFile}.TLSConfig()
+		require.ErrorContains(t, err, "no PEM certificates")
+	})
+
+	t.Run("client certificate needs both files", func(t *testing.T) {
+		_, err := TLSOptions{CertFile: "cert.pem"}.TLSConfig()
+		require.ErrorContains(t, err, "must be set together")
+	})
+
+	t.Run("OPS_PROXY overrides the environment proxy", func(t *testing.T) {
+		transport, err := TLSOptions{Proxy: "http://proxy.example.test:3128"}.Transport()
+		require.NoError(t, err)
+		proxyURL, err := transport.Proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: "api.example.test"}})
+		require.NoError(t, err)
+		require.Equal(t, "proxy.example.test:3128", proxyURL.Host)
+	})
+}
+
+func TestTLSOptionsMutualTLS(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := writeServerCA(t, server)
+	require.Error(t, getWith(t, TLSOptions{CAFile: caFile}, server.URL))
+
+	// reuse the httptest certificate as the client certificate
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, localhostKeyPEM(t, server), 0600))
+
+	require.NoError(t, getWith(t, TLSOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}, server.URL))
+}
+
+func TestConfigureHTTPTransport(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	oldTransport := http.DefaultTransport
+	oldClientTransport := http.DefaultClient.Transport
+	defer func() {
+		http.DefaultTransport = oldTransport
+		http.DefaultClient.Transport = oldClientTransport
+	}()
+
+	t.Setenv("OPS_CA_FILE", writeServerCA(t, server))
+	t.Setenv("OPS_INSECURE", "")
+	require.NoError(t, ConfigureHTTPTransport(false))
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+}
@@ -94,6 +94,7 @@ func info() {
 	fmt.Println("OPS & OPS_CMD:", os.Getenv("OPS_CMD"))
 	fmt.Println("OPS_VERSION:", os.Getenv("OPS_VERSION"))
 	fmt.Println("OPS_BRANCH:", os.Getenv("OPS_BRANCH"))
+	fmt.Println("OPS_CHANNEL:", os.Getenv("OPS_CHANNEL"))
 	fmt.Println("OPS_BIN:", os.Getenv("OPS_BIN"))
 	fmt.Println("OPS_TMP:", os.Getenv("OPS_TMP"))
 	fmt.Println("OPS_HOME:", os.Getenv("OPS_HOME"))
@@ -116,9 +117,10 @@ func banner() {
 	fmt.Println("-v | -version current version (always mention this when asking for help)")
 	fmt.Println("-t | -tasks   list tasks      (show top level tasks, download if needed)")
 	fmt.Println("-i | -info    CLI infos       (show the cli environment)")
-	fmt.Println("-u | -update  download latest (get the latest tasks and prerequisites)")
+	fmt.Println("-u | -update  download latest (get the latest tasks, prerequisites and plugins)")
 	fmt.Println("-c | -config  manage config   (openserverless server configuration)")
 	fmt.Println("-l | -login   access system   (required to access openserverless)")
+	fmt.Println("-selfupdate   update ops      (install the ops release the tasks require)")
 	fmt.Println("-reset        clean downloads (if nothing works, try this)")
 	fmt.Println()
 }
@@ -138,6 +140,20 @@ func executeToolsNoDownloadAndExit(args []string) {
 		banner()
 		tools.Help(mainTools)
 		os.Exit(0)
+	case "-u", "-update":
+		// rolling back must work even when the current tasks are broken
+		if !slices.Contains(args[2:], "--rollback") {
+			return
+		}
+		opsDir, err := homedir.Expand("~/.ops")
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		if _, err := rollbackTasks(joinpath(opsDir, getOpsBranch())); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		os.Exit(0)
+
 	case "-reset":
 		home := os.Getenv("OPS_HOME")
 		if home == "" {
@@ -176,7 +192,7 @@ func executeToolsNoDownloadAndExit(args []string) {
 
 var mainTools = []string{
 	"task", "info", "update", "login", "config",
-	"retry", "plugin", "reset", "serve",
+	"retry", "plugin", "reset", "serve", "selfupdate",
 }
 
 // CLI: ops -<cmd> <args>...
@@ -197,6 +213,12 @@ func executeTools(args []string, opsHome string) int {
 		return 0
 
 	case "u", "update":
+		if slices.Contains(args[1:], "--check") {
+			if err := checkUpdates(); err != nil {
+				log.Fatalf("error: %v", err)
+			}
+			return 0
+		}
 		// ok no up, nor down, let's download it
 		dir, err := pullTasks(true, true)
 		if err != nil {
@@ -205,6 +227,13 @@ func executeTools(args []string, opsHome string) int {
 		if err := setOpsOlarisHash(dir); err != nil {
 			log.Fatal("unable to set OPS_OLARIS...", err.Error())
 		}
+		if slices.Contains(args[1:], "--no-plugins") {
+			return 0
+		}
+		if err := updateInstalledPlugins(); err != nil {
+			warn(err.Error())
+			return 1
+		}
 		return 0
 
 	case "l", "login":
@@ -215,9 +244,13 @@ func executeTools(args []string, opsHome string) int {
 			log.Fatalf("error: %s", err.Error())
 		}
 
+		// the usage was printed
 		if loginResult == nil {
 			return 1
 		}
+		if loginResult.Listed {
+			return 0
+		}
 
 		fmt.Println("Successfully logged in as " + loginResult.Login + ".")
 		if err := wskPropertySet(loginResult.ApiHost, loginResult.Auth, loginResult.Login); err != nil {
@@ -256,6 +289,20 @@ func executeTools(args []string, opsHome string) int {
 		}
 		return 0
 
+	case "p":
+		if err := exitOnPluginStatus(pluginTaskTool(args[1:])); err != nil {
+			log.Fatalf("error: %s", err.Error())
+		}
+		return 0
+
+	case "selfupdate":
+		// after the config env vars and the TLS transport are set up, as
+		// config.json can define the releases URL, the keys and the CA
+		if err := selfUpdateTool(args[1:]); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		return 0
+
 	case "serve":
 		args[0] = "-serve"
 		opsRootDir := getRootDirOrExit()
@@ -347,6 +394,11 @@ func Main() {
 	// preliminanre processing not requiring to  downloading anything
 	executeToolsNoDownloadAndExit(os.Args)
 
+	// TLS trust and proxy settings shared by login, wsk and runtimes discovery
+	if err := config.ConfigureHTTPTransport(false); err != nil {
+		log.Fatalf("cannot setup TLS: %s", err.Error())
+	}
+
 	// in case args[1] is a wsk wrapper command invoke it and exit
 	// CLI: ops action ... (wsk wrapper)
 	if len(os.Args) > 1 {
@@ -400,6 +452,10 @@ func Main() {
 	if err != nil {
 		log.Fatalf("cannot apply env vars from configs: %s", err.Error())
 	}
+	// config.json may provide OPS_CA_FILE and the other TLS settings
+	if err := config.ConfigureHTTPTransport(false); err != nil {
+		log.Fatalf("cannot setup TLS: %s", err.Error())
+	}
 
 	// preflight checks - we need at least ssh curl to proceed
 	if err := preflightChecks(); err != nil {
@@ -547,6 +603,23 @@ func isPlainEmbeddedToolAlias(name string) bool {
 }
 
 func runOps(baseDir string, args []string) error {
+	// CLI: ops <plugin>:<task> ...
+	if len(args) > 1 {
+		if name, task, ok := splitPluginTask(args[1]); ok {
+			rest := args[2:]
+			if task != "" {
+				rest = append([]string{task}, rest...)
+			}
+			_, err := runNamedPlugin(name, rest)
+			return exitOnPluginStatus(err)
+		}
+		if pluginsBeforeOlaris() {
+			if found, err := runNamedPlugin(args[1], args[2:]); found {
+				return exitOnPluginStatus(err)
+			}
+		}
+	}
+
 	err := Ops(baseDir, args[1:])
 	if err == nil {
 		return nil
@@ -557,13 +630,11 @@ func runOps(baseDir string, args []string) error {
 	var taskNotFoundErr *TaskNotFoundErr
 	if errors.As(err, &taskNotFoundErr) {
 		trace("task not found, looking for plugin:", args[1])
-		plgDir, err := findTaskInPlugins(args[1])
-		if err != nil {
+		found, err := runNamedPlugin(args[1], args[2:])
+		if !found {
 			return taskNotFoundErr
 		}
-
-		debug("Found plugin", plgDir)
-		if err := Ops(plgDir, args[2:]); err != nil {
+		if err := exitOnPluginStatus(err); err != nil {
 			log.Fatalf("error: %s", err.Error())
 		}
 		return nil
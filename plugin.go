@@ -26,8 +26,6 @@ import (
 	"regexp"
 	"strings"
 
-	git "github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/mitchellh/go-homedir"
 )
 
@@ -39,98 +37,130 @@ func pluginTool() error {
 		return err
 	}
 
-	if flagSet.NArg() > 1 {
-		flagSet.Usage()
-		return errors.New("invalid number of arguments. Expected 1")
-	}
-
 	switch flagSet.Arg(0) {
 	case "", "list":
+		if flagSet.NArg() > 1 {
+			flagSet.Usage()
+			return errors.New("invalid number of arguments. Expected 1")
+		}
 		err := printPluginsHelp()
 		if err != nil {
 			return err
 		}
 		return nil
+	case "add":
+		if flagSet.NArg() != 2 {
+			flagSet.Usage()
+			return errors.New("invalid number of arguments. Expected a plugin source")
+		}
+		return downloadPluginTasksFromRepo(flagSet.Arg(1))
+	case "search":
+		if flagSet.NArg() > 2 {
+			flagSet.Usage()
+			return errors.New("invalid number of arguments. Expected a search term")
+		}
+		return searchPlugins(flagSet.Arg(1))
+	case "install":
+		if flagSet.NArg() != 2 {
+			flagSet.Usage()
+			return errors.New("invalid number of arguments. Expected a plugin name")
+		}
+		return installIndexedPlugin(flagSet.Arg(1))
+	case "remove", "update", "info", "enable", "disable":
+		return pluginCommand(flagSet.Arg(0), flagSet.Args()[1:])
 	default:
+		if flagSet.NArg() > 1 {
+			flagSet.Usage()
+			return errors.New("invalid number of arguments. Expected 1")
+		}
 		return downloadPluginTasksFromRepo(flagSet.Arg(0))
 	}
 }
 
 func printPluginUsage() {
-	fmt.Println(`Usage: ops -plugin <repo-url>
-
-Install/update plugins from a remote repository.
-The name of the repository must start with 'olaris-'.`)
+	fmt.Println(`Usage: ops -plugin [list]
+       ops -plugin add <source>[@<ref>]
+       ops -plugin search [<term>]
+       ops -plugin install <name>[@<ref>]
+       ops -plugin remove <name>
+       ops -plugin update <name>... | --all
+       ops -plugin info <name>
+       ops -plugin disable|enable <name>
+
+Install/update plugins from a git repository.
+The source can be an https or ssh URL (https://..., ssh://..., git@host:org/repo)
+or a local path, and its last element must start with 'olaris-'.
+The optional <ref> is a branch, tag or commit; updates stay on it.
+
+Private https repositories use the git credential helpers, or the token
+in the OPS_PLUGIN_TOKEN environment variable. The token is sent only over
+https to the host in OPS_PLUGIN_TOKEN_HOST, and only when the repository
+asks for credentials.
+
+search and install use the plugin indexes listed in OPS_PLUGIN_INDEXES (URLs
+or paths of JSON/YAML catalogs, set them with ops -config). When
+OPS_PLUGIN_TRUSTED_KEYS lists ed25519 public keys, each index must have a valid
+signature in <index>.sig. Without keys an unsigned index is used with a
+warning, while a signed one is refused.
+
+Plugin commands run as ops <plugin> <task>. When a name is defined more than
+once, OPS_PLUGIN_PRECEDENCE (default olaris,local,ops) chooses between the
+olaris tasks, the local plugins and the installed ones. Use ops <plugin>:<task>
+or ops -p <plugin> <task> to run a plugin hidden by an olaris command.
+
+Executable plugins are ops-<name> programs in ~/.ops/plugins/bin or on the
+PATH, run as ops <name> with the ops environment and config. ops -plugin info
+<name> invokes them with --ops-plugin-info to print their metadata as JSON, for
+example {"description": "...", "version": "...", "help": "..."}.
+
+Disabled plugins stay installed but their commands and config are not loaded.
+Plugins declaring in plugin.json a minimum ops version, an olaris version range
+or other required plugins are not installed or loaded when these are not met.`)
 }
 
+// downloadPluginTasksFromRepo installs or updates the plugin at repo, an
+// URL or local path optionally followed by @ref.
 func downloadPluginTasksFromRepo(repo string) error {
-	isNameValid, repoName := checkGitRepo(repo)
+	source, ref := splitPluginRef(repo)
+	isNameValid, repoName := checkGitRepo(source)
 	if !isNameValid {
-		return fmt.Errorf("plugin repository must be a https url and plugin must start with 'olaris-'")
+		return fmt.Errorf("plugin repository must be a git url or path and plugin must start with 'olaris-'")
 	}
-
-	pluginDir, err := homedir.Expand("~/.ops/" + repoName)
-	if err != nil {
-		return err
-	}
-
-	if isDir(pluginDir) {
-		fmt.Println("Updating plugin", repoName)
-
-		r, err := git.PlainOpen(pluginDir)
+	if isLocalPluginSource(source) {
+		expanded, err := homedir.Expand(source)
 		if err != nil {
 			return err
 		}
-		// Get the working directory for the repository
-		w, err := r.Worktree()
-		if err != nil {
+		if source, err = filepath.Abs(expanded); err != nil {
 			return err
 		}
-
-		// Pull the latest changes from the origin remote and merge into the current branch
-		err = w.Pull(&git.PullOptions{RemoteName: "origin"})
-		if err != nil {
-			if err.Error() == "already up-to-date" {
-				fmt.Println("The plugin repo is already up to date!")
-				return nil
-			}
-			return err
-		}
-
-		return nil
-	}
-
-	if err := os.MkdirAll(pluginDir, 0755); err != nil {
-		return err
-	}
-
-	// if not, clone
-	cloneOpts := &git.CloneOptions{
-		URL:           repo,
-		Progress:      os.Stderr,
-		ReferenceName: plumbing.NewBranchReferenceName("main"),
 	}
 
-	fmt.Println("Downloading plugins:", repoName)
-	_, err = git.PlainClone(pluginDir, false, cloneOpts)
+	pluginDir, err := homedir.Expand("~/.ops/" + repoName)
 	if err != nil {
 		return err
 	}
-
-	return nil
+	return installPlugin(pluginDir, repoName, source, ref)
 }
 
 func checkGitRepo(url string) (bool, string) {
 	// Remove the ".git" extension if present
-	url = strings.TrimSuffix(url, ".git")
+	url = strings.TrimSuffix(strings.TrimSuffix(url, "/"), ".git")
 
 	// Extract the repository name from the URL
-	parts := strings.Split(url, "/")
+	parts := strings.FieldsFunc(url, func(r rune) bool { return r == '/' || r == ':' || r == filepath.Separator })
+	if len(parts) == 0 {
+		return false, ""
+	}
 	repoName := parts[len(parts)-1]
 
-	// Check if the repository name matches the pattern "https://...olaris-*"
-	matchProtocol, _ := regexp.MatchString(`^https://.*$`, url)
-	matchName, _ := regexp.MatchString(`^olaris-.*$`, repoName)
+	// Check the repository is an https, ssh or file url, an scp-like ssh
+	// address or a local path, and its name matches "olaris-*"
+	matchProtocol, _ := regexp.MatchString(`^(https|ssh|git\+ssh|file)://.+$`, url)
+	if !matchProtocol && !strings.Contains(url, "://") {
+		matchProtocol = true
+	}
+	matchName, _ := regexp.MatchString(`^olaris-.+$`, repoName)
 
 	if matchName && matchProtocol {
 		return true, repoName
@@ -143,14 +173,18 @@ func printPluginsHelp() error {
 	if err != nil {
 		return err
 	}
+	plgs.exec = findExecPlugins()
 	plgs.print()
+	for _, collision := range plgs.collisions(os.Getenv("OPS_ROOT")) {
+		warn(collision)
+	}
 	return nil
 }
 
 // GetOpsRootPlugins returns the map with all the olaris-*/opsroot.json files
 // in the local and ~/.ops folders, pointed by their plugin names.
-// If the same plugin is found in both folders, the one in the local folder
-// is used.
+// If the same plugin is found in both folders, the one first in
+// OPS_PLUGIN_PRECEDENCE (by default the local folder) is used.
 // Useful to build the config map including the plugin configs
 func GetOpsRootPlugins() (map[string]string, error) {
 	plgs, err := newPlugins()
@@ -159,20 +193,13 @@ func GetOpsRootPlugins() (map[string]string, error) {
 	}
 
 	opsRoots := make(map[string]string)
-	for _, path := range plgs.local {
+	for _, path := range orderPluginFolders(plgs.local, plgs.ops) {
 		name := getPluginName(path)
-		opsRootPath := joinpath(path, OPSROOT)
-		opsRoots[name] = opsRootPath
-	}
-
-	for _, path := range plgs.ops {
-		name := getPluginName(path)
-		// if the plugin is already in the map, skip it
+		// if the plugin is already in the map, it takes precedence
 		if _, ok := opsRoots[name]; ok {
 			continue
 		}
-		opsRootPath := joinpath(path, OPSROOT)
-		opsRoots[name] = opsRootPath
+		opsRoots[name] = joinpath(path, OPSROOT)
 	}
 
 	return opsRoots, nil
@@ -185,23 +212,9 @@ func findTaskInPlugins(plg string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-
-	// check that plg is the suffix of a folder name in plgs.local
-	for _, path := range plgs.local {
-		folder := filepath.Base(path)
-		if strings.TrimPrefix(folder, "olaris-") == plg {
-			return path, nil
-		}
-	}
-
-	// check that plg is the suffix of a folder name in plgs.ops
-	for _, path := range plgs.ops {
-		folder := filepath.Base(path)
-		if strings.TrimPrefix(folder, "olaris-") == plg {
-			return path, nil
-		}
+	if path := plgs.find(plg); path != "" {
+		return path, nil
 	}
-
 	return "", &TaskNotFoundErr{input: plg}
 }
 
@@ -209,12 +222,24 @@ func findTaskInPlugins(plg string) (string, error) {
 type plugins struct {
 	local []string
 	ops   []string
+	// disabled plugins are listed but not loaded
+	disabled []string
+	// exec maps the executable plugins to their path, filled only to list
+	// them
+	exec map[string]string
 }
 
 func newPlugins() (*plugins, error) {
 	localDir := os.Getenv("OPS_ROOT_PLUGIN")
 	localOlarisFolders := make([]string, 0)
 	opsOlarisFolders := make([]string, 0)
+	disabledFolders := make([]string, 0)
+
+	states, err := loadPluginStates()
+	if err != nil {
+		warn("cannot read the plugins state:", err)
+		states = map[string]*pluginState{}
+	}
 
 	// Search in directory (localDir/olaris-*)
 	dir := filepath.Join(localDir, "olaris-*")
@@ -228,6 +253,10 @@ func newPlugins() (*plugins, error) {
 		if !isDir(folder) || !exists(folder, OPSFILE) {
 			continue
 		}
+		if isPluginDisabled(states, folder) {
+			disabledFolders = append(disabledFolders, folder)
+			continue
+		}
 		localOlarisFolders = append(localOlarisFolders, folder)
 	}
 
@@ -245,17 +274,24 @@ func newPlugins() (*plugins, error) {
 		if !isDir(folder) || !exists(folder, OPSFILE) {
 			continue
 		}
+		if isPluginDisabled(states, folder) {
+			disabledFolders = append(disabledFolders, folder)
+			continue
+		}
 		opsOlarisFolders = append(opsOlarisFolders, folder)
 	}
 
+	localOlarisFolders, opsOlarisFolders = filterLoadablePlugins(localOlarisFolders, opsOlarisFolders)
+
 	return &plugins{
-		local: localOlarisFolders,
-		ops:   opsOlarisFolders,
+		local:    localOlarisFolders,
+		ops:      opsOlarisFolders,
+		disabled: disabledFolders,
 	}, nil
 }
 
 func (p *plugins) print() {
-	if len(p.local) == 0 && len(p.ops) == 0 {
+	if len(p.local) == 0 && len(p.ops) == 0 && len(p.disabled) == 0 && len(p.exec) == 0 {
 		debug("No plugins installed")
 		fmt.Println("No plugins installed. Use 'ops -plugin' to add new ones.")
 		return
@@ -275,6 +311,12 @@ func (p *plugins) print() {
 			fmt.Printf("  %s (ops)\n", plgName)
 		}
 	}
+
+	printExecPlugins(p.exec)
+
+	for _, plg := range p.disabled {
+		fmt.Printf("  %s (disabled)\n", getPluginName(plg))
+	}
 }
 
 // getPluginName returns the plugin name from the plugin path, removing the
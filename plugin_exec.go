@@ -0,0 +1,231 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Executable plugins are ops-<name> programs in ~/.ops/plugins/bin or on
+// the PATH, run as ops <name> <args>... with the ops environment.
+//
+// Protocol: ops sets OPS_PLUGIN_PROTOCOL and OPS_PLUGIN_NAME. Invoked with
+// the single argument --ops-plugin-info, a plugin prints a JSON
+// execPluginInfo on stdout and exits; ops uses it to list and describe the
+// plugin.
+const (
+	execPluginPrefix   = "ops-"
+	execPluginInfoArg  = "--ops-plugin-info"
+	execPluginProtocol = "1"
+)
+
+// execPluginInfoTimeout bounds the metadata handshake.
+var execPluginInfoTimeout = 5 * time.Second
+
+// execPluginInfo is the metadata an executable plugin reports.
+type execPluginInfo struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+	// Help is the usage text shown by ops -plugin info.
+	Help     string `json:"help"`
+	Commands []struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	} `json:"commands"`
+}
+
+func execPluginsDir() (string, error) {
+	home, err := pluginsHome()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "plugins", "bin"), nil
+}
+
+// execPluginSearchPath lists the folders searched for executable plugins,
+// ~/.ops/plugins/bin first.
+func execPluginSearchPath() []string {
+	dirs := []string{}
+	if dir, err := execPluginsDir(); err == nil {
+		dirs = append(dirs, dir)
+	}
+	return append(dirs, filepath.SplitList(os.Getenv("PATH"))...)
+}
+
+// execPluginName returns the plugin name of an executable file, or "".
+func execPluginName(file string) string {
+	name := filepath.Base(file)
+	if runtime.GOOS == "windows" {
+		ext := strings.ToLower(filepath.Ext(name))
+		if ext != ".exe" && ext != ".bat" && ext != ".cmd" {
+			return ""
+		}
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	if !strings.HasPrefix(name, execPluginPrefix) || len(name) == len(execPluginPrefix) {
+		return ""
+	}
+	info, err := os.Stat(file)
+	if err != nil || info.IsDir() {
+		return ""
+	}
+	if runtime.GOOS != "windows" && info.Mode()&0111 == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(name, execPluginPrefix)
+}
+
+// findExecPlugins returns the executable plugins by name; the first found
+// in the search path wins.
+func findExecPlugins() map[string]string {
+	found := map[string]string{}
+	for _, dir := range execPluginSearchPath() {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if name := execPluginName(path); name != "" {
+				if _, ok := found[name]; !ok {
+					found[name] = path
+				}
+			}
+		}
+	}
+	return found
+}
+
+// findExecPlugin returns the path of the executable plugin name, or "".
+func findExecPlugin(name string) string {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return ""
+	}
+	for _, dir := range execPluginSearchPath() {
+		candidates := []string{filepath.Join(dir, execPluginPrefix+name)}
+		if runtime.GOOS == "windows" {
+			candidates = []string{candidates[0] + ".exe", candidates[0] + ".bat", candidates[0] + ".cmd"}
+		}
+		for _, path := range candidates {
+			if execPluginName(path) == name {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+func execPluginEnv(name string) []string {
+	return append(os.Environ(),
+		"OPS_PLUGIN_PROTOCOL="+execPluginProtocol,
+		"OPS_PLUGIN_NAME="+name,
+	)
+}
+
+// readExecPluginInfo runs the metadata handshake of an executable plugin.
+func readExecPluginInfo(name, path string) (*execPluginInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), execPluginInfoTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, execPluginInfoArg)
+	cmd.Env = execPluginEnv(name)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s %s: %w", filepath.Base(path), execPluginInfoArg, err)
+	}
+	info := &execPluginInfo{}
+	if err := json.Unmarshal(out.Bytes(), info); err != nil {
+		return nil, fmt.Errorf("%s %s: invalid metadata: %w", filepath.Base(path), execPluginInfoArg, err)
+	}
+	return info, nil
+}
+
+// runExecPlugin runs an executable plugin with the terminal and the ops
+// environment, which includes the config values.
+func runExecPlugin(name, path string, args []string) error {
+	debug("Found executable plugin", path)
+	cmd := exec.Command(path, args...)
+	cmd.Env = execPluginEnv(name)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// exitOnPluginStatus exits with the status of an executable plugin that
+// failed, so ops reports the same exit code; other errors are returned.
+func exitOnPluginStatus(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		os.Exit(exitErr.ExitCode())
+	}
+	return err
+}
+
+// printExecPlugins lists the executable plugins by file. They are not run
+// here, as any program named ops-<name> on the PATH is found: only
+// ops -plugin info reads their metadata.
+func printExecPlugins(found map[string]string) {
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %s (exec) %s\n", name, found[name])
+	}
+}
+
+// printExecPluginInfo is ops -plugin info for an executable plugin.
+func printExecPluginInfo(name, path string) error {
+	fmt.Printf("Name:     %s\n", name)
+	fmt.Printf("Location: %s (exec)\n", path)
+	info, err := readExecPluginInfo(name, path)
+	if err != nil {
+		return err
+	}
+	if info.Version != "" {
+		fmt.Printf("Version:  %s\n", info.Version)
+	}
+	if info.Description != "" {
+		fmt.Printf("About:    %s\n", info.Description)
+	}
+	if len(info.Commands) > 0 {
+		fmt.Println("Commands:")
+		for _, command := range info.Commands {
+			fmt.Printf("  %-16s %s\n", command.Name, command.Description)
+		}
+	}
+	if info.Help != "" {
+		fmt.Println()
+		fmt.Println(strings.TrimRight(info.Help, "\n"))
+	}
+	return nil
+}
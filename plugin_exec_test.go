@@ -0,0 +1,129 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testExecPlugin = `#!/bin/sh
+if [ "$1" = "--ops-plugin-info" ]; then
+  echo '{"name": "hello", "version": "1.2.0", "description": "Says hello", "help": "Usage: ops hello <who>", "commands": [{"name": "world", "description": "greet the world"}]}'
+  exit 0
+fi
+echo "hello $* from $OPS_PLUGIN_NAME protocol $OPS_PLUGIN_PROTOCOL region $OPS_REGION"
+`
+
+func writeExecPlugin(t *testing.T, dir, name, script string, mode os.FileMode) string {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(script), mode))
+	return path
+}
+
+func setupExecPlugins(t *testing.T) (string, string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("executable plugins are shell scripts in the tests")
+	}
+	opsHome := setPluginsHome(t)
+	t.Setenv("OPS_ROOT_PLUGIN", t.TempDir())
+	pathDir := t.TempDir()
+	t.Setenv("PATH", pathDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return filepath.Join(opsHome, "plugins", "bin"), pathDir
+}
+
+func TestFindExecPlugins(t *testing.T) {
+	binDir, pathDir := setupExecPlugins(t)
+	hello := writeExecPlugin(t, binDir, "ops-hello", testExecPlugin, 0755)
+	writeExecPlugin(t, pathDir, "ops-hello", testExecPlugin, 0755)
+	other := writeExecPlugin(t, pathDir, "ops-other", testExecPlugin, 0755)
+	writeExecPlugin(t, pathDir, "ops-noexec", testExecPlugin, 0644)
+	writeExecPlugin(t, pathDir, "ops-", testExecPlugin, 0755)
+
+	found := findExecPlugins()
+	require.Equal(t, hello, found["hello"])
+	require.Equal(t, other, found["other"])
+	require.NotContains(t, found, "noexec")
+	require.NotContains(t, found, "")
+
+	require.Equal(t, hello, findExecPlugin("hello"))
+	require.Empty(t, findExecPlugin("noexec"))
+	require.Empty(t, findExecPlugin("../ops-hello"))
+}
+
+func TestRunExecPlugin(t *testing.T) {
+	binDir, _ := setupExecPlugins(t)
+	writeExecPlugin(t, binDir, "ops-hello", testExecPlugin, 0755)
+	t.Setenv("OPS_REGION", "eu")
+
+	out := captureStdout(t, func() {
+		found, err := runNamedPlugin("hello", []string{"a", "b"})
+		require.True(t, found)
+		require.NoError(t, err)
+	})
+	require.Equal(t, "hello a b from hello protocol 1 region eu\n", out)
+
+	found, err := runNamedPlugin("missing", nil)
+	require.False(t, found)
+	require.NoError(t, err)
+
+	name, task, ok := splitPluginTask("hello:world")
+	require.True(t, ok)
+	require.Equal(t, "hello", name)
+	require.Equal(t, "world", task)
+}
+
+func TestExecPluginInfo(t *testing.T) {
+	binDir, _ := setupExecPlugins(t)
+	path := writeExecPlugin(t, binDir, "ops-hello", testExecPlugin, 0755)
+	writeExecPlugin(t, binDir, "ops-broken", "#!/bin/sh\necho not json\n", 0755)
+
+	info, err := readExecPluginInfo("hello", path)
+	require.NoError(t, err)
+	require.Equal(t, "1.2.0", info.Version)
+	require.Equal(t, "world", info.Commands[0].Name)
+
+	out := captureStdout(t, func() { require.NoError(t, printPluginInfo("hello")) })
+	require.Contains(t, out, "About:    Says hello")
+	require.Contains(t, out, "greet the world")
+	require.Contains(t, out, "Usage: ops hello <who>")
+
+	out = captureStdout(t, func() { require.NoError(t, printPluginsHelp()) })
+	require.Contains(t, out, "hello (exec) "+path+"\n")
+	require.Contains(t, out, "broken (exec) "+filepath.Join(binDir, "ops-broken")+"\n")
+
+	_, err = readExecPluginInfo("broken", filepath.Join(binDir, "ops-broken"))
+	require.ErrorContains(t, err, "invalid metadata")
+}
+
+func TestExecPluginsAreListedWithoutRunning(t *testing.T) {
+	binDir, _ := setupExecPlugins(t)
+	marker := filepath.Join(t.TempDir(), "ran")
+	writeExecPlugin(t, binDir, "ops-spy", "#!/bin/sh\ntouch "+marker+"\n", 0755)
+
+	out := captureStdout(t, func() { require.NoError(t, printPluginsHelp()) })
+	require.Contains(t, out, "spy (exec)")
+	require.NoFileExists(t, marker)
+}
@@ -0,0 +1,226 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+	"gopkg.in/yaml.v3"
+)
+
+// The plugin indexes and the keys trusted to sign them are read from the
+// environment, so they can also be set in config.json:
+//
+//	OPS_PLUGIN_INDEXES       URLs or paths of the indexes, comma or space separated
+//	OPS_PLUGIN_TRUSTED_KEYS  base64 ed25519 public keys; when set, every index
+//	                         must come with a valid <index>.sig signature, when
+//	                         not set a signed index is refused
+const (
+	pluginIndexesEnv     = "OPS_PLUGIN_INDEXES"
+	pluginTrustedKeysEnv = "OPS_PLUGIN_TRUSTED_KEYS"
+)
+
+// indexedPlugin is a plugin entry of an index.
+type indexedPlugin struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description"`
+	Source      string   `yaml:"source"`
+	Ref         string   `yaml:"ref"`
+	Tags        []string `yaml:"tags"`
+
+	index string
+}
+
+// pluginIndex is a catalog of plugins, in JSON or YAML.
+type pluginIndex struct {
+	Plugins []*indexedPlugin `yaml:"plugins"`
+}
+
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\t'
+	})
+}
+
+// readIndexLocation reads an index or its signature from an URL or a path.
+func readIndexLocation(location string) ([]byte, error) {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		resp, err := http.Get(location)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: %s", location, resp.Status)
+		}
+		return io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	}
+	path, err := homedir.Expand(strings.TrimPrefix(location, "file://"))
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// verifyIndexSignature checks the detached <location>.sig signature of an
+// index against the trusted keys.
+func verifyIndexSignature(location string, data []byte, keys []string) error {
+	sig, err := readIndexLocation(location + ".sig")
+	if err != nil {
+		return fmt.Errorf("index %s is not signed: %w", location, err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return fmt.Errorf("invalid signature of index %s: %w", location, err)
+	}
+	for _, key := range keys {
+		publicKey, err := base64.StdEncoding.DecodeString(key)
+		if err != nil || len(publicKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid key in %s: %q", pluginTrustedKeysEnv, key)
+		}
+		if ed25519.Verify(publicKey, data, signature) {
+			return nil
+		}
+	}
+	return fmt.Errorf("signature of index %s does not match any trusted key", location)
+}
+
+func loadPluginIndex(location string, keys []string) (*pluginIndex, error) {
+	data, err := readIndexLocation(location)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) > 0 {
+		if err := verifyIndexSignature(location, data, keys); err != nil {
+			return nil, err
+		}
+	} else if _, err := readIndexLocation(location + ".sig"); err == nil {
+		// the publisher signs the index, trusting it unchecked defeats that
+		return nil, fmt.Errorf("index %s is signed but %s is not set, so the signature cannot be checked", location, pluginTrustedKeysEnv)
+	} else {
+		warn("plugin index", location, "is not verified, set", pluginTrustedKeysEnv, "to check the signatures")
+	}
+	index := &pluginIndex{}
+	if err := yaml.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("invalid plugin index %s: %w", location, err)
+	}
+	for _, plugin := range index.Plugins {
+		plugin.Name = getPluginName(plugin.Name)
+		plugin.index = location
+	}
+	return index, nil
+}
+
+// loadPluginIndexes returns the plugins of all the configured indexes, in
+// order. Indexes that cannot be read or verified are reported and skipped.
+func loadPluginIndexes() ([]*indexedPlugin, error) {
+	locations := splitList(os.Getenv(pluginIndexesEnv))
+	if len(locations) == 0 {
+		return nil, fmt.Errorf("no plugin index configured, set one with: ops -config %s=<url>", pluginIndexesEnv)
+	}
+	keys := splitList(os.Getenv(pluginTrustedKeysEnv))
+	plugins := make([]*indexedPlugin, 0)
+	var failures []string
+	for _, location := range locations {
+		index, err := loadPluginIndex(location, keys)
+		if err != nil {
+			warn("skipping plugin index:", err)
+			failures = append(failures, location)
+			continue
+		}
+		plugins = append(plugins, index.Plugins...)
+	}
+	if len(failures) == len(locations) {
+		return nil, errors.New("no plugin index could be loaded")
+	}
+	return plugins, nil
+}
+
+func (p *indexedPlugin) matches(term string) bool {
+	term = strings.ToLower(term)
+	if strings.Contains(strings.ToLower(p.Name), term) || strings.Contains(strings.ToLower(p.Description), term) {
+		return true
+	}
+	for _, tag := range p.Tags {
+		if strings.Contains(strings.ToLower(tag), term) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchPlugins prints the indexed plugins matching term, all of them when
+// term is empty.
+func searchPlugins(term string) error {
+	plugins, err := loadPluginIndexes()
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	found := 0
+	for _, plugin := range plugins {
+		if seen[plugin.Name] || !plugin.matches(term) {
+			continue
+		}
+		seen[plugin.Name] = true
+		found++
+		fmt.Printf("%-20s %s\n", plugin.Name, plugin.Description)
+		fmt.Printf("%-20s %s\n", "", plugin.Source)
+	}
+	if found == 0 {
+		fmt.Printf("No plugins matching %q.\n", term)
+	}
+	return nil
+}
+
+// installIndexedPlugin installs a plugin by its index name, optionally
+// followed by @ref to override the ref of the index.
+func installIndexedPlugin(nameRef string) error {
+	name, ref, _ := strings.Cut(nameRef, "@")
+	name = getPluginName(name)
+	plugins, err := loadPluginIndexes()
+	if err != nil {
+		return err
+	}
+	for _, plugin := range plugins {
+		if plugin.Name != name {
+			continue
+		}
+		if ok, folder := checkGitRepo(plugin.Source); !ok || folder != pluginFolderName(name) {
+			return fmt.Errorf("index %s lists plugin %s with invalid source %q", plugin.index, name, plugin.Source)
+		}
+		if ref == "" {
+			ref = plugin.Ref
+		}
+		source := plugin.Source
+		if ref != "" {
+			source += "@" + ref
+		}
+		fmt.Printf("Installing %s from %s\n", name, plugin.Source)
+		return downloadPluginTasksFromRepo(source)
+	}
+	return fmt.Errorf("plugin %s not found in the plugin indexes", name)
+}
@@ -0,0 +1,138 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testIndex = `
+plugins:
+  - name: demo
+    description: A demo plugin
+    source: %s
+    tags: [example, hello]
+  - name: olaris-db
+    description: Database tools
+    source: https://github.com/example/olaris-db
+    ref: v1.0.0
+`
+
+func writeTestIndex(t *testing.T, source string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "index.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(fmt.Sprintf(testIndex, source)), 0644))
+	return file
+}
+
+func TestPluginIndexSearch(t *testing.T) {
+	t.Setenv(pluginTrustedKeysEnv, "")
+	t.Setenv(pluginIndexesEnv, writeTestIndex(t, "https://github.com/example/olaris-demo"))
+
+	out := captureStdout(t, func() { require.NoError(t, searchPlugins("HELLO")) })
+	require.Contains(t, out, "demo")
+	require.NotContains(t, out, "db")
+
+	out = captureStdout(t, func() { require.NoError(t, searchPlugins("")) })
+	require.Contains(t, out, "demo")
+	require.Contains(t, out, "https://github.com/example/olaris-db")
+
+	out = captureStdout(t, func() { require.NoError(t, searchPlugins("nothing")) })
+	require.Contains(t, out, `No plugins matching "nothing"`)
+
+	t.Setenv(pluginIndexesEnv, "")
+	require.ErrorContains(t, searchPlugins(""), "no plugin index configured")
+}
+
+func TestPluginIndexSignature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	index := []byte(fmt.Sprintf(testIndex, "https://github.com/example/olaris-demo"))
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(private, index))
+
+	files := map[string][]byte{"/index.json": index, "/index.json.sig": []byte(signature + "\n")}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+	location := server.URL + "/index.json"
+	key := base64.StdEncoding.EncodeToString(public)
+
+	loaded, err := loadPluginIndex(location, []string{key})
+	require.NoError(t, err)
+	require.Len(t, loaded.Plugins, 2)
+	require.Equal(t, "db", loaded.Plugins[1].Name)
+
+	// a signature that cannot be checked is not ignored
+	_, err = loadPluginIndex(location, nil)
+	require.ErrorContains(t, err, "is signed but "+pluginTrustedKeysEnv+" is not set")
+
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, err = loadPluginIndex(location, []string{base64.StdEncoding.EncodeToString(other)})
+	require.ErrorContains(t, err, "does not match any trusted key")
+
+	files["/index.json"] = append(index, '#')
+	_, err = loadPluginIndex(location, []string{key})
+	require.ErrorContains(t, err, "does not match any trusted key")
+
+	delete(files, "/index.json.sig")
+	_, err = loadPluginIndex(location, []string{key})
+	require.ErrorContains(t, err, "is not signed")
+
+	// without trusted keys an unsigned index is loaded
+	_, err = loadPluginIndex(location, nil)
+	require.NoError(t, err)
+
+	t.Setenv(pluginIndexesEnv, location)
+	t.Setenv(pluginTrustedKeysEnv, key)
+	_, err = loadPluginIndexes()
+	require.ErrorContains(t, err, "no plugin index could be loaded")
+}
+
+func TestPluginIndexInstall(t *testing.T) {
+	opsHome := setPluginsHome(t)
+	t.Setenv("OPS_ROOT_PLUGIN", t.TempDir())
+	t.Setenv(pluginTrustedKeysEnv, "")
+	src := newPluginRepo(t, "olaris-demo")
+	src.commit("opsfile.yml", "version: '3'\n")
+	t.Setenv(pluginIndexesEnv, writeTestIndex(t, src.dir))
+
+	require.ErrorContains(t, installIndexedPlugin("missing"), "plugin missing not found")
+	require.NoError(t, installIndexedPlugin("demo"))
+	require.FileExists(t, filepath.Join(opsHome, "olaris-demo", "opsfile.yml"))
+
+	states, err := loadPluginStates()
+	require.NoError(t, err)
+	require.Equal(t, src.dir, states["olaris-demo"].Source)
+}
@@ -0,0 +1,320 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apache/openserverless-cli/config"
+	git "github.com/go-git/go-git/v5"
+)
+
+// pluginFolderName turns a plugin name, with or without the olaris- prefix,
+// into its folder name.
+func pluginFolderName(name string) string {
+	return "olaris-" + strings.TrimPrefix(name, "olaris-")
+}
+
+// checkPluginName rejects the plugin names that would resolve outside the
+// plugin folders, like ../olaris-x or a/b.
+func checkPluginName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return fmt.Errorf("invalid plugin name %q", name)
+	}
+	return nil
+}
+
+// installedPluginDir returns the ~/.ops folder of a plugin, also when the
+// plugin is disabled.
+func installedPluginDir(name string) (string, error) {
+	if err := checkPluginName(name); err != nil {
+		return "", err
+	}
+	home, err := pluginsHome()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(home, pluginFolderName(name))
+	if !isDir(dir) {
+		return "", fmt.Errorf("plugin %s is not installed", getPluginName(name))
+	}
+	return dir, nil
+}
+
+// localPluginDir returns the OPS_ROOT_PLUGIN folder of a plugin, or "".
+func localPluginDir(name string) string {
+	dir := filepath.Join(os.Getenv("OPS_ROOT_PLUGIN"), pluginFolderName(name))
+	if isDir(dir) && exists(dir, OPSFILE) {
+		return dir
+	}
+	return ""
+}
+
+func removePlugin(name string) error {
+	dir, err := installedPluginDir(name)
+	if err != nil {
+		if localPluginDir(name) != "" {
+			return fmt.Errorf("plugin %s is a local folder, remove it by hand", getPluginName(name))
+		}
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	states, err := loadPluginStates()
+	if err != nil {
+		return err
+	}
+	delete(states, pluginFolderName(name))
+	fmt.Println("Removed plugin", getPluginName(name))
+	return savePluginStates(states)
+}
+
+// pluginSourceOf returns where a plugin was installed from, reading the
+// origin remote for plugins installed before ops recorded it.
+func pluginSourceOf(dir string, state *pluginState) (string, error) {
+	if state != nil && state.Source != "" {
+		return state.Source, nil
+	}
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return "", err
+	}
+	remote, err := repo.Remote("origin")
+	if err != nil {
+		return "", err
+	}
+	return remote.Config().URLs[0], nil
+}
+
+// installedPluginFolders lists the plugin folders in ~/.ops, enabled or not.
+func installedPluginFolders() ([]string, error) {
+	home, err := pluginsHome()
+	if err != nil {
+		return nil, err
+	}
+	folders, err := filepath.Glob(filepath.Join(home, "olaris-*"))
+	if err != nil {
+		return nil, err
+	}
+	dirs := make([]string, 0, len(folders))
+	for _, folder := range folders {
+		if isDir(folder) {
+			dirs = append(dirs, folder)
+		}
+	}
+	return dirs, nil
+}
+
+// updatePlugins updates the named plugins, or all the installed ones,
+// keeping each on its recorded ref.
+func updatePlugins(names []string, all bool) error {
+	states, err := loadPluginStates()
+	if err != nil {
+		return err
+	}
+	if all {
+		folders, err := installedPluginFolders()
+		if err != nil {
+			return err
+		}
+		names = names[:0]
+		for _, folder := range folders {
+			names = append(names, filepath.Base(folder))
+		}
+		if len(names) == 0 {
+			fmt.Println("No plugins installed. Use 'ops -plugin' to add new ones.")
+			return nil
+		}
+	}
+
+	var failed []string
+	for _, name := range names {
+		dir, err := installedPluginDir(name)
+		if err == nil {
+			var source string
+			if source, err = pluginSourceOf(dir, states[pluginFolderName(name)]); err == nil {
+				err = installPlugin(dir, pluginFolderName(name), source, "")
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: cannot update %s: %v\n", getPluginName(name), err)
+			failed = append(failed, getPluginName(name))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to update %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func setPluginDisabled(name string, disabled bool) error {
+	if _, err := installedPluginDir(name); err != nil && localPluginDir(name) == "" {
+		return err
+	}
+	states, err := loadPluginStates()
+	if err != nil {
+		return err
+	}
+	folder := pluginFolderName(name)
+	if states[folder] == nil {
+		states[folder] = &pluginState{}
+	}
+	states[folder].Disabled = disabled
+	if disabled {
+		fmt.Println("Disabled plugin", getPluginName(name))
+	} else {
+		fmt.Println("Enabled plugin", getPluginName(name))
+	}
+	return savePluginStates(states)
+}
+
+func isPluginDisabled(states map[string]*pluginState, folder string) bool {
+	state, ok := states[filepath.Base(folder)]
+	return ok && state.Disabled
+}
+
+// printPluginInfo shows where a plugin comes from and what it provides.
+func printPluginInfo(name string) error {
+	dir := localPluginDir(name)
+	location := "local"
+	if dir == "" {
+		var err error
+		if dir, err = installedPluginDir(name); err != nil {
+			if path := findExecPlugin(name); path != "" {
+				return printExecPluginInfo(name, path)
+			}
+			return err
+		}
+		location = "ops"
+	}
+	states, err := loadPluginStates()
+	if err != nil {
+		return err
+	}
+	state := states[pluginFolderName(name)]
+	if state == nil {
+		state = &pluginState{}
+	}
+
+	fmt.Printf("Name:     %s\n", getPluginName(name))
+	fmt.Printf("Location: %s (%s)\n", dir, location)
+	status := "enabled"
+	if state.Disabled {
+		status = "disabled"
+	}
+	fmt.Printf("Status:   %s\n", status)
+	if manifest, err := readPluginManifest(dir); err != nil {
+		fmt.Printf("Manifest: %v\n", err)
+	} else if manifest != nil {
+		for _, line := range describeManifest(manifest) {
+			fmt.Println(line)
+		}
+	}
+
+	if repo, err := git.PlainOpen(dir); err == nil {
+		if source, err := pluginSourceOf(dir, state); err == nil {
+			fmt.Printf("Source:   %s\n", source)
+		}
+		if state.Ref != "" {
+			fmt.Printf("Ref:      %s %s\n", state.RefType, state.Ref)
+		}
+		if head, err := repo.Head(); err == nil {
+			fmt.Printf("Commit:   %s\n", head.Hash())
+			if commit, err := repo.CommitObject(head.Hash()); err == nil && state.Updated.IsZero() {
+				state.Updated = commit.Committer.When
+			}
+		}
+	}
+	if !state.Updated.IsZero() {
+		fmt.Printf("Updated:  %s\n", state.Updated.Local().Format(time.RFC3339))
+	}
+
+	commands := getTaskNamesList(dir)
+	sort.Strings(commands)
+	fmt.Printf("Commands: %s\n", strings.Join(commands, ", "))
+
+	keys, err := pluginConfigKeys(name, filepath.Join(dir, OPSROOT))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Config:   %s\n", strings.Join(keys, ", "))
+	return nil
+}
+
+// pluginConfigKeys lists the config keys a plugin adds from its opsroot.json.
+func pluginConfigKeys(name, opsRoot string) ([]string, error) {
+	configMap, err := config.NewConfigMapBuilder().
+		WithPluginOpsRoots(map[string]string{getPluginName(name): opsRoot}).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0)
+	for key := range configMap.Flatten() {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// pluginCommand runs the lifecycle subcommands of ops -plugin.
+func pluginCommand(command string, args []string) error {
+	switch command {
+	case "remove", "info", "enable", "disable":
+		if len(args) != 1 {
+			printPluginUsage()
+			return fmt.Errorf("%s expects a plugin name", command)
+		}
+		if err := checkPluginName(args[0]); err != nil {
+			return err
+		}
+	}
+	switch command {
+	case "remove":
+		return removePlugin(args[0])
+	case "info":
+		return printPluginInfo(args[0])
+	case "enable":
+		return setPluginDisabled(args[0], false)
+	case "disable":
+		return setPluginDisabled(args[0], true)
+	case "update":
+		all := len(args) == 1 && args[0] == "--all"
+		if len(args) == 0 || (!all && strings.HasPrefix(args[0], "-")) {
+			printPluginUsage()
+			return errors.New("update expects plugin names or --all")
+		}
+		if all {
+			return updatePlugins(nil, true)
+		}
+		for _, name := range args {
+			if err := checkPluginName(name); err != nil {
+				return err
+			}
+		}
+		return updatePlugins(args, false)
+	}
+	return fmt.Errorf("unknown plugin command %s", command)
+}
@@ -0,0 +1,131 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// captureStdout returns what run prints on stdout.
+func captureStdout(t *testing.T, run func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	run()
+	w.Close()
+	var out bytes.Buffer
+	_, _ = io.Copy(&out, r)
+	return out.String()
+}
+
+func installDemoPlugin(t *testing.T) (string, *pluginRepo) {
+	t.Helper()
+	opsHome := setPluginsHome(t)
+	t.Setenv("OPS_ROOT_PLUGIN", t.TempDir())
+	src := newPluginRepo(t, "olaris-demo")
+	src.commit("opsroot.json", `{"version": "0.1.0", "config": {"region": "eu", "db": {"host": "localhost"}}}`)
+	require.NoError(t, os.MkdirAll(filepath.Join(src.dir, "deploy"), 0755))
+	src.commit("deploy/opsfile.yml", "version: '3'\n")
+	src.commit("opsfile.yml", "version: '3'\ntasks:\n  hello:\n    cmds: [echo hello]\n")
+	require.NoError(t, downloadPluginTasksFromRepo(src.dir))
+	return opsHome, src
+}
+
+func TestPluginDisableAndEnable(t *testing.T) {
+	_, _ = installDemoPlugin(t)
+
+	require.NoError(t, pluginCommand("disable", []string{"demo"}))
+	p, err := newPlugins()
+	require.NoError(t, err)
+	require.Empty(t, p.ops)
+	require.Len(t, p.disabled, 1)
+	roots, err := GetOpsRootPlugins()
+	require.NoError(t, err)
+	require.Empty(t, roots)
+	_, err = findTaskInPlugins("demo")
+	require.Error(t, err)
+
+	// the flag survives updates
+	require.NoError(t, pluginCommand("update", []string{"demo"}))
+	p, err = newPlugins()
+	require.NoError(t, err)
+	require.Empty(t, p.ops)
+
+	require.NoError(t, pluginCommand("enable", []string{"olaris-demo"}))
+	p, err = newPlugins()
+	require.NoError(t, err)
+	require.Len(t, p.ops, 1)
+
+	require.ErrorContains(t, pluginCommand("disable", []string{"missing"}), "plugin missing is not installed")
+}
+
+func TestPluginUpdateAllAndRemove(t *testing.T) {
+	opsHome, src := installDemoPlugin(t)
+	src.commit("version", "2")
+
+	require.NoError(t, pluginCommand("update", []string{"--all"}))
+	require.Equal(t, "2", readPluginFile(t, opsHome, "version"))
+
+	require.NoError(t, pluginCommand("remove", []string{"demo"}))
+	require.NoDirExists(t, filepath.Join(opsHome, "olaris-demo"))
+	states, err := loadPluginStates()
+	require.NoError(t, err)
+	require.NotContains(t, states, "olaris-demo")
+
+	require.ErrorContains(t, pluginCommand("remove", []string{"demo"}), "not installed")
+	require.ErrorContains(t, pluginCommand("update", nil), "update expects plugin names or --all")
+}
+
+func TestPluginCommandsRejectPathNames(t *testing.T) {
+	opsHome, _ := installDemoPlugin(t)
+	outside := filepath.Join(filepath.Dir(opsHome), "olaris-outside")
+	require.NoError(t, os.MkdirAll(outside, 0755))
+
+	for _, command := range []string{"remove", "info", "enable", "disable", "update"} {
+		for _, name := range []string{"../olaris-outside", "..", `demo\..\x`, "a/b"} {
+			require.ErrorContains(t, pluginCommand(command, []string{name}), "invalid plugin name", command+" "+name)
+		}
+	}
+	require.DirExists(t, outside)
+	require.DirExists(t, filepath.Join(opsHome, "olaris-demo"))
+}
+
+func TestPluginInfo(t *testing.T) {
+	_, src := installDemoPlugin(t)
+
+	out := captureStdout(t, func() {
+		require.NoError(t, pluginCommand("info", []string{"demo"}))
+	})
+	require.Contains(t, out, "Name:     demo\n")
+	require.Contains(t, out, "Status:   enabled\n")
+	require.Contains(t, out, "Source:   "+src.dir+"\n")
+	require.Contains(t, out, "Ref:      branch "+src.defaultBranch()+"\n")
+	require.Contains(t, out, "Commit:   "+mustHead(t, src.repo).String()+"\n")
+	require.Contains(t, out, "Updated:  ")
+	require.Contains(t, out, "Commands: deploy, hello\n")
+	require.Contains(t, out, "Config:   DEMO_DB_HOST, DEMO_REGION\n")
+}
@@ -0,0 +1,316 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver"
+)
+
+// PLUGINMANIFEST is the optional manifest at the root of a plugin. The same
+// fields can also be given as a "plugin" object in the plugin opsroot.json.
+const PLUGINMANIFEST = "plugin.json"
+
+// pluginManifest describes a plugin and what it needs to work.
+type pluginManifest struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+	// MinOpsVersion is the oldest ops able to run the plugin.
+	MinOpsVersion string `json:"minOpsVersion"`
+	// OlarisVersion is a semver constraint on the installed tasks, e.g.
+	// ">= 0.3.0, < 0.5.0".
+	OlarisVersion string `json:"olarisVersion"`
+	// Requires maps the plugins needed by this one to a version constraint,
+	// "*" for any version.
+	Requires map[string]string `json:"requires"`
+}
+
+// readPluginManifest returns the manifest of the plugin in dir, or nil when
+// the plugin does not declare one.
+func readPluginManifest(dir string) (*pluginManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, PLUGINMANIFEST))
+	if err == nil {
+		manifest := &pluginManifest{}
+		if err := json.Unmarshal(data, manifest); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", PLUGINMANIFEST, err)
+		}
+		return manifest, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	data, err = os.ReadFile(filepath.Join(dir, OPSROOT))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var opsRoot struct {
+		Plugin *pluginManifest `json:"plugin"`
+	}
+	if err := json.Unmarshal(data, &opsRoot); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", OPSROOT, err)
+	}
+	return opsRoot.Plugin, nil
+}
+
+// currentOlarisVersion returns the version of the tasks in OPS_ROOT, ""
+// when it is unknown.
+var currentOlarisVersion = func() string {
+	dir := os.Getenv("OPS_ROOT")
+	if dir == "" {
+		return ""
+	}
+	opsRoot, err := readOpsRootFile(dir)
+	if err != nil {
+		return ""
+	}
+	return opsRoot.Version
+}
+
+// releaseVersion parses a version ignoring its prerelease, so development
+// builds satisfy the release they lead to.
+func releaseVersion(version string) (*semver.Version, error) {
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	release, err := v.SetPrerelease("")
+	if err != nil {
+		return nil, err
+	}
+	release, err = release.SetMetadata("")
+	if err != nil {
+		return nil, err
+	}
+	return &release, nil
+}
+
+// checkCompatibility verifies the manifest of the plugin named name against
+// the running ops and the installed tasks.
+func (m *pluginManifest) checkCompatibility(name, opsVersion, olarisVersion string) error {
+	if m.Name != "" && m.Name != name {
+		return fmt.Errorf("plugin folder olaris-%s holds plugin %q", name, m.Name)
+	}
+	if m.Version != "" {
+		if _, err := semver.NewVersion(m.Version); err != nil {
+			return fmt.Errorf("invalid plugin version %q: %w", m.Version, err)
+		}
+	}
+
+	if m.MinOpsVersion != "" {
+		minimum, err := semver.NewVersion(m.MinOpsVersion)
+		if err != nil {
+			return fmt.Errorf("invalid minOpsVersion %q: %w", m.MinOpsVersion, err)
+		}
+		// development builds without a semver version are not checked
+		if current, err := releaseVersion(opsVersion); err == nil && current.LessThan(minimum) {
+			return fmt.Errorf("requires ops %s or newer, this is ops %s", m.MinOpsVersion, opsVersion)
+		}
+	}
+
+	if m.OlarisVersion != "" {
+		constraint, err := semver.NewConstraint(m.OlarisVersion)
+		if err != nil {
+			return fmt.Errorf("invalid olarisVersion %q: %w", m.OlarisVersion, err)
+		}
+		if olarisVersion != "" {
+			current, err := releaseVersion(olarisVersion)
+			if err != nil {
+				warn("Unable to validate olaris version", olarisVersion, ":", err)
+			} else if !constraint.Check(current) {
+				return fmt.Errorf("requires olaris %s, installed tasks are %s", m.OlarisVersion, olarisVersion)
+			}
+		}
+	}
+	return nil
+}
+
+// checkRequires verifies that the plugins m depends on are among the
+// available ones, at a matching version.
+func (m *pluginManifest) checkRequires(available map[string]*pluginManifest) error {
+	names := make([]string, 0, len(m.Requires))
+	for name := range m.Requires {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		required := m.Requires[name]
+		dependency, ok := available[getPluginName(name)]
+		if !ok {
+			return fmt.Errorf("requires plugin %s, which is not installed or not enabled", getPluginName(name))
+		}
+		if required == "" || required == "*" {
+			continue
+		}
+		constraint, err := semver.NewConstraint(required)
+		if err != nil {
+			return fmt.Errorf("invalid constraint %q for plugin %s: %w", required, name, err)
+		}
+		if dependency == nil || dependency.Version == "" {
+			return fmt.Errorf("requires plugin %s %s, which declares no version", getPluginName(name), required)
+		}
+		version, err := semver.NewVersion(dependency.Version)
+		if err != nil || !constraint.Check(version) {
+			return fmt.Errorf("requires plugin %s %s, found %s", getPluginName(name), required, dependency.Version)
+		}
+	}
+	return nil
+}
+
+// pluginProblems returns why each plugin folder cannot be loaded, keyed by
+// folder. A plugin is rejected when it is incompatible or when a plugin it
+// requires is missing or rejected itself.
+func pluginProblems(folders []string, opsVersion, olarisVersion string) map[string]error {
+	problems := map[string]error{}
+	manifests := map[string]*pluginManifest{}
+	byName := map[string]string{}
+	for _, folder := range folders {
+		name := getPluginName(folder)
+		byName[name] = folder
+		manifest, err := readPluginManifest(folder)
+		if err == nil && manifest != nil {
+			err = manifest.checkCompatibility(name, opsVersion, olarisVersion)
+		}
+		if err != nil {
+			problems[folder] = err
+			continue
+		}
+		manifests[name] = manifest
+	}
+
+	// drop plugins with unmet requirements until nothing changes
+	for changed := true; changed; {
+		changed = false
+		for name, manifest := range manifests {
+			if manifest == nil {
+				continue
+			}
+			if err := manifest.checkRequires(manifests); err != nil {
+				problems[byName[name]] = err
+				delete(manifests, name)
+				changed = true
+			}
+		}
+	}
+	return problems
+}
+
+// warnedPlugins avoids repeating the same warning when newPlugins runs more
+// than once.
+var warnedPlugins = map[string]bool{}
+
+// filterLoadablePlugins removes from the lists the plugins that cannot be
+// loaded, warning about each of them.
+func filterLoadablePlugins(local, ops []string) ([]string, []string) {
+	all := orderPluginFolders(local, ops)
+	// only the first plugin with a given name is loaded
+	seen := map[string]bool{}
+	visible := make([]string, 0, len(all))
+	for _, folder := range all {
+		if name := getPluginName(folder); !seen[name] {
+			seen[name] = true
+			visible = append(visible, folder)
+		}
+	}
+	problems := pluginProblems(visible, OpsVersion, currentOlarisVersion())
+	if len(problems) == 0 {
+		return local, ops
+	}
+	keep := func(folders []string) []string {
+		kept := make([]string, 0, len(folders))
+		for _, folder := range folders {
+			if err, bad := problems[folder]; bad {
+				if !warnedPlugins[folder] {
+					warnedPlugins[folder] = true
+					warn("skipping plugin", getPluginName(folder)+":", err)
+				}
+				continue
+			}
+			kept = append(kept, folder)
+		}
+		return kept
+	}
+	return keep(local), keep(ops)
+}
+
+// checkInstalledPlugin validates a plugin just installed in dir against ops,
+// the tasks and the other enabled plugins.
+func checkInstalledPlugin(dir string) error {
+	manifest, err := readPluginManifest(dir)
+	if err != nil || manifest == nil {
+		return err
+	}
+	name := getPluginName(dir)
+	if err := manifest.checkCompatibility(name, OpsVersion, currentOlarisVersion()); err != nil {
+		return err
+	}
+	if len(manifest.Requires) == 0 {
+		return nil
+	}
+	plgs, err := newPlugins()
+	if err != nil {
+		return err
+	}
+	available := map[string]*pluginManifest{}
+	for _, folder := range append(append([]string{}, plgs.local...), plgs.ops...) {
+		other, err := readPluginManifest(folder)
+		if err != nil {
+			continue
+		}
+		if _, ok := available[getPluginName(folder)]; !ok {
+			available[getPluginName(folder)] = other
+		}
+	}
+	return manifest.checkRequires(available)
+}
+
+// describeManifest returns the manifest lines shown by ops -plugin info.
+func describeManifest(m *pluginManifest) []string {
+	lines := []string{}
+	if m.Version != "" {
+		lines = append(lines, "Version:  "+m.Version)
+	}
+	if m.Description != "" {
+		lines = append(lines, "About:    "+m.Description)
+	}
+	if m.MinOpsVersion != "" {
+		lines = append(lines, "Needs:    ops >= "+m.MinOpsVersion)
+	}
+	if m.OlarisVersion != "" {
+		lines = append(lines, "Needs:    olaris "+m.OlarisVersion)
+	}
+	if len(m.Requires) > 0 {
+		requires := make([]string, 0, len(m.Requires))
+		for name, constraint := range m.Requires {
+			requires = append(requires, strings.TrimSpace(name+" "+strings.TrimPrefix(constraint, "*")))
+		}
+		sort.Strings(requires)
+		lines = append(lines, "Requires: "+strings.Join(requires, ", "))
+	}
+	return lines
+}
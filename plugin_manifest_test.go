@@ -0,0 +1,110 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPluginManifestCompatibility(t *testing.T) {
+	manifest := &pluginManifest{Name: "demo", Version: "1.0.0", MinOpsVersion: "0.9.0", OlarisVersion: ">= 0.3.0, < 0.5.0"}
+
+	require.NoError(t, manifest.checkCompatibility("demo", "0.9.0-2607122150.dev", "0.4.2"))
+	// development builds are not checked
+	require.NoError(t, manifest.checkCompatibility("demo", "main", "0.4.2"))
+	require.ErrorContains(t, manifest.checkCompatibility("demo", "0.8.5", "0.4.2"), "requires ops 0.9.0 or newer")
+	require.ErrorContains(t, manifest.checkCompatibility("demo", "0.9.1", "0.5.0"), "requires olaris >= 0.3.0, < 0.5.0")
+	require.ErrorContains(t, manifest.checkCompatibility("other", "0.9.1", "0.4.0"), `holds plugin "demo"`)
+
+	manifest.OlarisVersion = "not a range"
+	require.ErrorContains(t, manifest.checkCompatibility("demo", "0.9.1", "0.4.0"), "invalid olarisVersion")
+}
+
+func writePlugin(t *testing.T, dir, name, manifest string) string {
+	t.Helper()
+	folder := filepath.Join(dir, "olaris-"+name)
+	require.NoError(t, os.MkdirAll(folder, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(folder, OPSFILE), []byte("version: '3'\n"), 0644))
+	if manifest != "" {
+		require.NoError(t, os.WriteFile(filepath.Join(folder, PLUGINMANIFEST), []byte(manifest), 0644))
+	}
+	return folder
+}
+
+func TestPluginProblemsFollowRequirements(t *testing.T) {
+	dir := t.TempDir()
+	db := writePlugin(t, dir, "db", `{"version": "1.2.0"}`)
+	api := writePlugin(t, dir, "api", `{"requires": {"db": "^1.0.0"}}`)
+	web := writePlugin(t, dir, "web", `{"requires": {"api": "*"}}`)
+	old := writePlugin(t, dir, "old", `{"requires": {"db": "< 1.0.0"}}`)
+	future := writePlugin(t, dir, "future", `{"minOpsVersion": "9.0.0"}`)
+	ui := writePlugin(t, dir, "ui", `{"requires": {"future": "*"}}`)
+
+	problems := pluginProblems([]string{db, api, web, old, future, ui}, "0.9.1", "0.4.0")
+	require.Len(t, problems, 3)
+	require.ErrorContains(t, problems[old], "requires plugin db < 1.0.0, found 1.2.0")
+	require.ErrorContains(t, problems[future], "requires ops 9.0.0 or newer")
+	require.ErrorContains(t, problems[ui], "requires plugin future, which is not installed or not enabled")
+}
+
+func TestNewPluginsSkipsIncompatiblePlugins(t *testing.T) {
+	setPluginsHome(t)
+	dir := t.TempDir()
+	t.Setenv("OPS_ROOT_PLUGIN", dir)
+	good := writePlugin(t, dir, "good", "")
+	writePlugin(t, dir, "bad", `{"minOpsVersion": "99.0.0"}`)
+	// the manifest can also live in opsroot.json
+	inRoot := writePlugin(t, dir, "inroot", "")
+	require.NoError(t, os.WriteFile(filepath.Join(inRoot, OPSROOT), []byte(`{"version": "0.1.0", "plugin": {"requires": {"missing": "*"}}}`), 0644))
+
+	oldVersion := OpsVersion
+	OpsVersion = "0.9.1"
+	defer func() { OpsVersion = oldVersion }()
+
+	p, err := newPlugins()
+	require.NoError(t, err)
+	require.Equal(t, []string{good}, p.local)
+}
+
+func TestInstallPluginRejectsIncompatibleUpdate(t *testing.T) {
+	opsHome := setPluginsHome(t)
+	t.Setenv("OPS_ROOT_PLUGIN", t.TempDir())
+	oldVersion := OpsVersion
+	OpsVersion = "0.9.1"
+	defer func() { OpsVersion = oldVersion }()
+
+	src := newPluginRepo(t, "olaris-demo")
+	good := src.commit("plugin.json", `{"name": "demo", "version": "1.0.0", "minOpsVersion": "0.9.0"}`)
+	require.NoError(t, downloadPluginTasksFromRepo(src.dir))
+
+	src.commit("plugin.json", `{"name": "demo", "version": "2.0.0", "minOpsVersion": "1.0.0"}`)
+	err := downloadPluginTasksFromRepo(src.dir)
+	require.ErrorContains(t, err, "requires ops 1.0.0 or newer")
+	require.Contains(t, readPluginFile(t, opsHome, "plugin.json"), `"1.0.0"`)
+	states, err := loadPluginStates()
+	require.NoError(t, err)
+	require.Equal(t, good.String(), states["olaris-demo"].Commit)
+
+	require.NoError(t, pluginCommand("remove", []string{"demo"}))
+	require.ErrorContains(t, downloadPluginTasksFromRepo(src.dir), "cannot install plugin demo")
+	require.NoDirExists(t, filepath.Join(opsHome, "olaris-demo"))
+}
@@ -0,0 +1,207 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// pluginPrecedenceEnv orders where a command is looked up: a comma
+// separated list of olaris (the tasks), local (OPS_ROOT_PLUGIN plugins) and
+// ops (~/.ops plugins). Sources left out keep their default order after the
+// listed ones.
+const pluginPrecedenceEnv = "OPS_PLUGIN_PRECEDENCE"
+
+const (
+	sourceOlaris = "olaris"
+	sourceLocal  = "local"
+	sourceOps    = "ops"
+	// executable plugins always come after the other sources
+	sourceExec = "exec"
+)
+
+var defaultPluginPrecedence = []string{sourceOlaris, sourceLocal, sourceOps}
+
+// pluginPrecedence returns the lookup order of the command sources, falling
+// back to the default when OPS_PLUGIN_PRECEDENCE is invalid.
+func pluginPrecedence() []string {
+	value := os.Getenv(pluginPrecedenceEnv)
+	order := make([]string, 0, len(defaultPluginPrecedence))
+	for _, source := range splitList(value) {
+		source = strings.ToLower(source)
+		if !slices.Contains(defaultPluginPrecedence, source) || slices.Contains(order, source) {
+			if !warnedPlugins[pluginPrecedenceEnv] {
+				warnedPlugins[pluginPrecedenceEnv] = true
+				warn(fmt.Sprintf("invalid %s %q, expected an order of olaris, local and ops", pluginPrecedenceEnv, value))
+			}
+			return defaultPluginPrecedence
+		}
+		order = append(order, source)
+	}
+	for _, source := range defaultPluginPrecedence {
+		if !slices.Contains(order, source) {
+			order = append(order, source)
+		}
+	}
+	return order
+}
+
+// orderPluginFolders lists the local and ~/.ops plugin folders in lookup
+// order, so the first folder with a given name is the one used.
+func orderPluginFolders(local, ops []string) []string {
+	folders := make([]string, 0, len(local)+len(ops))
+	for _, source := range pluginPrecedence() {
+		switch source {
+		case sourceLocal:
+			folders = append(folders, local...)
+		case sourceOps:
+			folders = append(folders, ops...)
+		}
+	}
+	return folders
+}
+
+// pluginsBeforeOlaris tells if plugin commands are looked up before the
+// olaris tasks.
+func pluginsBeforeOlaris() bool {
+	return pluginPrecedence()[0] != sourceOlaris
+}
+
+// find returns the folder of the plugin named name, or "".
+func (p *plugins) find(name string) string {
+	name = strings.TrimPrefix(name, "olaris-")
+	for _, folder := range orderPluginFolders(p.local, p.ops) {
+		if getPluginName(folder) == name {
+			return folder
+		}
+	}
+	return ""
+}
+
+func (p *plugins) sourceOf(folder string) string {
+	if slices.Contains(p.local, folder) {
+		return sourceLocal
+	}
+	return sourceOps
+}
+
+// collisions describes the commands defined more than once among the olaris
+// tasks in olarisDir and the plugins, telling which one wins.
+func (p *plugins) collisions(olarisDir string) []string {
+	commands := map[string][]string{}
+	names := []string{}
+	add := func(name, source string) {
+		if _, ok := commands[name]; !ok {
+			names = append(names, name)
+		}
+		commands[name] = append(commands[name], source)
+	}
+	if olarisDir != "" {
+		for _, task := range getTaskNamesList(olarisDir) {
+			add(task, sourceOlaris)
+		}
+	}
+	for _, folder := range append(append([]string{}, p.local...), p.ops...) {
+		add(getPluginName(folder), p.sourceOf(folder))
+	}
+	for name := range p.exec {
+		add(name, sourceExec)
+	}
+
+	order := append(pluginPrecedence(), sourceExec)
+	sort.Strings(names)
+	messages := []string{}
+	for _, name := range names {
+		sources := commands[name]
+		if len(sources) < 2 {
+			continue
+		}
+		sort.Slice(sources, func(i, j int) bool {
+			return slices.Index(order, sources[i]) < slices.Index(order, sources[j])
+		})
+		describe := func(source string) string {
+			switch source {
+			case sourceOlaris:
+				return "olaris command " + name
+			case sourceExec:
+				return "executable plugin " + filepath.Base(p.exec[name])
+			}
+			return fmt.Sprintf("plugin %s (%s)", name, source)
+		}
+		for _, hidden := range sources[1:] {
+			message := fmt.Sprintf("%s is hidden by %s", describe(hidden), describe(sources[0]))
+			if sources[0] == sourceOlaris {
+				message += fmt.Sprintf(", run it with ops %s:<task> or ops -p %s", name, name)
+			}
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+// splitPluginTask splits the ops <plugin>:<task> syntax; ok is false when
+// arg does not address a plugin, as olaris task names can also contain a
+// colon.
+func splitPluginTask(arg string) (name, task string, ok bool) {
+	name, task, found := strings.Cut(arg, ":")
+	if !found || name == "" || strings.Contains(name, "=") {
+		return "", "", false
+	}
+	if _, err := findTaskInPlugins(name); err != nil && findExecPlugin(name) == "" {
+		return "", "", false
+	}
+	return name, task, true
+}
+
+// runPluginTask runs the tasks of the plugin in dir, with args starting
+// from the task name.
+func runPluginTask(dir string, args []string) error {
+	debug("Found plugin", dir)
+	return Ops(dir, args)
+}
+
+// runNamedPlugin runs the task plugin or, failing that, the executable
+// plugin called name; found is false when there is neither.
+func runNamedPlugin(name string, args []string) (found bool, err error) {
+	if dir, err := findTaskInPlugins(name); err == nil {
+		return true, runPluginTask(dir, args)
+	}
+	if path := findExecPlugin(name); path != "" {
+		return true, runExecPlugin(name, path, args)
+	}
+	return false, nil
+}
+
+// pluginTaskTool implements ops -p <plugin> <task>..., which always runs the
+// plugin even when an olaris command has the same name.
+func pluginTaskTool(args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Println("Usage: ops -p <plugin> [<task> <args>...]")
+		return fmt.Errorf("expected a plugin name")
+	}
+	found, err := runNamedPlugin(strings.TrimPrefix(args[0], "olaris-"), args[1:])
+	if !found {
+		return fmt.Errorf("plugin %s not found, see ops -plugin list", args[0])
+	}
+	return err
+}
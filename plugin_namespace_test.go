@@ -0,0 +1,116 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func makePluginFolder(t *testing.T, dir, name string) string {
+	t.Helper()
+	folder := filepath.Join(dir, name)
+	require.NoError(t, os.MkdirAll(folder, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(folder, OPSFILE), []byte("version: '3'\n"), 0644))
+	return folder
+}
+
+func TestPluginPrecedence(t *testing.T) {
+	t.Setenv(pluginPrecedenceEnv, "")
+	require.Equal(t, []string{"olaris", "local", "ops"}, pluginPrecedence())
+	require.False(t, pluginsBeforeOlaris())
+
+	t.Setenv(pluginPrecedenceEnv, "ops, LOCAL")
+	require.Equal(t, []string{"ops", "local", "olaris"}, pluginPrecedence())
+	require.True(t, pluginsBeforeOlaris())
+
+	t.Setenv(pluginPrecedenceEnv, "local")
+	require.Equal(t, []string{"local", "olaris", "ops"}, pluginPrecedence())
+
+	t.Setenv(pluginPrecedenceEnv, "ops,plugins")
+	require.Equal(t, defaultPluginPrecedence, pluginPrecedence())
+	t.Setenv(pluginPrecedenceEnv, "ops,ops")
+	require.Equal(t, defaultPluginPrecedence, pluginPrecedence())
+}
+
+func TestPluginCollisions(t *testing.T) {
+	opsHome := setPluginsHome(t)
+	localDir := t.TempDir()
+	t.Setenv("OPS_ROOT_PLUGIN", localDir)
+	t.Setenv(pluginPrecedenceEnv, "")
+
+	olarisDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(olarisDir, OPSFILE), []byte("version: '3'\ntasks:\n  deploy: {}\n"), 0644))
+	makePluginFolder(t, olarisDir, "setup")
+
+	localDb := makePluginFolder(t, localDir, "olaris-db")
+	opsDb := makePluginFolder(t, opsHome, "olaris-db")
+	makePluginFolder(t, opsHome, "olaris-deploy")
+	makePluginFolder(t, opsHome, "olaris-other")
+
+	plgs, err := newPlugins()
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"plugin db (ops) is hidden by plugin db (local)",
+		"plugin deploy (ops) is hidden by olaris command deploy, run it with ops deploy:<task> or ops -p deploy",
+	}, plgs.collisions(olarisDir))
+	require.Equal(t, localDb, plgs.find("db"))
+
+	t.Setenv(pluginPrecedenceEnv, "ops,olaris,local")
+	require.Equal(t, []string{
+		"plugin db (local) is hidden by plugin db (ops)",
+		"olaris command deploy is hidden by plugin deploy (ops)",
+	}, plgs.collisions(olarisDir))
+	require.Equal(t, opsDb, plgs.find("olaris-db"))
+
+	opsRoots, err := GetOpsRootPlugins()
+	require.NoError(t, err)
+	require.Equal(t, joinpath(opsDb, OPSROOT), opsRoots["db"])
+}
+
+func TestSplitPluginTask(t *testing.T) {
+	setPluginsHome(t)
+	localDir := t.TempDir()
+	t.Setenv("OPS_ROOT_PLUGIN", localDir)
+	folder := makePluginFolder(t, localDir, "olaris-db")
+
+	name, task, ok := splitPluginTask("db:migrate")
+	require.True(t, ok)
+	require.Equal(t, "db", name)
+	require.Equal(t, "migrate", task)
+	dir, err := findTaskInPlugins(name)
+	require.NoError(t, err)
+	require.Equal(t, folder, dir)
+
+	name, task, ok = splitPluginTask("db:")
+	require.True(t, ok)
+	require.Equal(t, "db", name)
+	require.Empty(t, task)
+
+	// olaris tasks and arguments can contain a colon too
+	for _, arg := range []string{"docker:build", "db", ":db", "url=http://x"} {
+		_, _, ok = splitPluginTask(arg)
+		require.False(t, ok, arg)
+	}
+
+	require.ErrorContains(t, pluginTaskTool([]string{"missing"}), "plugin missing not found")
+	require.ErrorContains(t, pluginTaskTool(nil), "expected a plugin name")
+}
@@ -0,0 +1,390 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/mitchellh/go-homedir"
+)
+
+// pluginTokenEnv holds a token used for private https plugin repositories
+// on the host in pluginTokenHostEnv, when no git credential helper provides
+// one.
+const (
+	pluginTokenEnv     = "OPS_PLUGIN_TOKEN"
+	pluginTokenHostEnv = "OPS_PLUGIN_TOKEN_HOST"
+)
+
+const (
+	refBranch = "branch"
+	refTag    = "tag"
+	refCommit = "commit"
+)
+
+// pluginState is what ops remembers about an installed plugin.
+type pluginState struct {
+	Source  string    `json:"source"`
+	Ref     string    `json:"ref,omitempty"`
+	RefType string    `json:"refType,omitempty"`
+	Commit  string    `json:"commit,omitempty"`
+	Updated time.Time `json:"updated"`
+	// Disabled plugins stay installed but are not loaded.
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+func pluginsHome() (string, error) {
+	return homedir.Expand("~/.ops")
+}
+
+// loadPluginStates reads ~/.ops/plugins.json, keyed by plugin folder name.
+func loadPluginStates() (map[string]*pluginState, error) {
+	home, err := pluginsHome()
+	if err != nil {
+		return nil, err
+	}
+	states := map[string]*pluginState{}
+	data, err := os.ReadFile(filepath.Join(home, "plugins.json"))
+	if os.IsNotExist(err) {
+		return states, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &states); err != nil {
+		return nil, fmt.Errorf("invalid plugins.json: %w", err)
+	}
+	return states, nil
+}
+
+func savePluginStates(states map[string]*pluginState) error {
+	home, err := pluginsHome()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(home, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(home, "plugins.json"), data, 0644)
+}
+
+// splitPluginRef separates the optional @ref from a plugin source. An @ is
+// a ref only after the last path separator, so user@host URLs are kept.
+func splitPluginRef(source string) (string, string) {
+	at := strings.LastIndex(source, "@")
+	if at < 0 || at < strings.LastIndexAny(source, "/:") {
+		return source, ""
+	}
+	return source[:at], source[at+1:]
+}
+
+// isLocalPluginSource tells if source is a path rather than a remote URL.
+func isLocalPluginSource(source string) bool {
+	if strings.Contains(source, "://") {
+		return false
+	}
+	if filepath.IsAbs(source) || strings.HasPrefix(source, ".") || strings.HasPrefix(source, "~") {
+		return true
+	}
+	// scp-like ssh syntax: [user@]host:path
+	return !strings.Contains(source, ":")
+}
+
+// pluginAuth returns the credentials for a remote plugin repository from
+// OPS_PLUGIN_TOKEN or, failing that, from the git credential helpers. The
+// token is sent only over https to the OPS_PLUGIN_TOKEN_HOST host.
+func pluginAuth(source string) transport.AuthMethod {
+	u, err := url.Parse(source)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil
+	}
+	if token := pluginToken(u); token != "" {
+		return &githttp.BasicAuth{Username: "oauth2", Password: token}
+	}
+	user, password := gitCredentialFill(u)
+	if password == "" {
+		return nil
+	}
+	return &githttp.BasicAuth{Username: user, Password: password}
+}
+
+// pluginToken returns OPS_PLUGIN_TOKEN when u is an https URL of the
+// OPS_PLUGIN_TOKEN_HOST host (with the port, if the host gives one).
+func pluginToken(u *url.URL) string {
+	token := os.Getenv(pluginTokenEnv)
+	if token == "" || u.Scheme != "https" {
+		return ""
+	}
+	host := os.Getenv(pluginTokenHostEnv)
+	if host == "" {
+		warn(fmt.Sprintf("%s is ignored: set %s to the host it is for", pluginTokenEnv, pluginTokenHostEnv))
+		return ""
+	}
+	if strings.Contains(host, ":") {
+		if !strings.EqualFold(u.Host, host) {
+			return ""
+		}
+	} else if !strings.EqualFold(u.Hostname(), host) {
+		return ""
+	}
+	return token
+}
+
+// gitCredentialFill asks the configured git credential helpers, never
+// prompting the user.
+var gitCredentialFill = func(u *url.URL) (string, string) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return "", ""
+	}
+	input := fmt.Sprintf("protocol=%s\nhost=%s\npath=%s\n\n", u.Scheme, u.Host, strings.TrimPrefix(u.Path, "/"))
+	cmd := exec.Command("git", "credential", "fill")
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=", "SSH_ASKPASS=")
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return "", ""
+	}
+	var user, password string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, "username="); ok {
+			user = value
+		}
+		if value, ok := strings.CutPrefix(line, "password="); ok {
+			password = value
+		}
+	}
+	return user, password
+}
+
+func isAuthError(err error) bool {
+	return errors.Is(err, transport.ErrAuthenticationRequired) || errors.Is(err, transport.ErrAuthorizationFailed)
+}
+
+// withPluginAuth runs a git operation anonymously first and retries with
+// credentials only when the repository asks for them.
+func withPluginAuth(source string, run func(auth transport.AuthMethod) error) error {
+	err := run(nil)
+	if isAuthError(err) {
+		if auth := pluginAuth(source); auth != nil {
+			return run(auth)
+		}
+	}
+	return err
+}
+
+// checkoutPluginRef moves the worktree to ref, trying a branch, a tag and
+// then a commit. An empty ref keeps the default branch.
+func checkoutPluginRef(repo *git.Repository, ref string) (string, string, error) {
+	w, err := repo.Worktree()
+	if err != nil {
+		return "", "", err
+	}
+	if ref == "" {
+		head, err := repo.Head()
+		if err != nil {
+			return "", "", err
+		}
+		return head.Name().Short(), refBranch, nil
+	}
+
+	if remote, err := repo.Reference(plumbing.NewRemoteReferenceName("origin", ref), true); err == nil {
+		branch := plumbing.NewBranchReferenceName(ref)
+		opts := &git.CheckoutOptions{Branch: branch}
+		if _, err := repo.Reference(branch, true); err != nil {
+			opts.Hash, opts.Create = remote.Hash(), true
+		}
+		if err := w.Checkout(opts); err != nil {
+			return "", "", err
+		}
+		return ref, refBranch, nil
+	}
+	if tag, err := repo.Tag(ref); err == nil {
+		hash := tag.Hash()
+		// annotated tags point to a tag object
+		if object, err := repo.TagObject(hash); err == nil {
+			if commit, err := object.Commit(); err == nil {
+				hash = commit.Hash
+			}
+		}
+		return ref, refTag, w.Checkout(&git.CheckoutOptions{Hash: hash})
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return "", "", fmt.Errorf("no branch, tag or commit %q in the plugin repository", ref)
+	}
+	return ref, refCommit, w.Checkout(&git.CheckoutOptions{Hash: *hash})
+}
+
+func headCommit(repo *git.Repository) string {
+	head, err := repo.Head()
+	if err != nil {
+		return ""
+	}
+	return head.Hash().String()
+}
+
+// samePluginSource tells if two plugin sources name the same repository,
+// ignoring a trailing slash or .git.
+func samePluginSource(a, b string) bool {
+	normalize := func(source string) string {
+		return strings.TrimSuffix(strings.TrimRight(source, "/"), ".git")
+	}
+	return normalize(a) == normalize(b)
+}
+
+// installPlugin clones source at ref into dir, or updates the existing
+// clone keeping the recorded ref unless a new one is given.
+func installPlugin(dir, name, source, ref string) error {
+	states, err := loadPluginStates()
+	if err != nil {
+		return err
+	}
+	state := states[name]
+
+	var repo *git.Repository
+	var previous *plumbing.Reference
+	if isDir(dir) {
+		repo, err = git.PlainOpen(dir)
+		if err != nil {
+			return err
+		}
+		if previous, err = repo.Head(); err != nil {
+			return err
+		}
+		// fetching another repository into the checkout would mix the two
+		if remote, err := repo.Remote("origin"); err == nil && !samePluginSource(remote.Config().URLs[0], source) {
+			return fmt.Errorf("plugin %s is installed from %s, not %s: remove it first with 'ops -plugin remove %s'",
+				getPluginName(name), remote.Config().URLs[0], source, getPluginName(name))
+		}
+		if ref == "" && state != nil {
+			ref = state.Ref
+		}
+		fmt.Println("Updating plugin", name)
+		err = withPluginAuth(source, func(auth transport.AuthMethod) error {
+			return repo.Fetch(&git.FetchOptions{RemoteName: "origin", Auth: auth, Tags: git.AllTags, Force: true})
+		})
+		if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+			return err
+		}
+	} else {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		fmt.Println("Downloading plugins:", name)
+		err = withPluginAuth(source, func(auth transport.AuthMethod) error {
+			var cloneErr error
+			repo, cloneErr = git.PlainClone(dir, false, &git.CloneOptions{URL: source, Auth: auth, Progress: os.Stderr, Tags: git.AllTags})
+			if cloneErr != nil {
+				//nolint:errcheck
+				os.RemoveAll(dir)
+				//nolint:errcheck
+				os.MkdirAll(dir, 0755)
+			}
+			return cloneErr
+		})
+		if err != nil {
+			//nolint:errcheck
+			os.RemoveAll(dir)
+			return err
+		}
+	}
+
+	ref, refType, err := checkoutPluginRef(repo, ref)
+	if err != nil {
+		return err
+	}
+	if refType == refBranch {
+		// follow the remote branch
+		remote, err := repo.Reference(plumbing.NewRemoteReferenceName("origin", ref), true)
+		if err != nil {
+			return err
+		}
+		w, err := repo.Worktree()
+		if err != nil {
+			return err
+		}
+		if remote.Hash().String() == headCommit(repo) && state != nil && state.Ref == ref {
+			fmt.Println("The plugin repo is already up to date!")
+		} else if err := w.Reset(&git.ResetOptions{Commit: remote.Hash(), Mode: git.MergeReset}); err != nil {
+			return err
+		}
+	}
+
+	if err := checkInstalledPlugin(dir); err != nil {
+		if previous == nil {
+			//nolint:errcheck
+			os.RemoveAll(dir)
+			return fmt.Errorf("cannot install plugin %s: %w", getPluginName(name), err)
+		}
+		if restoreErr := restorePluginHead(repo, previous); restoreErr != nil {
+			return fmt.Errorf("cannot update plugin %s: %w (and restoring it failed: %v)", getPluginName(name), err, restoreErr)
+		}
+		return fmt.Errorf("cannot update plugin %s, keeping %s: %w", getPluginName(name), shortHash(previous.Hash().String()), err)
+	}
+
+	states[name] = &pluginState{
+		Source:   source,
+		Ref:      ref,
+		RefType:  refType,
+		Commit:   headCommit(repo),
+		Updated:  time.Now().UTC(),
+		Disabled: state != nil && state.Disabled,
+	}
+	fmt.Printf("Plugin %s at %s %s (%s)\n", name, refType, ref, shortHash(states[name].Commit))
+	return savePluginStates(states)
+}
+
+// restorePluginHead puts a plugin back where it was before a rejected update.
+func restorePluginHead(repo *git.Repository, previous *plumbing.Reference) error {
+	w, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	if previous.Name().IsBranch() {
+		if err := w.Checkout(&git.CheckoutOptions{Branch: previous.Name(), Force: true}); err != nil {
+			return err
+		}
+		return w.Reset(&git.ResetOptions{Commit: previous.Hash(), Mode: git.HardReset})
+	}
+	return w.Checkout(&git.CheckoutOptions{Hash: previous.Hash(), Force: true})
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 8 {
+		return hash[:8]
+	}
+	return hash
+}
@@ -0,0 +1,255 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/mitchellh/go-homedir"
+	"github.com/stretchr/testify/require"
+)
+
+// setPluginsHome points ~/.ops to a temporary home for the test.
+func setPluginsHome(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	homedir.DisableCache = true
+	// Dir caches the temporary home even with the cache disabled
+	t.Cleanup(func() {
+		homedir.DisableCache = false
+		homedir.Reset()
+	})
+	return filepath.Join(home, ".ops")
+}
+
+// pluginRepo is a local plugin repository the tests install from.
+type pluginRepo struct {
+	t    *testing.T
+	dir  string
+	repo *git.Repository
+}
+
+func newPluginRepo(t *testing.T, name string) *pluginRepo {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), name)
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	p := &pluginRepo{t: t, dir: dir, repo: repo}
+	p.commit("opsfile.yml", "version: '3'\n")
+	return p
+}
+
+func (p *pluginRepo) commit(file, content string) plumbing.Hash {
+	p.t.Helper()
+	require.NoError(p.t, os.WriteFile(filepath.Join(p.dir, file), []byte(content), 0644))
+	w, err := p.repo.Worktree()
+	require.NoError(p.t, err)
+	_, err = w.Add(file)
+	require.NoError(p.t, err)
+	hash, err := w.Commit("update "+file, &git.CommitOptions{
+		Author: &object.Signature{Name: "ops", Email: "ops@example.com", When: time.Now()},
+	})
+	require.NoError(p.t, err)
+	return hash
+}
+
+func (p *pluginRepo) checkout(branch string, create bool) {
+	p.t.Helper()
+	w, err := p.repo.Worktree()
+	require.NoError(p.t, err)
+	require.NoError(p.t, w.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(branch), Create: create}))
+}
+
+func (p *pluginRepo) defaultBranch() string {
+	head, err := p.repo.Head()
+	require.NoError(p.t, err)
+	return head.Name().Short()
+}
+
+func readPluginFile(t *testing.T, opsHome, file string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(opsHome, "olaris-demo", file))
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestSplitPluginRef(t *testing.T) {
+	for _, tc := range []struct{ in, source, ref string }{
+		{"https://github.com/org/olaris-x", "https://github.com/org/olaris-x", ""},
+		{"https://github.com/org/olaris-x@v1.2.0", "https://github.com/org/olaris-x", "v1.2.0"},
+		{"https://user@host/org/olaris-x.git", "https://user@host/org/olaris-x.git", ""},
+		{"git@github.com:org/olaris-x.git", "git@github.com:org/olaris-x.git", ""},
+		{"git@github.com:org/olaris-x.git@dev", "git@github.com:org/olaris-x.git", "dev"},
+		{"./olaris-x@0a1b2c3", "./olaris-x", "0a1b2c3"},
+	} {
+		source, ref := splitPluginRef(tc.in)
+		require.Equal(t, tc.source, source, tc.in)
+		require.Equal(t, tc.ref, ref, tc.in)
+	}
+}
+
+func TestCheckGitRepoAcceptsSSHAndPaths(t *testing.T) {
+	for _, source := range []string{
+		"git@github.com:org/olaris-x.git",
+		"ssh://git@github.com/org/olaris-x",
+		"file:///srv/git/olaris-x",
+		"/srv/git/olaris-x",
+		"../olaris-x",
+	} {
+		ok, name := checkGitRepo(source)
+		require.True(t, ok, source)
+		require.Equal(t, "olaris-x", name, source)
+	}
+	ok, _ := checkGitRepo("http://example.com/olaris-x")
+	require.False(t, ok)
+}
+
+func TestInstallPluginFollowsRefs(t *testing.T) {
+	opsHome := setPluginsHome(t)
+	src := newPluginRepo(t, "olaris-demo")
+	main := src.defaultBranch()
+	src.commit("version", "1")
+	_, err := src.repo.CreateTag("v1", mustHead(t, src.repo), nil)
+	require.NoError(t, err)
+	first := src.commit("version", "2")
+	src.checkout("dev", true)
+	src.commit("version", "dev")
+	src.checkout(main, false)
+
+	// default branch
+	require.NoError(t, downloadPluginTasksFromRepo(src.dir))
+	require.Equal(t, "2", readPluginFile(t, opsHome, "version"))
+
+	// a tag is kept on update
+	require.NoError(t, downloadPluginTasksFromRepo(src.dir+"@v1"))
+	require.Equal(t, "1", readPluginFile(t, opsHome, "version"))
+	src.commit("version", "3")
+	require.NoError(t, downloadPluginTasksFromRepo(src.dir))
+	require.Equal(t, "1", readPluginFile(t, opsHome, "version"))
+
+	states, err := loadPluginStates()
+	require.NoError(t, err)
+	require.Equal(t, refTag, states["olaris-demo"].RefType)
+	require.Equal(t, src.dir, states["olaris-demo"].Source)
+
+	// a branch follows new commits
+	require.NoError(t, downloadPluginTasksFromRepo(src.dir+"@dev"))
+	require.Equal(t, "dev", readPluginFile(t, opsHome, "version"))
+	src.checkout("dev", false)
+	src.commit("version", "dev2")
+	require.NoError(t, downloadPluginTasksFromRepo(src.dir))
+	require.Equal(t, "dev2", readPluginFile(t, opsHome, "version"))
+
+	// a commit
+	require.NoError(t, downloadPluginTasksFromRepo(src.dir+"@"+first.String()[:10]))
+	require.Equal(t, "2", readPluginFile(t, opsHome, "version"))
+	states, err = loadPluginStates()
+	require.NoError(t, err)
+	require.Equal(t, refCommit, states["olaris-demo"].RefType)
+	require.Equal(t, first.String(), states["olaris-demo"].Commit)
+
+	require.ErrorContains(t, downloadPluginTasksFromRepo(src.dir+"@nope"), `no branch, tag or commit "nope"`)
+}
+
+func TestInstallPluginRefusesAnotherSource(t *testing.T) {
+	opsHome := setPluginsHome(t)
+	src := newPluginRepo(t, "olaris-demo")
+	src.commit("version", "1")
+	other := newPluginRepo(t, "olaris-demo")
+	other.commit("version", "other")
+
+	require.NoError(t, downloadPluginTasksFromRepo(src.dir))
+	require.NoError(t, downloadPluginTasksFromRepo(src.dir+"/"))
+	err := downloadPluginTasksFromRepo(other.dir)
+	require.ErrorContains(t, err, "is installed from "+src.dir)
+	require.ErrorContains(t, err, "ops -plugin remove demo")
+	require.Equal(t, "1", readPluginFile(t, opsHome, "version"))
+
+	require.NoError(t, removePlugin("demo"))
+	require.NoError(t, downloadPluginTasksFromRepo(other.dir))
+	require.Equal(t, "other", readPluginFile(t, opsHome, "version"))
+}
+
+func mustHead(t *testing.T, repo *git.Repository) plumbing.Hash {
+	t.Helper()
+	head, err := repo.Head()
+	require.NoError(t, err)
+	return head.Hash()
+}
+
+func TestPluginAuthUsesTokenThenCredentialHelper(t *testing.T) {
+	oldFill := gitCredentialFill
+	defer func() { gitCredentialFill = oldFill }()
+	gitCredentialFill = func(u *url.URL) (string, string) {
+		require.Equal(t, "git.example.com", u.Host)
+		return "alice", "helper-secret"
+	}
+
+	t.Setenv(pluginTokenEnv, "")
+	auth := pluginAuth("https://git.example.com/team/olaris-x")
+	require.Equal(t, "http-basic-auth - alice:*******", auth.String())
+
+	t.Setenv(pluginTokenEnv, "token")
+	t.Setenv(pluginTokenHostEnv, "git.example.com")
+	auth = pluginAuth("https://git.example.com/team/olaris-x")
+	require.Equal(t, "http-basic-auth - oauth2:*******", auth.String())
+
+	require.Nil(t, pluginAuth("git@git.example.com:team/olaris-x"))
+}
+
+func TestPluginTokenOnlyForItsHostOverHTTPS(t *testing.T) {
+	oldFill := gitCredentialFill
+	defer func() { gitCredentialFill = oldFill }()
+	gitCredentialFill = func(u *url.URL) (string, string) { return "", "" }
+
+	t.Setenv(pluginTokenEnv, "token")
+	t.Setenv(pluginTokenHostEnv, "")
+	require.Nil(t, pluginAuth("https://git.example.com/team/olaris-x"))
+
+	t.Setenv(pluginTokenHostEnv, "git.example.com")
+	require.NotNil(t, pluginAuth("https://git.example.com:8443/team/olaris-x"))
+	require.Nil(t, pluginAuth("https://evil.example.com/team/olaris-x"))
+	require.Nil(t, pluginAuth("http://git.example.com/team/olaris-x"))
+
+	t.Setenv(pluginTokenHostEnv, "git.example.com:8443")
+	require.NotNil(t, pluginAuth("https://git.example.com:8443/team/olaris-x"))
+	require.Nil(t, pluginAuth("https://git.example.com/team/olaris-x"))
+
+	// the token is never sent before the repository asks for credentials
+	var sent []transport.AuthMethod
+	err := withPluginAuth("https://git.example.com:8443/team/olaris-x", func(auth transport.AuthMethod) error {
+		sent = append(sent, auth)
+		if auth == nil {
+			return transport.ErrAuthenticationRequired
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, sent, 2)
+	require.Nil(t, sent[0])
+	require.NotNil(t, sent[1])
+}
@@ -0,0 +1,176 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// pluginCheckTimeout bounds the remote checks of the periodic update check.
+var pluginCheckTimeout = 10 * time.Second
+
+// pluginUpdate is a plugin whose branch moved upstream.
+type pluginUpdate struct {
+	name    string
+	branch  string
+	current string
+	latest  string
+}
+
+// checkPluginUpdate compares the plugin in dir with its remote branch. It
+// returns nil when the plugin is up to date or pinned to a tag or commit.
+func checkPluginUpdate(ctx context.Context, dir string, state *pluginState) (*pluginUpdate, error) {
+	if state != nil && state.RefType != "" && state.RefType != refBranch {
+		return nil, nil
+	}
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return nil, err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	if !head.Name().IsBranch() {
+		return nil, nil
+	}
+	source, err := pluginSourceOf(dir, state)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := repo.Remote("origin")
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []*plumbing.Reference
+	err = withPluginAuth(source, func(auth transport.AuthMethod) error {
+		var listErr error
+		refs, listErr = remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+		return listErr
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range refs {
+		if ref.Name() == head.Name() && ref.Hash() != head.Hash() {
+			return &pluginUpdate{
+				name:    getPluginName(dir),
+				branch:  head.Name().Short(),
+				current: head.Hash().String(),
+				latest:  ref.Hash().String(),
+			}, nil
+		}
+	}
+	return nil, nil
+}
+
+// checkPluginUpdates checks all the installed plugins in parallel. Plugins
+// that cannot be checked before ctx expires are skipped.
+func checkPluginUpdates(ctx context.Context) []*pluginUpdate {
+	folders, err := installedPluginFolders()
+	if err != nil || len(folders) == 0 {
+		return nil
+	}
+	states, err := loadPluginStates()
+	if err != nil {
+		debug("cannot read the plugins state:", err)
+		states = map[string]*pluginState{}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	updates := []*pluginUpdate{}
+	for _, folder := range folders {
+		wg.Add(1)
+		go func(folder string) {
+			defer wg.Done()
+			update, err := checkPluginUpdate(ctx, folder, states[filepath.Base(folder)])
+			if err != nil {
+				debug("cannot check plugin", getPluginName(folder), err)
+				return
+			}
+			if update != nil {
+				mu.Lock()
+				updates = append(updates, update)
+				mu.Unlock()
+			}
+		}(folder)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		debug("plugin update check timed out")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	result := append([]*pluginUpdate{}, updates...)
+	sort.Slice(result, func(i, j int) bool { return result[i].name < result[j].name })
+	return result
+}
+
+// printUpdatesSummary reports in one place whether the tasks and the
+// plugins can be updated.
+func printUpdatesSummary(tasks bool, plugins []*pluginUpdate) {
+	if len(plugins) == 0 {
+		if tasks {
+			fmt.Print("New tasks available! Use 'ops -update' to update.\n\n")
+		} else {
+			fmt.Print("Tasks up to date!\n\n")
+		}
+		return
+	}
+	fmt.Println("Updates available:")
+	if tasks {
+		fmt.Println("  tasks")
+	}
+	for _, plugin := range plugins {
+		fmt.Println(" ", plugin)
+	}
+	fmt.Print("Use 'ops -update' to update.\n\n")
+}
+
+func (u *pluginUpdate) String() string {
+	return fmt.Sprintf("plugin %s (%s %s -> %s)", u.name, u.branch, shortHash(u.current), shortHash(u.latest))
+}
+
+// updateInstalledPlugins updates every installed plugin after the tasks,
+// for ops -update.
+func updateInstalledPlugins() error {
+	folders, err := installedPluginFolders()
+	if err != nil || len(folders) == 0 {
+		return err
+	}
+	return updatePlugins(nil, true)
+}
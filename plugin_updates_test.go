@@ -0,0 +1,64 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPluginUpdates(t *testing.T) {
+	opsHome, src := installDemoPlugin(t)
+	other := newPluginRepo(t, "olaris-other")
+	require.NoError(t, downloadPluginTasksFromRepo(other.dir+"@"+other.defaultBranch()))
+	pinned := newPluginRepo(t, "olaris-pinned")
+	first := pinned.commit("opsfile.yml", "version: '3'\n# v1\n")
+	require.NoError(t, downloadPluginTasksFromRepo(pinned.dir+"@"+first.String()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	require.Empty(t, checkPluginUpdates(ctx))
+
+	installed, err := git.PlainOpen(filepath.Join(opsHome, "olaris-demo"))
+	require.NoError(t, err)
+	current := headCommit(installed)
+	latest := src.commit("opsfile.yml", "version: '3'\n# new\n")
+	pinned.commit("opsfile.yml", "version: '3'\n# v2\n")
+	updates := checkPluginUpdates(ctx)
+	require.Len(t, updates, 1)
+	require.Equal(t, &pluginUpdate{name: "demo", branch: src.defaultBranch(), current: current, latest: latest.String()}, updates[0])
+
+	out := captureStdout(t, func() { printUpdatesSummary(true, updates) })
+	require.Equal(t, "Updates available:\n  tasks\n  plugin demo ("+src.defaultBranch()+" "+
+		shortHash(current)+" -> "+shortHash(latest.String())+")\nUse 'ops -update' to update.\n\n", out)
+
+	require.NoError(t, updateInstalledPlugins())
+	require.Empty(t, checkPluginUpdates(ctx))
+}
+
+func TestPrintUpdatesSummaryTasksOnly(t *testing.T) {
+	out := captureStdout(t, func() { printUpdatesSummary(true, nil) })
+	require.Equal(t, "New tasks available! Use 'ops -update' to update.\n\n", out)
+	out = captureStdout(t, func() { printUpdatesSummary(false, nil) })
+	require.Equal(t, "Tasks up to date!\n\n", out)
+}
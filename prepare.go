@@ -26,7 +26,6 @@ import (
 
 	"github.com/Masterminds/semver"
 	git "github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/mitchellh/go-homedir"
 )
 
@@ -49,54 +48,45 @@ func downloadTasksFromGitHub(force bool, silent bool) (string, error) {
 	}
 	debug("localDir", localDir)
 
+	ref, err := tasksRef()
+	if err != nil {
+		return "", err
+	}
+	source, err := newTasksSource(repoURL, ref)
+	if err != nil {
+		return "", err
+	}
+
 	// Updating existing tools
 	if exists(opsBranchDir, "olaris") {
 		trace("Updating olaris in", opsBranchDir)
 		fmt.Println("Updating tasks...")
-		r, err := git.PlainOpen(localDir)
+		oldID, oldVersion := currentTasksID(localDir), tasksVersion(localDir)
+		updated, err := updateTasks(opsBranchDir, source)
 		if err != nil {
 			return "", err
 		}
-		// Get the working directory for the repository
-		w, err := r.Worktree()
-		if err != nil {
-			return "", err
-		}
-
-		// Pull the latest changes from the origin remote and merge into the current branch
-		// Clone the repo if not existing
-		ref := plumbing.NewBranchReferenceName(branch)
-		err = w.Pull(&git.PullOptions{
-			RemoteName:    "origin",
-			ReferenceName: ref,
-			SingleBranch:  true,
-		})
-		if err != nil {
-			if err.Error() == "already up-to-date" {
-				fmt.Println("Tasks are already up to date!")
-				return localDir, nil
-			}
-			return "", err
+		if !updated {
+			fmt.Println("Tasks are already up to date!")
+			return localDir, nil
 		}
 
 		fmt.Println("Tasks updated successfully")
+		printTasksChanges(localDir, oldID, oldVersion)
 		touchLatestCheckFile(joinpath(opsBranchDir, LATESTCHECK))
 		return localDir, nil
 	}
 
 	// Clone the repo if not existing
-	ref := plumbing.NewBranchReferenceName(branch)
-	cloneOpts := &git.CloneOptions{
-		URL:           repoURL,
-		Progress:      os.Stderr,
-		ReferenceName: ref, // Specify the branch to clone
+	if _, isGit := source.(*gitTasksSource); isGit {
+		fmt.Println("Cloning tasks...")
+	} else {
+		fmt.Println("Downloading tasks from", source)
 	}
-
-	fmt.Println("Cloning tasks...")
-	_, err = git.PlainClone(localDir, false, cloneOpts)
+	_, err = fetchTasks(source, localDir)
 	if err != nil {
 		os.RemoveAll(opsBranchDir)
-		warn(fmt.Sprintf("failed to clone olaris on branch '%s'", branch))
+		warn(fmt.Sprintf("failed to download the tasks of '%s' from %s", ref, source))
 		return "", err
 	}
 
@@ -113,7 +103,7 @@ func pullTasks(force, silent bool) (string, error) {
 	localDir, err := downloadTasksFromGitHub(force, silent)
 	debug("localDir", localDir)
 	if err != nil {
-		return "", fmt.Errorf("cannot update tasks because: %s\nremove the folder ~/.ops and run ops -update", err.Error())
+		return "", fmt.Errorf("cannot update tasks because: %s\nif ops does not work, run ops -update --rollback, or remove the folder ~/.ops and run ops -update", err.Error())
 	}
 
 	err = ensurePrereq(localDir)
@@ -145,7 +135,7 @@ func pullTasks(force, silent bool) (string, error) {
 	if opsVersion.LessThan(opsRootVersion) {
 		fmt.Println()
 		fmt.Printf("Your ops version (%v) is older than the required version (%v).\n", opsVersion, opsRootVersion)
-		if err := autoCLIUpdate(); err != nil {
+		if err := autoCLIUpdate(opsRoot.Version); err != nil {
 			return "", err
 		}
 	}
@@ -212,13 +202,24 @@ func locateOpsRootSearch(cur string) string {
 	return locateOpsRootSearch(parent)
 }
 
-func autoCLIUpdate() error {
-	cli := os.Getenv("OPS_CMD")
-	trace("autoCLIUpdate", cli)
-	cmd := exec.Command(cli, "util", "update-cli")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// autoCLIUpdate replaces ops with the version the tasks require, unless a
+// version is pinned. Without trusted keys the release cannot be verified, so
+// the update is left to an explicit ops -selfupdate --insecure.
+func autoCLIUpdate(version string) error {
+	trace("autoCLIUpdate", version)
+	if pinned := os.Getenv(selfUpdatePinEnv); pinned != "" {
+		warn(fmt.Sprintf("ops is pinned to %s by %s, not updating it to %s", pinned, selfUpdatePinEnv, version))
+		return nil
+	}
+	updater, err := newSelfUpdater()
+	if err != nil {
+		return err
+	}
+	if len(updater.keys) == 0 {
+		fmt.Printf("Set %s to update ops automatically, or run 'ops -selfupdate --insecure' to install it unverified.\n", selfUpdateKeysEnv)
+		return nil
+	}
+	return updater.update(version, false)
 }
 
 func checkOperatorVersion(opsRootConfig map[string]interface{}) error {
@@ -235,7 +236,13 @@ func setOpsOlarisHash(olarisDir string) error {
 	trace("setOpsOlarisHash", olarisDir)
 	r, err := git.PlainOpen(olarisDir)
 	if err != nil {
-		return err
+		// tasks not from git record their id
+		id := currentTasksID(olarisDir)
+		if id == "" {
+			return err
+		}
+		os.Setenv("OPS_OLARIS", id)
+		return nil
 	}
 	h, err := r.Head()
 	if err != nil {
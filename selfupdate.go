@@ -0,0 +1,448 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"github.com/apache/openserverless-cli/tools"
+	"github.com/mitchellh/go-homedir"
+)
+
+const (
+	// selfUpdateURLEnv is where the releases are published, as
+	// <url>/v<version>/openserverless-cli_<version>_<os>_<arch>.tar.gz
+	selfUpdateURLEnv = "OPS_RELEASES_URL"
+	// selfUpdateKeysEnv lists the ed25519 public keys, in base64, trusted
+	// to sign the checksums of the releases.
+	selfUpdateKeysEnv = "OPS_RELEASES_TRUSTED_KEYS"
+	// selfUpdatePinEnv pins the version installed by ops -selfupdate and
+	// by the automatic updates.
+	selfUpdatePinEnv = "OPS_SELFUPDATE_VERSION"
+)
+
+const defaultSelfUpdateURL = "https://github.com/apache/openserverless-cli/releases/download"
+
+// releaseProject prefixes the names of the release files, as published by
+// goreleaser (see .goreleaser.yaml).
+const releaseProject = "openserverless-cli"
+
+// the largest archive ops downloads
+const maxReleaseSize = 256 << 20
+
+// selfUpdater replaces the ops executable exe with a release.
+type selfUpdater struct {
+	baseURL string
+	goos    string
+	goarch  string
+	exe     string
+	keys    []string
+	// insecure accepts a release without a verified signature
+	insecure bool
+}
+
+func newSelfUpdater() (*selfUpdater, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return nil, err
+	}
+	baseURL := os.Getenv(selfUpdateURLEnv)
+	if baseURL == "" {
+		baseURL = defaultSelfUpdateURL
+	}
+	return &selfUpdater{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		goos:    tools.GetOS(),
+		goarch:  tools.GetARCH(),
+		exe:     exe,
+		keys:    splitList(os.Getenv(selfUpdateKeysEnv)),
+	}, nil
+}
+
+// artifact is the name of the release archive for the platform.
+func (u *selfUpdater) artifact(version string) string {
+	ext := ".tar.gz"
+	if u.goos == "windows" {
+		ext = ".zip"
+	}
+	return fmt.Sprintf("%s_%s_%s_%s%s", releaseProject, version, u.goos, u.goarch, ext)
+}
+
+// checksums is the name of the sha256sum list of the release archives,
+// signed by the detached base64 signature in <checksums>.sig.
+func checksums(version string) string {
+	return fmt.Sprintf("%s_%s_checksums.txt", releaseProject, version)
+}
+
+func (u *selfUpdater) releaseURL(version, file string) string {
+	return fmt.Sprintf("%s/v%s/%s", u.baseURL, strings.TrimPrefix(version, "v"), file)
+}
+
+func fetchRelease(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot download %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxReleaseSize))
+}
+
+// checksum returns the verified sha256 of file in the release.
+func (u *selfUpdater) checksum(version, file string) (string, error) {
+	url := u.releaseURL(version, checksums(version))
+	sums, err := fetchRelease(url)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case len(u.keys) > 0:
+		if err := u.verifySignature(url, sums); err != nil {
+			return "", err
+		}
+	case u.insecure:
+		warn(fmt.Sprintf("the signature of ops %s is not verified (--insecure)", version))
+	default:
+		return "", fmt.Errorf("cannot verify the signature of ops %s: set %s, or add --insecure to trust the checksums of the download server", version, selfUpdateKeysEnv)
+	}
+	for _, line := range strings.Split(string(sums), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == file {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("no checksum for %s in %s", file, url)
+}
+
+func (u *selfUpdater) verifySignature(url string, data []byte) error {
+	sig, err := fetchRelease(url + ".sig")
+	if err != nil {
+		return fmt.Errorf("the release is not signed: %w", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return fmt.Errorf("invalid signature %s.sig: %w", url, err)
+	}
+	for _, key := range u.keys {
+		publicKey, err := base64.StdEncoding.DecodeString(key)
+		if err != nil || len(publicKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid key in %s: %q", selfUpdateKeysEnv, key)
+		}
+		if ed25519.Verify(publicKey, data, signature) {
+			return nil
+		}
+	}
+	return fmt.Errorf("signature of %s does not match any trusted key", url)
+}
+
+// download returns the verified ops executable of version.
+func (u *selfUpdater) download(version string) ([]byte, error) {
+	file := u.artifact(version)
+	sum, err := u.checksum(version, file)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := fetchRelease(u.releaseURL(version, file))
+	if err != nil {
+		return nil, err
+	}
+	actual := sha256.Sum256(archive)
+	if hex.EncodeToString(actual[:]) != sum {
+		return nil, fmt.Errorf("checksum mismatch for %s", file)
+	}
+	name := "ops"
+	if u.goos == "windows" {
+		name = "ops.exe"
+	}
+	if strings.HasSuffix(file, ".zip") {
+		return extractZipFile(archive, name)
+	}
+	return extractTarFile(archive, name)
+}
+
+func extractTarFile(archive []byte, name string) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no %s in the release", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg && filepath.Base(hdr.Name) == name {
+			return io.ReadAll(io.LimitReader(tr, maxReleaseSize))
+		}
+	}
+}
+
+func extractZipFile(archive []byte, name string) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range zr.File {
+		if filepath.Base(f.Name) != name || f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(io.LimitReader(rc, maxReleaseSize))
+	}
+	return nil, fmt.Errorf("no %s in the release", name)
+}
+
+// install replaces the executable with binary, keeping the current one in
+// <exe>.old. The new executable must report version before it is swapped
+// in.
+func (u *selfUpdater) install(version string, binary []byte) error {
+	dir, name := filepath.Split(u.exe)
+	staged, err := os.CreateTemp(dir, "."+name+".new-*")
+	if err != nil {
+		return fmt.Errorf("cannot write in %s: %w", dir, err)
+	}
+	stagedPath := staged.Name()
+	//nolint:errcheck
+	defer os.Remove(stagedPath)
+	_, err = staged.Write(binary)
+	if closeErr := staged.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(stagedPath, 0755)
+	}
+	if err != nil {
+		return err
+	}
+	// only a binary for this machine can be checked
+	if u.goos == runtime.GOOS && u.goarch == runtime.GOARCH {
+		if err := checkOpsVersion(stagedPath, version); err != nil {
+			return err
+		}
+	}
+
+	backup := u.exe + ".old"
+	if err := os.Remove(backup); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// windows cannot replace a running executable, but can rename it
+	if runtime.GOOS == "windows" {
+		if err := os.Rename(u.exe, backup); err != nil {
+			return err
+		}
+		if err := os.Rename(stagedPath, u.exe); err != nil {
+			//nolint:errcheck
+			os.Rename(backup, u.exe)
+			return err
+		}
+		return nil
+	}
+	if err := os.Link(u.exe, backup); err != nil {
+		if err := copyExecutable(u.exe, backup); err != nil {
+			return fmt.Errorf("cannot keep a backup of %s: %w", u.exe, err)
+		}
+	}
+	return os.Rename(stagedPath, u.exe)
+}
+
+func copyExecutable(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, info.Mode().Perm())
+}
+
+// checkOpsVersion runs exe -version and checks it reports version.
+func checkOpsVersion(exe, version string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, exe, "-version")
+	// OPS_VERSION would override the version of the new ops
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, "OPS_VERSION=") {
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("the new ops does not run: %w", err)
+	}
+	reported := strings.TrimSpace(string(out))
+	expected, err := semver.NewVersion(version)
+	if err != nil {
+		return fmt.Errorf("invalid version %q: %w", version, err)
+	}
+	if actual, err := semver.NewVersion(reported); err != nil || !actual.Equal(expected) {
+		return fmt.Errorf("the new ops reports version %q instead of %s", reported, version)
+	}
+	return nil
+}
+
+// rollback restores the executable replaced by the last update.
+func (u *selfUpdater) rollback() error {
+	backup := u.exe + ".old"
+	if _, err := os.Stat(backup); err != nil {
+		return fmt.Errorf("no previous ops to roll back to in %s", backup)
+	}
+	if runtime.GOOS == "windows" {
+		failed := u.exe + ".failed"
+		if err := os.Rename(u.exe, failed); err != nil {
+			return err
+		}
+		if err := os.Rename(backup, u.exe); err != nil {
+			//nolint:errcheck
+			os.Rename(failed, u.exe)
+			return err
+		}
+		return nil
+	}
+	return os.Rename(backup, u.exe)
+}
+
+// update installs version, refusing to go back to an older one unless
+// downgrade is set.
+func (u *selfUpdater) update(version string, downgrade bool) error {
+	version = strings.TrimPrefix(version, "v")
+	target, err := semver.NewVersion(version)
+	if err != nil {
+		return fmt.Errorf("invalid version %q: %w", version, err)
+	}
+	if current, err := semver.NewVersion(OpsVersion); err == nil {
+		if current.Equal(target) {
+			fmt.Printf("ops %s is already installed\n", OpsVersion)
+			return nil
+		}
+		if current.GreaterThan(target) && !downgrade {
+			return fmt.Errorf("ops %s is newer than %s, add --downgrade to install it", OpsVersion, version)
+		}
+	}
+	fmt.Printf("Downloading ops %s for %s/%s...\n", version, u.goos, u.goarch)
+	binary, err := u.download(version)
+	if err != nil {
+		return err
+	}
+	if err := u.install(version, binary); err != nil {
+		return err
+	}
+	fmt.Printf("ops updated from %s to %s, the previous one is in %s.old\n", OpsVersion, version, u.exe)
+	return nil
+}
+
+// requiredOpsVersion is the version of ops the current tasks ask for.
+func requiredOpsVersion() (string, error) {
+	dir := os.Getenv("OPS_ROOT")
+	if dir == "" {
+		var err error
+		if dir, err = homedir.Expand(joinpath(joinpath("~/.ops", getOpsBranch()), "olaris")); err != nil {
+			return "", err
+		}
+	}
+	opsRoot, err := readOpsRootFile(dir)
+	if err != nil || opsRoot.Version == "" {
+		return "", errors.New("cannot find the version required by the tasks, give the version to install")
+	}
+	return opsRoot.Version, nil
+}
+
+// selfUpdateTool implements ops -selfupdate [<version>] [--downgrade]
+// [--rollback] [--insecure].
+func selfUpdateTool(args []string) error {
+	version, downgrade, rollback, insecure := "", false, false, false
+	for _, arg := range args {
+		switch arg {
+		case "-h", "--help":
+			fmt.Println(`Usage: ops -selfupdate [<version>] [--downgrade] [--rollback] [--insecure]
+
+Replace ops with the release <version>, by default the one the tasks require,
+or the one pinned in OPS_SELFUPDATE_VERSION.
+
+  --downgrade  allow installing an older version
+  --rollback   restore the ops replaced by the last update
+  --insecure   install without a signature verified with OPS_RELEASES_TRUSTED_KEYS`)
+			return nil
+		case "--downgrade":
+			downgrade = true
+		case "--rollback":
+			rollback = true
+		case "--insecure":
+			insecure = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("unknown option %s", arg)
+			}
+			version = arg
+		}
+	}
+	updater, err := newSelfUpdater()
+	if err != nil {
+		return err
+	}
+	updater.insecure = insecure
+	if rollback {
+		if err := updater.rollback(); err != nil {
+			return err
+		}
+		fmt.Println("ops rolled back")
+		return nil
+	}
+	if version == "" {
+		// a pinned version can be older than the current one
+		if version = os.Getenv(selfUpdatePinEnv); version != "" {
+			downgrade = true
+		} else if version, err = requiredOpsVersion(); err != nil {
+			return err
+		}
+	}
+	return updater.update(version, downgrade)
+}
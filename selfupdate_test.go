@@ -0,0 +1,213 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func opsScript(version string) string {
+	return "#!/bin/sh\necho " + version + "\n"
+}
+
+// releaseArchive returns a tar.gz with the ops executable, as published
+// in the releases.
+func releaseArchive(t *testing.T, content string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, data := range map[string]string{"LICENSE": "license", "ops": content} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0755, Size: int64(len(data))}))
+		_, err := tw.Write([]byte(data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+// setupSelfUpdate serves the releases 0.2.0 and 0.0.9 signed by key, and
+// returns an updater for an ops 0.1.0 executable.
+func setupSelfUpdate(t *testing.T) (*selfUpdater, map[string][]byte, ed25519.PrivateKey) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ops is a shell script")
+	}
+	public, private, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	oldVersion := OpsVersion
+	OpsVersion = "0.1.0"
+	t.Cleanup(func() { OpsVersion = oldVersion })
+
+	files := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+
+	exe := filepath.Join(t.TempDir(), "ops")
+	require.NoError(t, os.WriteFile(exe, []byte(opsScript("0.1.0")), 0755))
+	u := &selfUpdater{
+		baseURL: server.URL + "/releases",
+		goos:    runtime.GOOS,
+		goarch:  runtime.GOARCH,
+		exe:     exe,
+		keys:    []string{base64.StdEncoding.EncodeToString(public)},
+	}
+	// the files as named by goreleaser
+	for _, version := range []string{"0.2.0", "0.0.9"} {
+		archive := releaseArchive(t, opsScript(version))
+		artifact := "openserverless-cli_" + version + "_" + runtime.GOOS + "_" + runtime.GOARCH + ".tar.gz"
+		sums := []byte(sha256Hex(archive) + "  " + artifact + "\n")
+		files["/releases/v"+version+"/"+artifact] = archive
+		files["/releases/v"+version+"/openserverless-cli_"+version+"_checksums.txt"] = sums
+		files["/releases/v"+version+"/openserverless-cli_"+version+"_checksums.txt.sig"] = []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(private, sums)))
+	}
+	return u, files, private
+}
+
+func readOps(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestSelfUpdateReleaseNames(t *testing.T) {
+	u := &selfUpdater{baseURL: "https://example.com/releases", goos: "windows", goarch: "amd64"}
+	require.Equal(t, "openserverless-cli_0.2.0_windows_amd64.zip", u.artifact("0.2.0"))
+	u.goos = "darwin"
+	require.Equal(t, "openserverless-cli_0.2.0_darwin_amd64.tar.gz", u.artifact("0.2.0"))
+	require.Equal(t, "openserverless-cli_0.2.0_checksums.txt", checksums("0.2.0"))
+	require.Equal(t, "https://example.com/releases/v0.2.0/openserverless-cli_0.2.0_checksums.txt", u.releaseURL("v0.2.0", checksums("0.2.0")))
+}
+
+func TestSelfUpdate(t *testing.T) {
+	u, _, _ := setupSelfUpdate(t)
+
+	out := captureStdout(t, func() {
+		require.NoError(t, u.update("v0.2.0", false))
+	})
+	require.Contains(t, out, "ops updated from 0.1.0 to 0.2.0")
+	require.Equal(t, opsScript("0.2.0"), readOps(t, u.exe))
+	require.Equal(t, opsScript("0.1.0"), readOps(t, u.exe+".old"))
+	entries, err := os.ReadDir(filepath.Dir(u.exe))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	require.NoError(t, u.rollback())
+	require.Equal(t, opsScript("0.1.0"), readOps(t, u.exe))
+	require.ErrorContains(t, u.rollback(), "no previous ops")
+
+	out = captureStdout(t, func() {
+		require.NoError(t, u.update("0.1.0", false))
+	})
+	require.Equal(t, "ops 0.1.0 is already installed\n", out)
+}
+
+func TestSelfUpdateInsecure(t *testing.T) {
+	u, _, _ := setupSelfUpdate(t)
+	u.keys, u.insecure = nil, true
+	captureStdout(t, func() {
+		require.NoError(t, u.update("0.2.0", false))
+	})
+	require.Equal(t, opsScript("0.2.0"), readOps(t, u.exe))
+}
+
+func TestSelfUpdateDowngrade(t *testing.T) {
+	u, _, _ := setupSelfUpdate(t)
+	require.ErrorContains(t, u.update("0.0.9", false), "add --downgrade")
+	require.Equal(t, opsScript("0.1.0"), readOps(t, u.exe))
+
+	captureStdout(t, func() {
+		require.NoError(t, u.update("0.0.9", true))
+	})
+	require.Equal(t, opsScript("0.0.9"), readOps(t, u.exe))
+}
+
+func TestSelfUpdateVerification(t *testing.T) {
+	u, files, private := setupSelfUpdate(t)
+	artifact := "/releases/v0.2.0/" + u.artifact("0.2.0")
+	sums := "/releases/v0.2.0/" + checksums("0.2.0")
+
+	// a checksum signed by an untrusted key
+	_, other, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	files[sums+".sig"] = []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(other, files[sums])))
+	require.ErrorContains(t, u.update("0.2.0", false), "does not match any trusted key")
+	delete(files, sums+".sig")
+	require.ErrorContains(t, u.update("0.2.0", false), "not signed")
+
+	// without trusted keys the signature cannot be checked
+	keys := u.keys
+	u.keys = nil
+	require.ErrorContains(t, u.update("0.2.0", false), "add --insecure")
+	u.keys = keys
+
+	// a tampered archive
+	files[sums+".sig"] = []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(private, files[sums])))
+	files[artifact] = releaseArchive(t, opsScript("0.2.0")+"# tampered\n")
+	require.ErrorContains(t, u.update("0.2.0", false), "checksum mismatch")
+
+	// a binary reporting another version is not swapped in
+	archive := releaseArchive(t, opsScript("0.3.0"))
+	files[artifact] = archive
+	files[sums] = []byte(sha256Hex(archive) + "  " + u.artifact("0.2.0") + "\n")
+	files[sums+".sig"] = []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(private, files[sums])))
+	require.ErrorContains(t, u.update("0.2.0", false), `reports version "0.3.0"`)
+	archive = releaseArchive(t, opsScript("0.2.0.1"))
+	files[artifact] = archive
+	files[sums] = []byte(sha256Hex(archive) + "  " + u.artifact("0.2.0") + "\n")
+	files[sums+".sig"] = []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(private, files[sums])))
+	require.ErrorContains(t, u.update("0.2.0", false), `reports version "0.2.0.1"`)
+
+	require.ErrorContains(t, u.update("0.4.0", false), "404")
+	require.Equal(t, opsScript("0.1.0"), readOps(t, u.exe))
+	require.NoFileExists(t, u.exe+".old")
+	entries, err := os.ReadDir(filepath.Dir(u.exe))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestAutoCLIUpdateNeedsTrustedKeys(t *testing.T) {
+	t.Setenv(selfUpdatePinEnv, "")
+	t.Setenv(selfUpdateKeysEnv, "")
+	t.Setenv(selfUpdateURLEnv, "http://unreachable.invalid")
+	out := captureStdout(t, func() {
+		require.NoError(t, autoCLIUpdate("0.2.0"))
+	})
+	require.Contains(t, out, "run 'ops -selfupdate --insecure'")
+}
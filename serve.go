@@ -18,14 +18,21 @@
 package openserverless
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
-	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/pkg/browser"
 )
@@ -33,7 +40,8 @@ import (
 func Serve(olarisDir string, args []string) error {
 	flag := flag.NewFlagSet("serve", flag.ExitOnError)
 	flag.Usage = func() {
-		fmt.Println(`Serve a local directory on http://localhost:9768. You can change port with the OPS_PORT environment variable.
+		fmt.Println(`Serve a local directory on http://localhost:9768. You can change port with the OPS_PORT environment variable;
+when the port is busy a free one is used.
 
 Usage:
   ops -serve [options] <dir>
@@ -41,7 +49,21 @@ Usage:
 Options:
   -h, --help Print help message
   --no-open Do not open browser automatically
+  --bind <address> Listen only on <address> (e.g. 127.0.0.1) instead of all interfaces
+  --https Serve over HTTPS with a self-signed certificate
+  --cert <file> --key <file> Serve over HTTPS with the given certificate and key
+  --spa Serve index.html for unknown page navigations (Accept: text/html) instead of proxying them
+  --access-log Log every request with its status and duration
   --proxy <proxy> Use proxy server
+  --route <prefix>=<upstream> Proxy paths under <prefix> to <upstream> (repeatable)
+  --routes <file> Load routing rules from a YAML file (auth: true routes add the login key only to same-origin requests from this machine)
+  --actions <dir> Run the web actions found in <dir> locally instead of proxying them
+  --runtime <ext>=<command> With --actions, run <ext> sources with <command> (repeatable)
+  --record <dir> Save the proxied requests and responses in <dir>, with auth headers redacted
+  --replay <dir> Answer proxied requests from the recordings in <dir> without contacting the upstream
+  --cors <origins> Allow cross-origin requests from the comma separated origins ("*" for any, without credentials)
+  --watch Reload the browser when files in <dir> change
+  --on-change <args> With --watch, run "ops <args>" (e.g. a build) before reloading
 		`)
 	}
 	// Define command line flags
@@ -49,11 +71,41 @@ Options:
 	var helpFlag bool
 	var noBrowserFlag bool
 	var proxyFlag string
+	var watchFlag bool
+	var onChangeFlag string
+	var routeFlag routeFlags
+	var routesFlag string
+	var corsFlag string
+	var actionsFlag string
+	var runtimeFlag runtimeFlags
+	var bindFlag string
+	var httpsFlag bool
+	var certFlag string
+	var keyFlag string
+	var spaFlag bool
+	var accessLogFlag bool
+	var recordFlag string
+	var replayFlag string
 
 	flag.BoolVar(&helpFlag, "h", false, "Print help message")
 	flag.BoolVar(&helpFlag, "help", false, "Print help message")
 	flag.BoolVar(&noBrowserFlag, "no-open", false, "Do not open browser")
+	flag.StringVar(&bindFlag, "bind", "", "Listen address")
+	flag.BoolVar(&httpsFlag, "https", false, "Serve over HTTPS")
+	flag.StringVar(&certFlag, "cert", "", "TLS certificate file")
+	flag.StringVar(&keyFlag, "key", "", "TLS key file")
+	flag.BoolVar(&spaFlag, "spa", false, "Single page application mode")
+	flag.BoolVar(&accessLogFlag, "access-log", false, "Log requests")
+	flag.StringVar(&recordFlag, "record", "", "Record proxied traffic")
+	flag.StringVar(&replayFlag, "replay", "", "Replay recorded traffic")
 	flag.StringVar(&proxyFlag, "proxy", "", "Use proxy server")
+	flag.Var(&routeFlag, "route", "Proxy a path prefix to an upstream")
+	flag.StringVar(&routesFlag, "routes", "", "Routing rules file")
+	flag.StringVar(&corsFlag, "cors", "", "Allowed CORS origins")
+	flag.StringVar(&actionsFlag, "actions", "", "Local web actions directory")
+	flag.Var(&runtimeFlag, "runtime", "Command running an action extension")
+	flag.BoolVar(&watchFlag, "watch", false, "Reload the browser on changes")
+	flag.StringVar(&onChangeFlag, "on-change", "", "ops arguments to run on changes")
 
 	// Parse command line flags
 	os.Args = args
@@ -68,19 +120,56 @@ Options:
 		return nil
 	}
 
-	webDir := flag.Arg(0)
+	if onChangeFlag != "" && !watchFlag {
+		return fmt.Errorf("--on-change requires --watch")
+	}
+	if len(runtimeFlag) > 0 && actionsFlag == "" {
+		return fmt.Errorf("--runtime requires --actions")
+	}
+	if recordFlag != "" && replayFlag != "" {
+		return fmt.Errorf("--record and --replay cannot be used together")
+	}
 
-	// run ops server and open browser
-	port := getOpsPort()
-	webDirPath := joinpath(os.Getenv("OPS_PWD"), webDir)
-	log.Println("Serving directory: " + webDirPath)
+	var transport http.RoundTripper
+	if recordFlag != "" {
+		if transport, err = newRecordingTransport(servePath(recordFlag)); err != nil {
+			return err
+		}
+	}
+	if replayFlag != "" {
+		if transport, err = newReplayTransport(servePath(replayFlag)); err != nil {
+			return err
+		}
+	}
 
-	if !noBrowserFlag {
-		if err := browser.OpenURL("http://localhost:" + port); err != nil {
+	routes := &serveRoutes{}
+	if routesFlag != "" {
+		routes, err = loadServeRoutes(servePath(routesFlag))
+		if err != nil {
+			return err
+		}
+	}
+	routes.Routes = append(routes.Routes, routeFlag...)
+	routes.transport = transport
+	if corsFlag != "" {
+		routes.CORS = append(routes.CORS, strings.Split(corsFlag, ",")...)
+	}
+	if err := routes.prepare(); err != nil {
+		return err
+	}
+
+	var tlsConfig *tls.Config
+	if httpsFlag || certFlag != "" || keyFlag != "" {
+		tlsConfig, err = serveTLSConfig(certFlag, keyFlag, bindFlag)
+		if err != nil {
 			return err
 		}
 	}
 
+	webDir := flag.Arg(0)
+	webDirPath := joinpath(os.Getenv("OPS_PWD"), webDir)
+	log.Println("Serving directory: " + webDirPath)
+
 	localHandler := webFileServerHandler(webDirPath)
 
 	var proxy *httputil.ReverseProxy = nil
@@ -90,6 +179,20 @@ Options:
 			return err
 		}
 		proxy = httputil.NewSingleHostReverseProxy(remoteUrl)
+		proxy.Transport = transport
+	} else if replayFlag != "" {
+		// replaying needs no upstream
+		proxy = &httputil.ReverseProxy{Rewrite: func(*httputil.ProxyRequest) {}, Transport: transport}
+	}
+
+	// the SPA fallback gets the reload script too when watching
+	var reload *liveReload
+	var spaHandler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join(webDirPath, "index.html"))
+	})
+	if watchFlag {
+		reload = newLiveReload()
+		spaHandler = reload.index(webDirPath)
 	}
 
 	customHandler := func(w http.ResponseWriter, r *http.Request) {
@@ -102,6 +205,11 @@ Options:
 			return
 		}
 
+		if spaFlag && isSPARoute(r) {
+			spaHandler.ServeHTTP(w, r)
+			return
+		}
+
 		// File not found locally, proxy the request to the remote server
 		log.Printf("not found locally %s\n", r.URL.Path)
 
@@ -111,29 +219,62 @@ Options:
 		}
 	}
 
-	handler := http.HandlerFunc(customHandler)
+	var handler http.Handler = http.HandlerFunc(customHandler)
+	if len(routes.Routes) > 0 || len(routes.CORS) > 0 {
+		handler = routes.handler(handler)
+	}
+	if actionsFlag != "" {
+		actionsDir := servePath(actionsFlag)
+		log.Println("Serving web actions from: " + actionsDir)
+		handler = newLocalActions(actionsDir, runtimeFlag).handler(handler)
+	}
 
-	if checkPortAvailable(port) {
-		log.Println("OpenServerless config server started at http://localhost:" + port)
-		addr := fmt.Sprintf(":%s", port)
-		return http.ListenAndServe(addr, handler)
-	} else {
-		log.Println("OpenServerless config server failed to start. Port already in use?")
-		return nil
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if reload != nil {
+		handler = reload.inject(webDirPath, handler)
+		log.Println("Watching for changes: " + webDirPath)
+		go reload.watch(webDirPath, 500*time.Millisecond, split(onChangeFlag), ctx.Done())
+	}
+	if accessLogFlag {
+		handler = accessLog(handler)
+	}
+
+	ln, err := listenServe(bindFlag, getOpsPort())
+	if err != nil {
+		return err
+	}
+	address := serveURL(ln, tlsConfig != nil)
+	log.Println("OpenServerless config server started at " + address)
+
+	if !noBrowserFlag {
+		if err := browser.OpenURL(address); err != nil {
+			//nolint:errcheck
+			ln.Close()
+			return err
+		}
+	}
+
+	server := &http.Server{Handler: handler, TLSConfig: tlsConfig}
+	if reload != nil {
+		server.RegisterOnShutdown(reload.close)
+	}
+	if err := runServer(ctx, server, ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
 	}
+	return nil
+}
+
+// servePath resolves a path given on the command line against OPS_PWD.
+func servePath(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return joinpath(os.Getenv("OPS_PWD"), name)
 }
 
 // Handler to serve the olaris/web directory
 func webFileServerHandler(webDir string) http.Handler {
 	return http.FileServer(http.Dir(webDir))
 }
-
-func checkPortAvailable(port string) bool {
-	ln, err := net.Listen("tcp", ":"+port)
-	if err != nil {
-		return false
-	}
-	//nolint:errcheck
-	ln.Close()
-	return true
-}
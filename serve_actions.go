@@ -0,0 +1,341 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const webActionsPrefix = "/api/v1/web/"
+
+// localActionTimeout matches the default OpenWhisk action time limit.
+var localActionTimeout = 60 * time.Second
+
+// The launchers load an action source, call its main with the JSON
+// parameters read from stdin and print the result as the last stdout line.
+const nodeActionLauncher = `const fs=require("fs"),path=require("path"),Module=require("module");
+const file=path.resolve(process.argv[1]);let mod;
+if(fs.statSync(file).isDirectory()){mod=require(file);}else{
+const m=new Module(file,module);m.filename=file;m.paths=Module._nodeModulePaths(path.dirname(file));
+m._compile(fs.readFileSync(file,"utf8")+"\n;if(typeof main===\"function\"&&!module.exports.main)module.exports.main=main;",file);mod=m.exports;}
+const main=typeof mod==="function"?mod:mod.main;
+let input="";process.stdin.on("data",d=>input+=d).on("end",async()=>{
+try{const res=await main(JSON.parse(input||"{}"));process.stdout.write("\n"+JSON.stringify(res===undefined?{}:res)+"\n");}
+catch(e){console.error(e);process.exit(1);}});`
+
+const pythonActionLauncher = `import json, runpy, sys
+ns = runpy.run_path(sys.argv[1])
+res = ns["main"](json.load(sys.stdin))
+print()
+print(json.dumps({} if res is None else res))`
+
+// defaultActionRuntimes maps a source extension to the command running it.
+// Custom runtimes get the action path as last argument and follow the same
+// stdin/stdout contract as the launchers.
+func defaultActionRuntimes() map[string][]string {
+	return map[string][]string{
+		".js": {"node", "-e", nodeActionLauncher},
+		".py": {"python3", "-c", pythonActionLauncher},
+	}
+}
+
+// runtimeFlags collects repeated --runtime EXT=COMMAND flags.
+type runtimeFlags map[string][]string
+
+func (rf *runtimeFlags) String() string {
+	return fmt.Sprintf("%v", *rf)
+}
+
+func (rf *runtimeFlags) Set(value string) error {
+	ext, command, ok := strings.Cut(value, "=")
+	if !ok || ext == "" || strings.TrimSpace(command) == "" {
+		return fmt.Errorf("invalid runtime %q, expected EXT=COMMAND", value)
+	}
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	if *rf == nil {
+		*rf = runtimeFlags{}
+	}
+	(*rf)[ext] = split(command)
+	return nil
+}
+
+// localActions runs the web actions found in dir, laid out as
+// <pkg>/<action>.<ext>, <pkg>/<action>/ or <action>.<ext> for the default
+// package.
+type localActions struct {
+	dir      string
+	runtimes map[string][]string
+}
+
+func newLocalActions(dir string, runtimes map[string][]string) *localActions {
+	all := defaultActionRuntimes()
+	for ext, command := range runtimes {
+		all[ext] = command
+	}
+	return &localActions{dir: dir, runtimes: all}
+}
+
+// find returns the source and runtime extension of pkg/action, or "" when
+// the action is not available locally.
+func (la *localActions) find(pkg, action string) (string, string) {
+	bases := []string{filepath.Join(la.dir, pkg, action)}
+	if pkg == "default" {
+		bases = append([]string{filepath.Join(la.dir, action)}, bases...)
+	}
+	for _, base := range bases {
+		if info, err := os.Stat(base); err == nil && info.IsDir() {
+			for _, entry := range []struct{ file, ext string }{
+				{"package.json", ".js"}, {"index.js", ".js"}, {"__main__.py", ".py"},
+			} {
+				if _, err := os.Stat(filepath.Join(base, entry.file)); err == nil {
+					return base, entry.ext
+				}
+			}
+			continue
+		}
+		exts := make([]string, 0, len(la.runtimes))
+		for ext := range la.runtimes {
+			exts = append(exts, ext)
+		}
+		sort.Strings(exts)
+		for _, ext := range exts {
+			if info, err := os.Stat(base + ext); err == nil && !info.IsDir() {
+				return base + ext, ext
+			}
+		}
+	}
+	return "", ""
+}
+
+// parseWebActionPath splits /api/v1/web/<ns>/<pkg>/<action>[.ext][/path].
+func parseWebActionPath(path string) (pkg, action, ext, rest string, ok bool) {
+	if !strings.HasPrefix(path, webActionsPrefix) {
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(path, webActionsPrefix), "/", 4)
+	if len(parts) < 3 {
+		return
+	}
+	pkg, action = parts[1], parts[2]
+	for _, known := range []string{".http", ".json", ".html", ".text"} {
+		if strings.HasSuffix(action, known) {
+			action, ext = strings.TrimSuffix(action, known), known
+			break
+		}
+	}
+	if pkg == "" || action == "" || strings.HasPrefix(pkg, ".") || strings.HasPrefix(action, ".") {
+		return
+	}
+	if len(parts) == 4 {
+		rest = "/" + parts[3]
+	}
+	return pkg, action, ext, rest, true
+}
+
+// handler runs the web actions available in the actions directory and
+// leaves every other request to next.
+func (la *localActions) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pkg, action, ext, rest, ok := parseWebActionPath(r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		source, runtime := la.find(pkg, action)
+		if source == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		params, err := webActionParams(r, rest)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Running local action %s/%s (%s)\n", pkg, action, source)
+		result, err := la.run(r.Context(), source, runtime, params)
+		if err != nil {
+			log.Printf("local action %s/%s failed: %v\n", pkg, action, err)
+			writeJSON(w, http.StatusBadGateway, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		writeWebActionResult(w, ext, result)
+	})
+}
+
+func (la *localActions) run(ctx context.Context, source, runtime string, params map[string]interface{}) (map[string]interface{}, error) {
+	input, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, localActionTimeout)
+	defer cancel()
+
+	command := la.runtimes[runtime]
+	args := append(append([]string{}, command[1:]...), source)
+	cmd := exec.CommandContext(ctx, command[0], args...)
+	cmd.Dir = filepath.Dir(source)
+	cmd.Stdin = bytes.NewReader(input)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("action timed out after %s", localActionTimeout)
+		}
+		return nil, err
+	}
+
+	// everything before the last line is the action log
+	output := strings.TrimRight(stdout.String(), "\n")
+	logs, last := "", output
+	if index := strings.LastIndex(output, "\n"); index >= 0 {
+		logs, last = output[:index], output[index+1:]
+	}
+	if logs = strings.TrimSpace(logs); logs != "" {
+		fmt.Fprintln(os.Stderr, logs)
+	}
+	result := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(last), &result); err != nil {
+		return nil, fmt.Errorf("the action did not return a JSON object: %q", last)
+	}
+	return result, nil
+}
+
+// webActionParams builds the action parameters like the OpenWhisk
+// controller: query and body parameters plus the __ow_ request fields.
+func webActionParams(r *http.Request, rest string) (map[string]interface{}, error) {
+	params := map[string]interface{}{}
+	for key, values := range r.URL.Query() {
+		params[key] = values[0]
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > 0 {
+		contentType := r.Header.Get("Content-Type")
+		switch {
+		case strings.HasPrefix(contentType, "application/json"):
+			var fields map[string]interface{}
+			if err := json.Unmarshal(body, &fields); err != nil {
+				return nil, fmt.Errorf("invalid JSON body: %w", err)
+			}
+			for key, value := range fields {
+				params[key] = value
+			}
+		case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+			form, err := url.ParseQuery(string(body))
+			if err != nil {
+				return nil, err
+			}
+			for key, values := range form {
+				params[key] = values[0]
+			}
+		default:
+			params["__ow_body"] = string(body)
+		}
+	}
+	headers := map[string]interface{}{}
+	for name := range r.Header {
+		headers[strings.ToLower(name)] = r.Header.Get(name)
+	}
+	params["__ow_method"] = strings.ToLower(r.Method)
+	params["__ow_headers"] = headers
+	params["__ow_path"] = rest
+	return params, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	//nolint:errcheck
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeWebActionResult turns an action result into the HTTP response for
+// the requested extension.
+func writeWebActionResult(w http.ResponseWriter, ext string, result map[string]interface{}) {
+	if errValue, ok := result["error"]; ok {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": errValue})
+		return
+	}
+	switch ext {
+	case ".json":
+		writeJSON(w, http.StatusOK, result)
+		return
+	case ".html", ".text":
+		field := strings.TrimPrefix(ext, ".")
+		text, ok := result[field].(string)
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "the action did not return a " + field + " field"})
+			return
+		}
+		w.Header().Set("Content-Type", map[string]string{".html": "text/html; charset=utf-8", ".text": "text/plain; charset=utf-8"}[ext])
+		_, _ = io.WriteString(w, text)
+		return
+	}
+
+	_, hasBody := result["body"]
+	_, hasHeaders := result["headers"]
+	_, hasStatus := result["statusCode"]
+	if !hasBody && !hasHeaders && !hasStatus {
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+	if headers, ok := result["headers"].(map[string]interface{}); ok {
+		for name, value := range headers {
+			w.Header().Set(name, fmt.Sprint(value))
+		}
+	}
+	status := http.StatusOK
+	if !hasBody {
+		status = http.StatusNoContent
+	}
+	if code, ok := result["statusCode"].(float64); ok {
+		status = int(code)
+	}
+	switch body := result["body"].(type) {
+	case nil:
+		w.WriteHeader(status)
+	case string:
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, body)
+	default:
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.WriteHeader(status)
+		//nolint:errcheck
+		json.NewEncoder(w).Encode(body)
+	}
+}
@@ -0,0 +1,151 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeAction(t *testing.T, dir, name, content string) {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+	require.NoError(t, os.WriteFile(file, []byte(content), 0644))
+}
+
+func startLocalActions(t *testing.T, dir string, runtimes map[string][]string) *httptest.Server {
+	t.Helper()
+	proxy := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "proxied %s", r.URL.Path)
+	})
+	server := httptest.NewServer(newLocalActions(dir, runtimes).handler(proxy))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func doRequest(t *testing.T, method, url, contentType, body string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(data)
+}
+
+func TestParseWebActionPath(t *testing.T) {
+	pkg, action, ext, rest, ok := parseWebActionPath("/api/v1/web/ns/hello/world.json/a/b")
+	require.True(t, ok)
+	require.Equal(t, []string{"hello", "world", ".json", "/a/b"}, []string{pkg, action, ext, rest})
+
+	_, _, _, _, ok = parseWebActionPath("/api/v1/web/ns/hello")
+	require.False(t, ok)
+	_, _, _, _, ok = parseWebActionPath("/api/v1/web/ns/../secret")
+	require.False(t, ok)
+	_, _, _, _, ok = parseWebActionPath("/api/v1/namespaces/ns/actions/x")
+	require.False(t, ok)
+}
+
+func TestLocalActionsWithCustomRuntime(t *testing.T) {
+	dir := t.TempDir()
+	// the last output line is the result, the rest is the action log
+	writeAction(t, dir, "hello/echo.sh", `read -r args
+echo "log line"
+printf '{"body":%s,"statusCode":201,"headers":{"X-Local":"yes"}}\n' "$args"
+`)
+	writeAction(t, dir, "hello/fail.sh", `echo '{"error":"bad name"}'`)
+	writeAction(t, dir, "top.sh", `echo '{"message":"default package"}'`)
+	server := startLocalActions(t, dir, map[string][]string{".sh": {"sh"}})
+
+	resp, body := doRequest(t, http.MethodPost, server.URL+"/api/v1/web/ns/hello/echo/sub?name=Mike", "application/json", `{"n":1}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, "yes", resp.Header.Get("X-Local"))
+	require.Contains(t, body, `"name":"Mike"`)
+	require.Contains(t, body, `"n":1`)
+	require.Contains(t, body, `"__ow_method":"post"`)
+	require.Contains(t, body, `"__ow_path":"/sub"`)
+
+	resp, body = doRequest(t, http.MethodGet, server.URL+"/api/v1/web/ns/hello/fail", "", "")
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.JSONEq(t, `{"error":"bad name"}`, body)
+
+	resp, body = doRequest(t, http.MethodGet, server.URL+"/api/v1/web/ns/default/top", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.JSONEq(t, `{"message":"default package"}`, body)
+
+	_, body = doRequest(t, http.MethodGet, server.URL+"/api/v1/web/ns/hello/missing", "", "")
+	require.Equal(t, "proxied /api/v1/web/ns/hello/missing", body)
+	_, body = doRequest(t, http.MethodGet, server.URL+"/index.html", "", "")
+	require.Equal(t, "proxied /index.html", body)
+}
+
+func TestLocalActionsNodeRuntime(t *testing.T) {
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not available")
+	}
+	dir := t.TempDir()
+	writeAction(t, dir, "hello/greet.js", `function main(args) {
+  console.log("called");
+  return { html: "<h1>Hello " + (args.name || "world") + "</h1>" };
+}
+`)
+	server := startLocalActions(t, dir, nil)
+
+	resp, body := doRequest(t, http.MethodPost, server.URL+"/api/v1/web/ns/hello/greet.html", "application/x-www-form-urlencoded", "name=Ops")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	require.Equal(t, "<h1>Hello Ops</h1>", body)
+}
+
+func TestLocalActionsPythonRuntime(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not available")
+	}
+	dir := t.TempDir()
+	writeAction(t, dir, "hello/sum.py", `def main(args):
+    print("adding")
+    return {"body": {"sum": int(args["a"]) + int(args["b"])}}
+`)
+	server := startLocalActions(t, dir, nil)
+
+	resp, body := doRequest(t, http.MethodGet, server.URL+"/api/v1/web/ns/hello/sum?a=2&b=3", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.JSONEq(t, `{"sum":5}`, body)
+}
+
+func TestRuntimeFlags(t *testing.T) {
+	var flags runtimeFlags
+	require.NoError(t, flags.Set("ts=deno run -A"))
+	require.Equal(t, []string{"deno", "run", "-A"}, flags[".ts"])
+	require.Error(t, flags.Set(".ts="))
+}
@@ -0,0 +1,265 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// redactedHeaders are never written to a recording.
+var redactedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Require-Whisk-Auth",
+	"X-Api-Key",
+	"X-Auth-Token",
+}
+
+// recordedBody keeps text as is and anything else in base64.
+type recordedBody struct {
+	Data     string `json:"data"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+func newRecordedBody(data []byte) recordedBody {
+	if utf8.Valid(data) {
+		return recordedBody{Data: string(data)}
+	}
+	return recordedBody{Data: base64.StdEncoding.EncodeToString(data), Encoding: "base64"}
+}
+
+func (b recordedBody) bytes() ([]byte, error) {
+	if b.Encoding == "base64" {
+		return base64.StdEncoding.DecodeString(b.Data)
+	}
+	return []byte(b.Data), nil
+}
+
+type recordedRequest struct {
+	Method  string       `json:"method"`
+	URL     string       `json:"url"`
+	Headers http.Header  `json:"headers"`
+	Body    recordedBody `json:"body"`
+}
+
+type recordedResponse struct {
+	Status  int          `json:"status"`
+	Headers http.Header  `json:"headers"`
+	Body    recordedBody `json:"body"`
+}
+
+// recording is one proxied exchange, saved as a JSON file.
+type recording struct {
+	Request  recordedRequest  `json:"request"`
+	Response recordedResponse `json:"response"`
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// recordingRouteKey holds in the request context the prefix of the route
+// proxying it.
+type recordingRouteKey struct{}
+
+// withRecordingRoute marks r as proxied by the route with prefix.
+func withRecordingRoute(r *http.Request, prefix string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), recordingRouteKey{}, prefix))
+}
+
+// recordingFile names the recording of a request after its route, method,
+// path, sorted query and body. The upstream host is ignored so recordings
+// survive a change of cluster, while the route keeps apart the upstreams
+// receiving the same path.
+func recordingFile(dir string, r *http.Request, body []byte) string {
+	route, _ := r.Context().Value(recordingRouteKey{}).(string)
+	key := r.Method + " " + r.URL.Path + "?" + r.URL.Query().Encode()
+	if route != "" {
+		key = route + " " + key
+	}
+	sum := sha256.Sum256([]byte(key + "\n" + string(body)))
+	name := strings.Trim(unsafeFileChars.ReplaceAllString(r.URL.Path, "_"), "_")
+	if len(name) > 80 {
+		name = name[:80]
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s_%s_%s.json", r.Method, name, hex.EncodeToString(sum[:6])))
+}
+
+func redactHeaders(header http.Header) http.Header {
+	redacted := header.Clone()
+	for _, name := range redactedHeaders {
+		if redacted.Get(name) != "" {
+			redacted.Set(name, "<redacted>")
+		}
+	}
+	return redacted
+}
+
+func readRequestBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	//nolint:errcheck
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// recordingTransport forwards requests upstream and saves every exchange
+// in dir.
+type recordingTransport struct {
+	dir  string
+	next http.RoundTripper
+}
+
+func newRecordingTransport(dir string) (*recordingTransport, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &recordingTransport{dir: dir, next: http.DefaultTransport}, nil
+}
+
+func (rt *recordingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(r)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := rt.next.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	// streams and upgraded connections cannot be replayed
+	if resp.StatusCode == http.StatusSwitchingProtocols || strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return resp, nil
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	//nolint:errcheck
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	rec := recording{
+		Request: recordedRequest{
+			Method:  r.Method,
+			URL:     r.URL.RequestURI(),
+			Headers: redactHeaders(r.Header),
+			Body:    newRecordedBody(reqBody),
+		},
+		Response: recordedResponse{
+			Status:  resp.StatusCode,
+			Headers: redactHeaders(resp.Header),
+			Body:    newRecordedBody(respBody),
+		},
+	}
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(rec); err != nil {
+		return nil, err
+	}
+	file := recordingFile(rt.dir, r, reqBody)
+	if err := os.WriteFile(file, data.Bytes(), 0644); err != nil {
+		log.Printf("cannot save recording %s: %v\n", file, err)
+	} else {
+		log.Printf("Recorded %s %s\n", r.Method, r.URL.RequestURI())
+	}
+	return resp, nil
+}
+
+// replayTransport answers from the recordings in dir and never contacts
+// the upstream.
+type replayTransport struct {
+	dir string
+}
+
+func newReplayTransport(dir string) (*replayTransport, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &replayTransport{dir: dir}, nil
+}
+
+func (rt *replayTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(r)
+	if err != nil {
+		return nil, err
+	}
+	file := recordingFile(rt.dir, r, reqBody)
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		log.Printf("No recording for %s %s\n", r.Method, r.URL.RequestURI())
+		message := fmt.Sprintf(`{"error":"no recording for %s %s"}`, r.Method, r.URL.Path)
+		return &http.Response{
+			StatusCode:    http.StatusNotFound,
+			Header:        http.Header{"Content-Type": {"application/json"}},
+			Body:          io.NopCloser(strings.NewReader(message)),
+			ContentLength: int64(len(message)),
+			Request:       r,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("invalid recording %s: %w", file, err)
+	}
+	body, err := rec.Response.Body.bytes()
+	if err != nil {
+		return nil, fmt.Errorf("invalid recording %s: %w", file, err)
+	}
+	header := rec.Response.Headers.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Del("Set-Cookie")
+	header.Set("Content-Length", fmt.Sprint(len(body)))
+	log.Printf("Replayed %s %s\n", r.Method, r.URL.RequestURI())
+	return &http.Response{
+		StatusCode:    rec.Response.Status,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}, nil
+}
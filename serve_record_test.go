@@ -0,0 +1,150 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func proxyThrough(t *testing.T, upstream string, transport http.RoundTripper) *httptest.Server {
+	t.Helper()
+	target, err := url.Parse(upstream)
+	require.NoError(t, err)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = transport
+	server := httptest.NewServer(proxy)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	calls := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Header().Set("X-Upstream", "yes")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.RequestURI(), body)
+	}))
+	defer upstream.Close()
+	dir := filepath.Join(t.TempDir(), "fixtures")
+
+	recorder, err := newRecordingTransport(dir)
+	require.NoError(t, err)
+	recording := proxyThrough(t, upstream.URL, recorder)
+
+	req, _ := http.NewRequest(http.MethodPost, recording.URL+"/api/v1/web/ns/pkg/add?b=2&a=1", strings.NewReader(`{"x":1}`))
+	req.Header.Set("Authorization", "Basic dXVpZDprZXk=")
+	resp, body := doRawRequest(t, req)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, `POST /api/v1/web/ns/pkg/add?b=2&a=1 {"x":1}`, body)
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	saved, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	require.NoError(t, err)
+	require.NotContains(t, string(saved), "dXVpZDprZXk=")
+	require.NotContains(t, string(saved), "session=secret")
+	require.Contains(t, string(saved), "<redacted>")
+
+	upstream.Close()
+	replayer, err := newReplayTransport(dir)
+	require.NoError(t, err)
+	replaying := proxyThrough(t, "http://unreachable.invalid", replayer)
+
+	// the query order does not matter
+	req, _ = http.NewRequest(http.MethodPost, replaying.URL+"/api/v1/web/ns/pkg/add?a=1&b=2", strings.NewReader(`{"x":1}`))
+	resp, body = doRawRequest(t, req)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, "yes", resp.Header.Get("X-Upstream"))
+	require.Empty(t, resp.Header.Get("Set-Cookie"))
+	require.Equal(t, `POST /api/v1/web/ns/pkg/add?b=2&a=1 {"x":1}`, body)
+	require.Equal(t, 1, calls)
+
+	req, _ = http.NewRequest(http.MethodPost, replaying.URL+"/api/v1/web/ns/pkg/add?a=1&b=2", strings.NewReader(`{"x":2}`))
+	resp, body = doRawRequest(t, req)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.Contains(t, body, "no recording for POST /api/v1/web/ns/pkg/add")
+}
+
+func TestRecordingsAreKeptPerRoute(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := newRecordingTransport(dir)
+	require.NoError(t, err)
+	newRoutes := func(transport http.RoundTripper, api, mock string) *httptest.Server {
+		routes := &serveRoutes{transport: transport, Routes: []*serveRoute{
+			{Prefix: "/api/", Upstream: api, Strip: true},
+			{Prefix: "/mock/", Upstream: mock, Strip: true},
+		}}
+		require.NoError(t, routes.prepare())
+		server := httptest.NewServer(routes.handler(http.NotFoundHandler()))
+		t.Cleanup(server.Close)
+		return server
+	}
+	api := echoUpstream(t, "api")
+	mock := echoUpstream(t, "mock")
+	recording := newRoutes(recorder, api.URL, mock.URL)
+	require.Equal(t, "api /users  ", getBody(t, recording.URL+"/api/users"))
+	require.Equal(t, "mock /users  ", getBody(t, recording.URL+"/mock/users"))
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+
+	replayer, err := newReplayTransport(dir)
+	require.NoError(t, err)
+	replaying := newRoutes(replayer, "http://unreachable.invalid", "http://unreachable.invalid")
+	require.Equal(t, "api /users  ", getBody(t, replaying.URL+"/api/users"))
+	require.Equal(t, "mock /users  ", getBody(t, replaying.URL+"/mock/users"))
+}
+
+func TestRecordedBodyKeepsBinaryData(t *testing.T) {
+	data := []byte{0x1f, 0x8b, 0xff, 0x00}
+	body := newRecordedBody(data)
+	require.Equal(t, "base64", body.Encoding)
+	decoded, err := body.bytes()
+	require.NoError(t, err)
+	require.Equal(t, data, decoded)
+}
+
+func TestReplayRequiresDirectory(t *testing.T) {
+	_, err := newReplayTransport(filepath.Join(t.TempDir(), "missing"))
+	require.Error(t, err)
+}
+
+func doRawRequest(t *testing.T, req *http.Request) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(data)
+}
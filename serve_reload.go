@@ -0,0 +1,260 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// liveReloadPath is the Server-Sent Events endpoint the injected script
+// listens to.
+const liveReloadPath = "/__ops/livereload"
+
+const liveReloadScript = `<script>(function(){var es=new EventSource("` + liveReloadPath + `");es.onmessage=function(e){if(e.data==="reload"){location.reload();}};})();</script>`
+
+// runServeTask runs `ops <args>` when a watched file changes.
+var runServeTask = func(args []string) error {
+	me, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(me, args...)
+	cmd.Dir = os.Getenv("OPS_PWD")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// liveReload pushes reload events to the connected browsers.
+type liveReload struct {
+	mu      sync.Mutex
+	clients map[chan string]struct{}
+	// done ends the event streams, which would otherwise hold the shutdown
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newLiveReload() *liveReload {
+	return &liveReload{clients: map[chan string]struct{}{}, done: make(chan struct{})}
+}
+
+// close ends the event streams of the connected browsers.
+func (lr *liveReload) close() {
+	lr.closeOnce.Do(func() { close(lr.done) })
+}
+
+func (lr *liveReload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	events := make(chan string, 1)
+	lr.mu.Lock()
+	lr.clients[events] = struct{}{}
+	lr.mu.Unlock()
+	defer func() {
+		lr.mu.Lock()
+		delete(lr.clients, events)
+		lr.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case event := <-events:
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-lr.done:
+			return
+		}
+	}
+}
+
+func (lr *liveReload) broadcast(event string) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	for client := range lr.clients {
+		select {
+		case client <- event:
+		default:
+			// a reload is already pending for this client
+		}
+	}
+}
+
+// inject serves the HTML pages of dir with the reload script added, and
+// leaves everything else to next.
+func (lr *liveReload) inject(dir string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == liveReloadPath {
+			lr.ServeHTTP(w, r)
+			return
+		}
+		name := path.Clean("/" + r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/") {
+			name = path.Join(name, "index.html")
+		}
+		ext := strings.ToLower(path.Ext(name))
+		if ext != ".html" && ext != ".htm" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		file, err := http.Dir(dir).Open(name)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil || info.IsDir() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		content, err := io.ReadAll(file)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.ServeContent(w, r, name, info.ModTime(), bytes.NewReader(injectLiveReloadScript(content)))
+	})
+}
+
+// index serves the index.html of dir with the reload script added, for the
+// client side routes of a single page application.
+func (lr *liveReload) index(dir string) http.Handler {
+	inject := lr.inject(dir, http.NotFoundHandler())
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.Clone(r.Context())
+		r.URL.Path, r.URL.RawPath = "/index.html", ""
+		inject.ServeHTTP(w, r)
+	})
+}
+
+func injectLiveReloadScript(html []byte) []byte {
+	lower := bytes.ToLower(html)
+	if index := bytes.LastIndex(lower, []byte("</body>")); index >= 0 {
+		injected := make([]byte, 0, len(html)+len(liveReloadScript))
+		injected = append(injected, html[:index]...)
+		injected = append(injected, liveReloadScript...)
+		return append(injected, html[index:]...)
+	}
+	return append(append([]byte{}, html...), liveReloadScript...)
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// scanWatchedDir records the files under dir, skipping hidden entries and
+// node_modules.
+func scanWatchedDir(dir string) map[string]fileStamp {
+	files := map[string]fileStamp{}
+	//nolint:errcheck
+	filepath.WalkDir(dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		base := entry.Name()
+		if name != dir && (strings.HasPrefix(base, ".") || base == "node_modules") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			files[name] = fileStamp{info.ModTime(), info.Size()}
+		}
+		return nil
+	})
+	return files
+}
+
+func changedFiles(before, after map[string]fileStamp) []string {
+	changed := make([]string, 0)
+	for name, stamp := range after {
+		if old, ok := before[name]; !ok || old != stamp {
+			changed = append(changed, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// watch polls dir until stop is closed. On changes it runs the optional ops
+// task and then tells the browsers to reload; a failed task skips the reload
+// so the page keeps showing the last good build.
+func (lr *liveReload) watch(dir string, interval time.Duration, task []string, stop <-chan struct{}) {
+	current := scanWatchedDir(dir)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		next := scanWatchedDir(dir)
+		changed := changedFiles(current, next)
+		current = next
+		if len(changed) == 0 {
+			continue
+		}
+		log.Printf("changed: %s\n", strings.Join(changed, ", "))
+		if len(task) > 0 {
+			log.Printf("running: ops %s\n", strings.Join(task, " "))
+			err := runServeTask(task)
+			// files written by the task itself must not trigger another run
+			current = scanWatchedDir(dir)
+			if err != nil {
+				log.Printf("ops %s failed: %v\n", strings.Join(task, " "), err)
+				continue
+			}
+		}
+		lr.broadcast("reload")
+	}
+}
@@ -0,0 +1,145 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLiveReloadInjectsScript(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html><body><h1>hi</h1></BODY></html>"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.js"), []byte("console.log(1)"), 0644))
+
+	reload := newLiveReload()
+	ts := httptest.NewServer(reload.inject(dir, webFileServerHandler(dir)))
+	defer ts.Close()
+
+	get := func(path string) string {
+		resp, err := http.Get(ts.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	require.Equal(t, "<html><body><h1>hi</h1>"+liveReloadScript+"</BODY></html>", get("/"))
+	require.Contains(t, get("/index.html"), liveReloadScript)
+	require.Equal(t, "console.log(1)", get("/app.js"))
+}
+
+func TestLiveReloadIndexForSPARoutes(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html><body>app</body></html>"), 0644))
+
+	server := httptest.NewServer(newLiveReload().index(dir))
+	defer server.Close()
+
+	body := getBody(t, server.URL+"/users/42")
+	require.Contains(t, body, "app")
+	require.Contains(t, body, liveReloadPath)
+}
+
+func TestLiveReloadWatchRunsTaskAndBroadcasts(t *testing.T) {
+	dir := t.TempDir()
+	page := filepath.Join(dir, "index.html")
+	require.NoError(t, os.WriteFile(page, []byte("<body>v1</body>"), 0644))
+
+	var mu sync.Mutex
+	var tasks [][]string
+	taskErr := errors.New("build failed")
+	oldRunner := runServeTask
+	runServeTask = func(args []string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		tasks = append(tasks, args)
+		// the build writes into the watched directory
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "bundle.js"), []byte(time.Now().String()), 0644))
+		if len(tasks) == 1 {
+			return taskErr
+		}
+		return nil
+	}
+	defer func() { runServeTask = oldRunner }()
+
+	reload := newLiveReload()
+	ts := httptest.NewServer(reload.inject(dir, webFileServerHandler(dir)))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + liveReloadPath)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	events := make(chan string, 4)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				events <- data
+			}
+		}
+	}()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go reload.watch(dir, 20*time.Millisecond, []string{"app", "build"}, stop)
+
+	// a failing build does not reload
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, os.WriteFile(page, []byte("<body>v2 broken</body>"), 0644))
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event %q", event)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	require.NoError(t, os.WriteFile(page, []byte("<body>v3 fixed</body>"), 0644))
+	select {
+	case event := <-events:
+		require.Equal(t, "reload", event)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no reload event")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// the files written by the build did not trigger more runs
+	require.Equal(t, [][]string{{"app", "build"}, {"app", "build"}}, tasks)
+}
+
+func Test_changedFiles(t *testing.T) {
+	now := time.Now()
+	before := map[string]fileStamp{"a": {now, 1}, "b": {now, 1}}
+	after := map[string]fileStamp{"a": {now, 1}, "b": {now, 2}, "c": {now, 1}}
+	changed := changedFiles(before, after)
+	require.ElementsMatch(t, []string{"b", "c"}, changed)
+	require.ElementsMatch(t, []string{"b", "c"}, changedFiles(after, before))
+}
@@ -0,0 +1,238 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// serveRoute forwards the requests under Prefix to Upstream.
+type serveRoute struct {
+	Prefix   string `yaml:"prefix"`
+	Upstream string `yaml:"upstream"`
+	// Strip removes Prefix from the path sent upstream.
+	Strip bool `yaml:"strip"`
+	// Auth sends the AUTH key of the current login as basic credentials,
+	// only on same-origin requests from this machine.
+	Auth bool `yaml:"auth"`
+	// Headers are added to the upstream request; values can refer to
+	// config and environment variables as $NAME or ${NAME}.
+	Headers map[string]string `yaml:"headers"`
+
+	proxy *httputil.ReverseProxy
+}
+
+// serveRoutes is the routing table of `ops -serve`, loaded from --routes
+// and extended with --route flags.
+type serveRoutes struct {
+	Routes []*serveRoute `yaml:"routes"`
+	// CORS lists the origins allowed to call the server, "*" for any.
+	CORS []string `yaml:"cors"`
+
+	// transport carries the proxied requests, nil for the default one.
+	transport http.RoundTripper
+}
+
+func loadServeRoutes(file string) (*serveRoutes, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	routes := &serveRoutes{}
+	if err := yaml.Unmarshal(data, routes); err != nil {
+		return nil, fmt.Errorf("invalid routes file %s: %w", file, err)
+	}
+	return routes, nil
+}
+
+// routeFlags collects repeated --route PREFIX=UPSTREAM flags.
+type routeFlags []*serveRoute
+
+func (rf *routeFlags) String() string {
+	values := make([]string, 0, len(*rf))
+	for _, route := range *rf {
+		values = append(values, route.Prefix+"="+route.Upstream)
+	}
+	return strings.Join(values, ",")
+}
+
+func (rf *routeFlags) Set(value string) error {
+	prefix, upstream, ok := strings.Cut(value, "=")
+	if !ok || prefix == "" || upstream == "" {
+		return fmt.Errorf("invalid route %q, expected PREFIX=UPSTREAM", value)
+	}
+	*rf = append(*rf, &serveRoute{Prefix: prefix, Upstream: upstream})
+	return nil
+}
+
+// prepare validates the routes, builds their proxies and sorts them so the
+// longest prefix is matched first.
+func (rs *serveRoutes) prepare() error {
+	for _, route := range rs.Routes {
+		if !strings.HasPrefix(route.Prefix, "/") {
+			return fmt.Errorf("route prefix %q must start with /", route.Prefix)
+		}
+		target, err := url.Parse(route.Upstream)
+		if err != nil || target.Scheme == "" || target.Host == "" {
+			return fmt.Errorf("route %s: invalid upstream %q", route.Prefix, route.Upstream)
+		}
+		if route.Auth && os.Getenv("AUTH") == "" {
+			return fmt.Errorf("route %s: no AUTH key found, please run ops -login", route.Prefix)
+		}
+		route.proxy = rs.newRouteProxy(route, target)
+	}
+	sort.SliceStable(rs.Routes, func(i, j int) bool {
+		return len(rs.Routes[i].Prefix) > len(rs.Routes[j].Prefix)
+	})
+	return nil
+}
+
+func (rs *serveRoutes) newRouteProxy(route *serveRoute, target *url.URL) *httputil.ReverseProxy {
+	proxy := &httputil.ReverseProxy{
+		Transport: rs.transport,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			if route.Strip {
+				stripped := strings.TrimPrefix(pr.In.URL.Path, strings.TrimSuffix(route.Prefix, "/"))
+				if !strings.HasPrefix(stripped, "/") {
+					stripped = "/" + stripped
+				}
+				pr.Out.URL.Path = stripped
+				pr.Out.URL.RawPath = ""
+			}
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			// the login key must not be spent on behalf of another site or
+			// another machine
+			if route.Auth && localRequest(pr.In) && !crossOrigin(pr.In) {
+				pr.Out.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(os.Getenv("AUTH"))))
+			}
+			for name, value := range route.Headers {
+				pr.Out.Header.Set(name, os.ExpandEnv(value))
+			}
+			pr.Out = withRecordingRoute(pr.Out, route.Prefix)
+		},
+	}
+	if len(rs.CORS) > 0 {
+		// the server answers CORS itself, upstream headers would be duplicated
+		proxy.ModifyResponse = func(resp *http.Response) error {
+			for name := range resp.Header {
+				if strings.HasPrefix(name, "Access-Control-") {
+					resp.Header.Del(name)
+				}
+			}
+			return nil
+		}
+	}
+	return proxy
+}
+
+// match returns the route for path, matching prefixes on whole segments.
+func (rs *serveRoutes) match(path string) *serveRoute {
+	for _, route := range rs.Routes {
+		prefix := strings.TrimSuffix(route.Prefix, "/")
+		if prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return route
+		}
+	}
+	return nil
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin for origin, "" when
+// it is not allowed. Only the origins listed explicitly are echoed back and
+// allowed credentials, "*" allows any origin without them.
+func (rs *serveRoutes) allowOrigin(origin string) (string, bool) {
+	wildcard := false
+	for _, allowed := range rs.CORS {
+		if allowed == "*" {
+			wildcard = true
+		} else if strings.EqualFold(allowed, origin) {
+			return origin, true
+		}
+	}
+	if wildcard {
+		return "*", false
+	}
+	return "", false
+}
+
+// crossOrigin tells if r comes from a page served by another origin.
+func crossOrigin(r *http.Request) bool {
+	// browsers send no Origin loading images and scripts, but Sec-Fetch-Site
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "none" {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || !strings.EqualFold(u.Host, r.Host)
+}
+
+// localRequest tells if r comes from this machine.
+func localRequest(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// handler proxies the routed paths and leaves the others to next. With CORS
+// origins configured it also answers preflight requests for every path.
+func (rs *serveRoutes) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" && len(rs.CORS) > 0 {
+			allowed, credentials := rs.allowOrigin(origin)
+			if allowed != "" {
+				w.Header().Set("Access-Control-Allow-Origin", allowed)
+				if credentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+					w.Header().Add("Vary", "Origin")
+				}
+			}
+			if allowed != "" && r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+				if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+					w.Header().Set("Access-Control-Allow-Headers", headers)
+				}
+				w.Header().Set("Access-Control-Max-Age", "600")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		if route := rs.match(r.URL.Path); route != nil {
+			log.Printf("Routing %s to %s\n", r.URL.Path, route.Upstream)
+			route.proxy.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
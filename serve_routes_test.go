@@ -0,0 +1,242 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// echoUpstream answers with the name, path and Authorization it received.
+func echoUpstream(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "upstream")
+		fmt.Fprintf(w, "%s %s %s %s", name, r.URL.Path, r.Header.Get("Authorization"), r.Header.Get("X-Env"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func getBody(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestServeRoutesMatchLongestPrefix(t *testing.T) {
+	t.Setenv("AUTH", "uuid:key")
+	t.Setenv("SERVE_TEST_VALUE", "from-env")
+	api := echoUpstream(t, "api")
+	mock := echoUpstream(t, "mock")
+
+	routes := &serveRoutes{Routes: []*serveRoute{
+		{Prefix: "/api", Upstream: api.URL},
+		{Prefix: "/api/v1/web", Upstream: api.URL, Auth: true, Headers: map[string]string{"X-Env": "${SERVE_TEST_VALUE}"}},
+		{Prefix: "/mock/", Upstream: mock.URL + "/base", Strip: true},
+	}}
+	require.NoError(t, routes.prepare())
+
+	local := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "local %s", r.URL.Path)
+	})
+	server := httptest.NewServer(routes.handler(local))
+	defer server.Close()
+
+	require.Equal(t, "api /api/v1/web/ns/hello Basic dXVpZDprZXk= from-env", getBody(t, server.URL+"/api/v1/web/ns/hello"))
+	require.Equal(t, "api /api/v1/namespaces  ", getBody(t, server.URL+"/api/v1/namespaces"))
+	require.Equal(t, "mock /base/users/1  ", getBody(t, server.URL+"/mock/users/1"))
+	require.Equal(t, "local /mockup", getBody(t, server.URL+"/mockup"))
+	require.Equal(t, "local /index.html", getBody(t, server.URL+"/index.html"))
+}
+
+func TestServeRoutesCORS(t *testing.T) {
+	api := echoUpstream(t, "api")
+	routes := &serveRoutes{
+		Routes: []*serveRoute{{Prefix: "/api", Upstream: api.URL}},
+		CORS:   []string{"http://localhost:5173"},
+	}
+	require.NoError(t, routes.prepare())
+	server := httptest.NewServer(routes.handler(http.NotFoundHandler()))
+	defer server.Close()
+
+	preflight, _ := http.NewRequest(http.MethodOptions, server.URL+"/api/x", nil)
+	preflight.Header.Set("Origin", "http://localhost:5173")
+	preflight.Header.Set("Access-Control-Request-Method", "POST")
+	preflight.Header.Set("Access-Control-Request-Headers", "content-type")
+	resp, err := http.DefaultClient.Do(preflight)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	require.Equal(t, "http://localhost:5173", resp.Header.Get("Access-Control-Allow-Origin"))
+	require.Equal(t, "content-type", resp.Header.Get("Access-Control-Allow-Headers"))
+
+	get, _ := http.NewRequest(http.MethodGet, server.URL+"/api/x", nil)
+	get.Header.Set("Origin", "http://localhost:5173")
+	resp, err = http.DefaultClient.Do(get)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, []string{"http://localhost:5173"}, resp.Header.Values("Access-Control-Allow-Origin"))
+	require.Equal(t, "true", resp.Header.Get("Access-Control-Allow-Credentials"))
+
+	get.Header.Set("Origin", "http://evil.example")
+	resp, err = http.DefaultClient.Do(get)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+}
+
+func TestServeRoutesCORSWildcard(t *testing.T) {
+	t.Setenv("AUTH", "uuid:key")
+	api := echoUpstream(t, "api")
+	routes := &serveRoutes{
+		Routes: []*serveRoute{{Prefix: "/api", Upstream: api.URL, Auth: true}},
+		CORS:   []string{"*"},
+	}
+	require.NoError(t, routes.prepare())
+	server := httptest.NewServer(routes.handler(http.NotFoundHandler()))
+	defer server.Close()
+
+	get, _ := http.NewRequest(http.MethodGet, server.URL+"/api/x", nil)
+	get.Header.Set("Origin", "http://evil.example")
+	resp, err := http.DefaultClient.Do(get)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, "*", resp.Header.Get("Access-Control-Allow-Origin"))
+	require.Empty(t, resp.Header.Get("Access-Control-Allow-Credentials"))
+	// the AUTH key is not added to a cross-origin request
+	require.Equal(t, "api /api/x  ", string(body))
+
+	get.Header.Set("Origin", server.URL)
+	resp, err = http.DefaultClient.Do(get)
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, "api /api/x Basic dXVpZDprZXk= ", string(body))
+}
+
+func TestServeRoutesAuthOnlyForLocalSameOrigin(t *testing.T) {
+	t.Setenv("AUTH", "uuid:key")
+	api := echoUpstream(t, "api")
+	routes := &serveRoutes{Routes: []*serveRoute{{Prefix: "/api", Upstream: api.URL, Auth: true}}}
+	require.NoError(t, routes.prepare())
+	handler := routes.handler(http.NotFoundHandler())
+
+	call := func(remote string, headers map[string]string) string {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/x", nil)
+		req.RemoteAddr = remote
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+	require.Equal(t, "api /api/x Basic dXVpZDprZXk= ", call("127.0.0.1:5000", nil))
+	require.Equal(t, "api /api/x Basic dXVpZDprZXk= ", call("[::1]:5000", map[string]string{"Sec-Fetch-Site": "same-origin"}))
+	// another machine on the network
+	require.Equal(t, "api /api/x  ", call("192.168.1.20:5000", nil))
+	// an image or a script of another site sends no Origin
+	require.Equal(t, "api /api/x  ", call("127.0.0.1:5000", map[string]string{"Sec-Fetch-Site": "cross-site"}))
+	require.Equal(t, "api /api/x  ", call("127.0.0.1:5000", map[string]string{"Sec-Fetch-Site": "same-site"}))
+}
+
+func TestServeRoutesWebsocketPassthrough(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		require.NoError(t, err)
+		defer conn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		_ = rw.Flush()
+		line, _ := rw.ReadString('\n')
+		_, _ = rw.WriteString("echo " + line)
+		_ = rw.Flush()
+	}))
+	defer upstream.Close()
+
+	routes := &serveRoutes{Routes: []*serveRoute{{Prefix: "/ws", Upstream: upstream.URL}}}
+	require.NoError(t, routes.prepare())
+	server := httptest.NewServer(routes.handler(http.NotFoundHandler()))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /ws/events HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	_, err = conn.Write([]byte("ping\n"))
+	require.NoError(t, err)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "echo ping\n", line)
+}
+
+func TestLoadServeRoutes(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "routes.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(`routes:
+  - prefix: /api/v1/web
+    upstream: https://apihost.example
+    auth: true
+  - prefix: /mock
+    upstream: http://localhost:3000
+    strip: true
+    headers:
+      X-Mock: "yes"
+cors: ["*"]
+`), 0644))
+
+	routes, err := loadServeRoutes(file)
+	require.NoError(t, err)
+	require.Len(t, routes.Routes, 2)
+	require.True(t, routes.Routes[0].Auth)
+	require.True(t, routes.Routes[1].Strip)
+	require.Equal(t, "yes", routes.Routes[1].Headers["X-Mock"])
+	require.Equal(t, []string{"*"}, routes.CORS)
+
+	t.Setenv("AUTH", "")
+	require.ErrorContains(t, routes.prepare(), "no AUTH key found")
+
+	var flags routeFlags
+	require.NoError(t, flags.Set("/mock=http://localhost:3000"))
+	require.Error(t, flags.Set("/mock"))
+	routes = &serveRoutes{Routes: []*serveRoute{{Prefix: "mock", Upstream: "http://localhost"}}}
+	require.ErrorContains(t, routes.prepare(), "must start with /")
+}
@@ -0,0 +1,182 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenServeFallsBackToFreePort(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer busy.Close()
+	_, port, _ := net.SplitHostPort(busy.Addr().String())
+
+	ln, err := listenServe("127.0.0.1", port)
+	require.NoError(t, err)
+	defer ln.Close()
+	require.NotEqual(t, busy.Addr().String(), ln.Addr().String())
+	require.True(t, strings.HasPrefix(serveURL(ln, true), "https://localhost:"))
+}
+
+func TestSelfSignedCertificate(t *testing.T) {
+	config, err := serveTLSConfig("", "", "192.168.1.10")
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	require.NoError(t, err)
+	require.NoError(t, cert.VerifyHostname("localhost"))
+	require.NoError(t, cert.VerifyHostname("192.168.1.10"))
+
+	_, err = serveTLSConfig("cert.pem", "", "")
+	require.ErrorContains(t, err, "--cert and --key must be used together")
+}
+
+func TestRunServerHTTPSAndGracefulShutdown(t *testing.T) {
+	config, err := serveTLSConfig("", "", "")
+	require.NoError(t, err)
+	ln, err := listenServe("127.0.0.1", "0")
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	server := &http.Server{TLSConfig: config, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		// an in-flight request completes during the shutdown
+		time.Sleep(100 * time.Millisecond)
+		_, _ = io.WriteString(w, "done")
+	})}
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- runServer(ctx, server, ln) }()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	body := make(chan string, 1)
+	go func() {
+		resp, err := client.Get(serveURL(ln, true))
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		body <- string(data)
+	}()
+	<-started
+	cancel()
+	require.Equal(t, "done", <-body)
+	require.NoError(t, <-result)
+}
+
+func TestRunServerEndsLiveReloadStreams(t *testing.T) {
+	ln, err := listenServe("127.0.0.1", "0")
+	require.NoError(t, err)
+	reload := newLiveReload()
+	server := &http.Server{Handler: reload}
+	server.RegisterOnShutdown(reload.close)
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- runServer(ctx, server, ln) }()
+
+	resp, err := http.Get(serveURL(ln, false) + liveReloadPath)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	cancel()
+	select {
+	case err := <-result:
+		require.NoError(t, err)
+	case <-time.After(serveShutdownTimeout):
+		t.Fatal("the event stream held the shutdown")
+	}
+	_, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+}
+
+func TestRunServerShutdownTimeout(t *testing.T) {
+	old := serveShutdownTimeout
+	serveShutdownTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { serveShutdownTimeout = old })
+	ln, err := listenServe("127.0.0.1", "0")
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- runServer(ctx, server, ln) }()
+	go func() {
+		resp, err := http.Get(serveURL(ln, false))
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+	cancel()
+	// a request outliving the timeout is cut off, not reported as an error
+	require.NoError(t, <-result)
+}
+
+func TestIsSPARoute(t *testing.T) {
+	request := func(method, path, accept string) *http.Request {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("Accept", accept)
+		return r
+	}
+	require.True(t, isSPARoute(request(http.MethodGet, "/users/42", "text/html,application/xhtml+xml")))
+	require.False(t, isSPARoute(request(http.MethodGet, "/app.js", "*/*")))
+	require.False(t, isSPARoute(request(http.MethodPost, "/users", "text/html")))
+	require.False(t, isSPARoute(request(http.MethodGet, "/users", "application/json")))
+	require.False(t, isSPARoute(request(http.MethodGet, "/api/users", "")))
+	require.False(t, isSPARoute(request(http.MethodGet, "/api/users", "*/*")))
+
+	navigate := request(http.MethodGet, "/users/42", "*/*")
+	navigate.Header.Set("Sec-Fetch-Mode", "navigate")
+	require.True(t, isSPARoute(navigate))
+}
+
+func TestAccessLog(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	handler := accessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := w.(http.Flusher)
+		require.True(t, ok)
+		w.WriteHeader(http.StatusTeapot)
+		_, _ = io.WriteString(w, "short")
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/tea?x=1", nil))
+	require.Contains(t, logs.String(), "GET /tea?x=1 418 5B")
+}
@@ -0,0 +1,347 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/Masterminds/semver"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/mitchellh/go-homedir"
+)
+
+// tasksChannelEnv selects the release channel of the tasks: stable, beta or
+// nightly. Without a channel the tasks follow OPS_BRANCH.
+const tasksChannelEnv = "OPS_CHANNEL"
+
+// tagRefPrefix marks a ref selecting the newest version tag matching a
+// glob, releaseRefPrefix the newest one that is not a pre-release.
+const (
+	tagRefPrefix     = "tag:"
+	releaseRefPrefix = "release:"
+)
+
+// the length of the changes shown after an update
+const (
+	maxChangelogLines   = 40
+	maxChangelogCommits = 20
+)
+
+// tasksRef maps the channel to the ref of the tasks. Each channel can be
+// remapped with OPS_CHANNEL_<NAME>, to a branch, to tag:<glob> or to
+// release:<glob>.
+//
+//	stable   the newest release of the OPS_BRANCH line, e.g. 0.1.* for 0.1.0
+//	beta     the newest version tag of the line, pre-releases included
+//	nightly  the main branch
+//
+// When OPS_BRANCH is not a version, stable and beta follow the branch.
+func tasksRef() (string, error) {
+	channel := strings.ToLower(strings.TrimSpace(os.Getenv(tasksChannelEnv)))
+	if channel == "" {
+		return getOpsBranch(), nil
+	}
+	if ref := os.Getenv(tasksChannelEnv + "_" + strings.ToUpper(channel)); ref != "" {
+		return ref, nil
+	}
+	switch channel {
+	case "stable":
+		if line := tasksLine(); line != "" {
+			return releaseRefPrefix + line, nil
+		}
+		return getOpsBranch(), nil
+	case "beta":
+		if line := tasksLine(); line != "" {
+			return tagRefPrefix + line, nil
+		}
+		return getOpsBranch(), nil
+	case "nightly":
+		return "main", nil
+	}
+	return "", fmt.Errorf("unknown channel %q in %s, use stable, beta or nightly", channel, tasksChannelEnv)
+}
+
+// tasksLine is the glob of the versions in the release line of OPS_BRANCH,
+// "" when the branch is not a version.
+func tasksLine() string {
+	version, err := semver.NewVersion(getOpsBranch())
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d.%d.*", version.Major(), version.Minor())
+}
+
+// parseTagRef splits a tag:<glob> or release:<glob> ref into the glob and
+// whether pre-releases are included.
+func parseTagRef(ref string) (string, bool, bool) {
+	if pattern, ok := strings.CutPrefix(ref, tagRefPrefix); ok {
+		return pattern, true, true
+	}
+	if pattern, ok := strings.CutPrefix(ref, releaseRefPrefix); ok {
+		return pattern, false, true
+	}
+	return "", false, false
+}
+
+// currentTasksSource is where the tasks come from for OPS_REPO and the
+// channel.
+func currentTasksSource() (tasksSource, error) {
+	ref, err := tasksRef()
+	if err != nil {
+		return nil, err
+	}
+	return newTasksSource(getOpsRepo(), ref)
+}
+
+// newestTag returns the highest semantic version among the tags matching
+// pattern, with or without their leading v, "" if none. Pre-releases are
+// skipped unless prereleases is set.
+func newestTag(tags []string, pattern string, prereleases bool) string {
+	newest := ""
+	var newestVersion *semver.Version
+	for _, tag := range tags {
+		ok, _ := path.Match(pattern, tag)
+		if !ok {
+			ok, _ = path.Match(pattern, strings.TrimPrefix(tag, "v"))
+		}
+		if !ok {
+			continue
+		}
+		version, err := semver.NewVersion(tag)
+		if err != nil || (!prereleases && version.Prerelease() != "") {
+			continue
+		}
+		if newestVersion == nil || version.GreaterThan(newestVersion) {
+			newest, newestVersion = tag, version
+		}
+	}
+	return newest
+}
+
+// describeTasks names a version of the tasks as "<version> (<id>)".
+func describeTasks(version, id string) string {
+	switch {
+	case id == "":
+		return version
+	case version == "":
+		return shortTasksID(id)
+	}
+	return fmt.Sprintf("%s (%s)", version, shortTasksID(id))
+}
+
+func tasksVersion(dir string) string {
+	if opsRoot, err := readOpsRootFile(dir); err == nil {
+		return opsRoot.Version
+	}
+	return ""
+}
+
+// printTasksChanges shows what changed since the tasks oldID, at
+// oldVersion, to those in dir: the new sections of CHANGELOG.md or else
+// the new commits.
+func printTasksChanges(dir, oldID, oldVersion string) {
+	fmt.Printf("%s -> %s\n", describeTasks(oldVersion, oldID), describeTasks(tasksVersion(dir), currentTasksID(dir)))
+	if lines := changelogSince(dir, oldVersion); len(lines) > 0 {
+		fmt.Println("Release notes:")
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+		return
+	}
+	commits, complete := commitsSince(dir, oldID)
+	if len(commits) == 0 {
+		return
+	}
+	fmt.Println("Changes:")
+	for _, commit := range commits {
+		fmt.Println(" ", commit)
+	}
+	if !complete {
+		fmt.Println("  ...")
+	}
+}
+
+// changelogSince returns the sections of the CHANGELOG.md in dir before the
+// one of version, or only the first section when version is not known.
+func changelogSince(dir, version string) []string {
+	data, err := os.ReadFile(joinpath(dir, "CHANGELOG.md"))
+	if err != nil {
+		return nil
+	}
+	lines := []string{}
+	sections := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, " \r")
+		if strings.HasPrefix(line, "## ") {
+			if headingHasVersion(line, version) {
+				break
+			}
+			sections++
+			if version == "" && sections > 1 {
+				break
+			}
+		}
+		if sections > 0 {
+			lines = append(lines, line)
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > maxChangelogLines {
+		lines = append(lines[:maxChangelogLines], "...")
+	}
+	return lines
+}
+
+// headingHasVersion tells if a heading like "## [v0.1.0] - 2024-01-01" is
+// the one of version.
+func headingHasVersion(heading, version string) bool {
+	if version == "" {
+		return false
+	}
+	words := strings.FieldsFunc(heading, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '.' && r != '-' && r != '+'
+	})
+	for _, word := range words {
+		if strings.TrimPrefix(word, "v") == strings.TrimPrefix(version, "v") {
+			return true
+		}
+	}
+	return false
+}
+
+// commitsSince lists the commits of the checkout in dir after oldID, newest
+// first. It returns false when there are more than it shows.
+func commitsSince(dir, oldID string) ([]string, bool) {
+	repo, err := git.PlainOpen(dir)
+	if err != nil || oldID == "" || strings.HasPrefix(oldID, "sha256:") {
+		return nil, true
+	}
+	old := plumbing.NewHash(oldID)
+	// a tag is identified by the tag object
+	if tag, err := repo.TagObject(old); err == nil {
+		old = tag.Target
+	}
+	// the old tasks come from another history, e.g. another channel
+	if _, err := repo.CommitObject(old); err != nil {
+		return nil, true
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, true
+	}
+	iter, err := repo.Log(&git.LogOptions{From: head.Hash()})
+	if err != nil {
+		return nil, true
+	}
+	defer iter.Close()
+
+	commits := []string{}
+	complete := true
+	errStop := errors.New("stop")
+	//nolint:errcheck
+	iter.ForEach(func(c *object.Commit) error {
+		if c.Hash == old {
+			return errStop
+		}
+		if len(commits) == maxChangelogCommits {
+			complete = false
+			return errStop
+		}
+		subject, _, _ := strings.Cut(strings.TrimSpace(c.Message), "\n")
+		commits = append(commits, shortHash(c.Hash.String())+" "+subject)
+		return nil
+	})
+	return commits, complete
+}
+
+// checkTasksUpdate reports whether source has tasks other than those in
+// opsBranchDir and what changed, downloading them aside without applying
+// them.
+func checkTasksUpdate(opsBranchDir string, source tasksSource) (bool, error) {
+	localDir := joinpath(opsBranchDir, "olaris")
+	if !isDir(localDir) {
+		fmt.Println("Tasks not downloaded yet, 'ops -update' downloads them from", source)
+		return true, nil
+	}
+	current := currentTasksID(localDir)
+	latest, err := source.latest()
+	if err != nil {
+		return false, err
+	}
+	if latest != "" && latest == current {
+		fmt.Println("Tasks are up to date:", describeTasks(tasksVersion(localDir), current))
+		return false, nil
+	}
+
+	staging := joinpath(opsBranchDir, "olaris.check")
+	if err := os.RemoveAll(staging); err != nil {
+		return false, err
+	}
+	//nolint:errcheck
+	defer os.RemoveAll(staging)
+	if _, err := fetchTasks(source, staging); err != nil {
+		return false, err
+	}
+	if err := validateTasks(staging); err != nil {
+		return false, fmt.Errorf("the new tasks cannot be used: %w", err)
+	}
+	fmt.Println("Tasks update available:")
+	printTasksChanges(staging, current, tasksVersion(localDir))
+	return true, nil
+}
+
+// checkUpdates implements ops -update --check: it reports the updates of
+// the tasks and of the plugins without applying them.
+func checkUpdates() error {
+	source, err := currentTasksSource()
+	if err != nil {
+		return err
+	}
+	opsBranchDir, err := homedir.Expand(joinpath("~/.ops", getOpsBranch()))
+	if err != nil {
+		return err
+	}
+	tasks, err := checkTasksUpdate(opsBranchDir, source)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pluginCheckTimeout)
+	defer cancel()
+	plugins := checkPluginUpdates(ctx)
+	if len(plugins) > 0 {
+		fmt.Println("Plugin updates available:")
+		for _, plugin := range plugins {
+			fmt.Println(" ", plugin)
+		}
+	}
+	if tasks || len(plugins) > 0 {
+		fmt.Println("Use 'ops -update' to update.")
+	}
+	return nil
+}
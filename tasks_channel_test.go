@@ -0,0 +1,159 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTasksRef(t *testing.T) {
+	t.Setenv("OPS_BRANCH", "0.1.0")
+	t.Setenv(tasksChannelEnv, "")
+	t.Setenv(tasksChannelEnv+"_BETA", "")
+	for channel, expected := range map[string]string{
+		"":        "0.1.0",
+		"stable":  "release:0.1.*",
+		"Beta":    "tag:0.1.*",
+		"nightly": "main",
+	} {
+		t.Setenv(tasksChannelEnv, channel)
+		ref, err := tasksRef()
+		require.NoError(t, err)
+		require.Equal(t, expected, ref, channel)
+	}
+
+	t.Setenv(tasksChannelEnv, "beta")
+	t.Setenv(tasksChannelEnv+"_BETA", "tag:v0.1.*")
+	ref, err := tasksRef()
+	require.NoError(t, err)
+	require.Equal(t, "tag:v0.1.*", ref)
+
+	// a branch that is not a version has no line to follow
+	t.Setenv("OPS_BRANCH", "develop")
+	t.Setenv(tasksChannelEnv+"_BETA", "")
+	for _, channel := range []string{"stable", "beta"} {
+		t.Setenv(tasksChannelEnv, channel)
+		ref, err := tasksRef()
+		require.NoError(t, err)
+		require.Equal(t, "develop", ref, channel)
+	}
+
+	t.Setenv(tasksChannelEnv, "edge")
+	_, err = tasksRef()
+	require.ErrorContains(t, err, `unknown channel "edge"`)
+}
+
+func TestNewestTag(t *testing.T) {
+	tags := []string{"v0.1.0", "v0.2.0-beta.1", "v0.1.1", "latest", "v0.2.0-beta.2", "v10.2.0", "0.2.1-rc.1"}
+	require.Equal(t, "v10.2.0", newestTag(tags, "*", true))
+	require.Equal(t, "v0.1.1", newestTag(tags, "v0.1.*", true))
+	require.Equal(t, "", newestTag(tags, "v1.*", true))
+
+	// a line matches the tags with and without the v
+	require.Equal(t, "0.2.1-rc.1", newestTag(tags, "0.2.*", true))
+	require.Equal(t, "", newestTag(tags, "0.2.*", false))
+	require.Equal(t, "v0.1.1", newestTag(tags, "0.1.*", false))
+}
+
+func TestTasksSourceReleaseRef(t *testing.T) {
+	source, err := newTasksSource("https://example.com/tasks.git", "release:0.1.*")
+	require.NoError(t, err)
+	require.Equal(t, &gitTasksSource{url: "https://example.com/tasks.git", tags: "0.1.*"}, source)
+
+	oci, err := newTasksSource("oci://registry.example.com/tasks", "tag:0.1.*")
+	require.NoError(t, err)
+	require.Equal(t, "0.1.*", oci.(*ociTasksSource).tags)
+	require.True(t, oci.(*ociTasksSource).prereleases)
+}
+
+func TestChangelogSince(t *testing.T) {
+	dir := t.TempDir()
+	require.Nil(t, changelogSince(dir, "0.1.0"))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "CHANGELOG.md"), []byte(`# Changelog
+
+## [0.3.0] - 2024-03-01
+- third
+
+## [0.2.0] - 2024-02-01
+- second
+
+## [0.1.0] - 2024-01-01
+- first
+`), 0644))
+	require.Equal(t, []string{"## [0.3.0] - 2024-03-01", "- third", "", "## [0.2.0] - 2024-02-01", "- second"},
+		changelogSince(dir, "0.1.0"))
+	require.Equal(t, []string{"## [0.3.0] - 2024-03-01", "- third"}, changelogSince(dir, ""))
+	require.Empty(t, changelogSince(dir, "v0.3.0"))
+}
+
+func TestGitTasksSourceChannelTag(t *testing.T) {
+	t.Setenv(tasksKeepEnv, "")
+	opsBranchDir, src := setupTasksCheckout(t)
+	localDir := filepath.Join(opsBranchDir, "olaris")
+	old := tasksHead(t, localDir)
+
+	source, err := newTasksSource(src.dir, "tag:v*")
+	require.NoError(t, err)
+	require.Equal(t, &gitTasksSource{url: src.dir, tags: "v*", prereleases: true}, source)
+	_, err = source.latest()
+	require.ErrorContains(t, err, "no version tag matching v*")
+
+	tagged := src.commit(OPSROOT, `{"version": "0.2.0"}`)
+	_, err = src.repo.CreateTag("v0.2.0", tagged, &git.CreateTagOptions{
+		Tagger:  &object.Signature{Name: "ops", Email: "ops@example.com", When: time.Now()},
+		Message: "0.2.0",
+	})
+	require.NoError(t, err)
+	src.commit(OPSFILE, "version: '3'\n# unreleased\n")
+
+	out := captureStdout(t, func() {
+		updated, err := checkTasksUpdate(opsBranchDir, source)
+		require.NoError(t, err)
+		require.True(t, updated)
+	})
+	require.Contains(t, out, "0.1.0 ("+shortHash(old)+") -> 0.2.0")
+	require.Contains(t, out, "update "+OPSROOT)
+	require.NotContains(t, out, "update "+OPSFILE)
+	require.Equal(t, old, tasksHead(t, localDir))
+	require.NoDirExists(t, filepath.Join(opsBranchDir, "olaris.check"))
+
+	updated, err := updateTasks(opsBranchDir, source)
+	require.NoError(t, err)
+	require.True(t, updated)
+	require.Equal(t, "0.2.0", tasksVersion(localDir))
+
+	// the annotated tag is recorded, so the checkout is up to date
+	latest, err := source.latest()
+	require.NoError(t, err)
+	require.Equal(t, latest, currentTasksID(localDir))
+	updated, err = updateTasks(opsBranchDir, source)
+	require.NoError(t, err)
+	require.False(t, updated)
+	out = captureStdout(t, func() {
+		_, err := checkTasksUpdate(opsBranchDir, source)
+		require.NoError(t, err)
+	})
+	require.Equal(t, "Tasks are up to date: 0.2.0 ("+shortTasksID(latest)+")\n", out)
+}
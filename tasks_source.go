@@ -0,0 +1,512 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/mitchellh/go-homedir"
+)
+
+// tasksSource is where ops downloads the olaris tasks from, selected by the
+// scheme of OPS_REPO:
+//
+//	https://host/org/repo         a git repository, at OPS_BRANCH
+//	https://host/tasks.tar.gz     a tarball, checked against #sha256=<hex>
+//	                              or the <url>.sha256 file; the latter comes
+//	                              from the same server, so only #sha256 pins
+//	                              the content
+//	oci://registry/repo[:tag]     an OCI artifact, tagged OPS_BRANCH by default
+//	file:///path or a path        a local directory
+//
+// Git repositories and OCI artifacts follow the ref of the release channel,
+// see tasksRef.
+type tasksSource interface {
+	// latest identifies the tasks the source provides now, "" when it
+	// cannot tell without downloading them.
+	latest() (string, error)
+	// fetch downloads the tasks into the new directory dir and returns
+	// their id.
+	fetch(dir string) (string, error)
+	String() string
+}
+
+// tasksHTTPClient downloads the tarballs and the OCI artifacts; the timeout
+// bounds a stalled server, not a slow download of a normal size.
+var tasksHTTPClient = &http.Client{Timeout: 5 * time.Minute}
+
+// TASKSSOURCE records in non git checkouts where the tasks come from.
+const TASKSSOURCE = ".opssource"
+
+type tasksSourceRecord struct {
+	Source string `json:"source"`
+	ID     string `json:"id"`
+}
+
+// newTasksSource returns the source of repo at ref, a branch or tag name,
+// tag:<glob> for the newest version tag matching glob, or release:<glob> for
+// the newest one that is not a pre-release.
+func newTasksSource(repo, ref string) (tasksSource, error) {
+	if strings.HasPrefix(repo, "oci://") {
+		return newOCITasksSource(repo, ref)
+	}
+	u, err := url.Parse(repo)
+	if err == nil && (u.Scheme == "http" || u.Scheme == "https") &&
+		(strings.HasSuffix(u.Path, ".tar.gz") || strings.HasSuffix(u.Path, ".tgz")) {
+		source := &tarballTasksSource{url: repo}
+		if sum, ok := strings.CutPrefix(u.Fragment, "sha256="); ok {
+			u.Fragment = ""
+			source.url, source.sha256 = u.String(), strings.ToLower(sum)
+		}
+		return source, nil
+	}
+	if path, ok := strings.CutPrefix(repo, "file://"); ok || isLocalPluginSource(repo) {
+		if !ok {
+			path = repo
+		}
+		// a local git repository is still cloned at the branch
+		if dir, err := homedir.Expand(path); err == nil && isDir(dir) && !isDir(joinpath(dir, ".git")) {
+			abs, err := filepath.Abs(dir)
+			if err != nil {
+				return nil, err
+			}
+			return &localTasksSource{dir: abs}, nil
+		}
+	}
+	if pattern, prereleases, ok := parseTagRef(ref); ok {
+		return &gitTasksSource{url: repo, tags: pattern, prereleases: prereleases}, nil
+	}
+	return &gitTasksSource{url: repo, branch: ref}, nil
+}
+
+// currentTasksID identifies the tasks in dir: the commit of a git checkout
+// or the id recorded when they were fetched.
+func currentTasksID(dir string) string {
+	if data, err := os.ReadFile(joinpath(dir, TASKSSOURCE)); err == nil {
+		var record tasksSourceRecord
+		if json.Unmarshal(data, &record) == nil {
+			return record.ID
+		}
+	}
+	if repo, err := git.PlainOpen(dir); err == nil {
+		return headCommit(repo)
+	}
+	return ""
+}
+
+func shortTasksID(id string) string {
+	return shortHash(strings.TrimPrefix(id, "sha256:"))
+}
+
+// fetchTasks downloads the tasks of source into dir, recording the source
+// when it is not a git checkout.
+func fetchTasks(source tasksSource, dir string) (string, error) {
+	id, err := source.fetch(dir)
+	if err != nil {
+		return "", err
+	}
+	// a tag is identified by the tag object, which is not the head commit
+	if _, isGit := source.(*gitTasksSource); isGit && id == currentTasksID(dir) {
+		return id, nil
+	}
+	data, err := json.MarshalIndent(tasksSourceRecord{Source: source.String(), ID: id}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return id, os.WriteFile(joinpath(dir, TASKSSOURCE), data, 0644)
+}
+
+// tasksSourceNewer tells if source provides tasks other than those in dir.
+func tasksSourceNewer(source tasksSource, dir string) bool {
+	latest, err := source.latest()
+	if err != nil {
+		warn("failed to check the tasks source", err)
+		return false
+	}
+	return latest != "" && latest != currentTasksID(dir)
+}
+
+// gitTasksSource clones a branch of a git repository, or its newest tag
+// matching the tags pattern.
+type gitTasksSource struct {
+	url         string
+	branch      string
+	tags        string
+	prereleases bool
+}
+
+func (s *gitTasksSource) String() string {
+	return s.url
+}
+
+// resolve finds the reference to clone in the remote repository.
+func (s *gitTasksSource) resolve() (*plumbing.Reference, error) {
+	remote := git.NewRemote(memory.NewStorage(), &gitconfig.RemoteConfig{Name: "origin", URLs: []string{s.url}})
+	refs, err := remote.List(&git.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if s.tags != "" {
+		tags := map[string]*plumbing.Reference{}
+		names := []string{}
+		for _, ref := range refs {
+			if ref.Name().IsTag() {
+				tags[ref.Name().Short()] = ref
+				names = append(names, ref.Name().Short())
+			}
+		}
+		if tag := newestTag(names, s.tags, s.prereleases); tag != "" {
+			return tags[tag], nil
+		}
+		return nil, fmt.Errorf("no version tag matching %s in the tasks repository", s.tags)
+	}
+	for _, ref := range refs {
+		if ref.Name() == plumbing.NewBranchReferenceName(s.branch) {
+			return ref, nil
+		}
+	}
+	return nil, fmt.Errorf("branch %s not found in the tasks repository", s.branch)
+}
+
+func (s *gitTasksSource) latest() (string, error) {
+	ref, err := s.resolve()
+	if err != nil {
+		return "", err
+	}
+	return ref.Hash().String(), nil
+}
+
+func (s *gitTasksSource) fetch(dir string) (string, error) {
+	ref := plumbing.NewHashReference(plumbing.NewBranchReferenceName(s.branch), plumbing.ZeroHash)
+	if s.tags != "" {
+		var err error
+		if ref, err = s.resolve(); err != nil {
+			return "", err
+		}
+	}
+	repo, err := git.PlainClone(dir, false, &git.CloneOptions{
+		URL:           s.url,
+		Progress:      os.Stderr,
+		ReferenceName: ref.Name(),
+		SingleBranch:  true,
+	})
+	if err != nil {
+		return "", err
+	}
+	if s.tags != "" {
+		return ref.Hash().String(), nil
+	}
+	return headCommit(repo), nil
+}
+
+// tarballTasksSource downloads a tar.gz and checks its sha256.
+type tarballTasksSource struct {
+	url    string
+	sha256 string
+}
+
+func (s *tarballTasksSource) String() string {
+	return s.url
+}
+
+// checksum returns the expected sha256, from OPS_REPO or <url>.sha256.
+func (s *tarballTasksSource) checksum() (string, error) {
+	if s.sha256 != "" {
+		return s.sha256, nil
+	}
+	resp, err := tasksHTTPClient.Get(s.url + ".sha256")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("no checksum for %s: add #sha256=<hex> to OPS_REPO or publish %s.sha256", s.url, s.url)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+	// the sha256sum format is "<hex>  <file>"
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty checksum in %s.sha256", s.url)
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+func (s *tarballTasksSource) latest() (string, error) {
+	sum, err := s.checksum()
+	if err != nil {
+		return "", err
+	}
+	return "sha256:" + sum, nil
+}
+
+func (s *tarballTasksSource) fetch(dir string) (string, error) {
+	sum, err := s.checksum()
+	if err != nil {
+		return "", err
+	}
+	if s.sha256 == "" {
+		warn("the tasks of", s.url, "are checked against a checksum from the same server, add #sha256=<hex> to OPS_REPO to pin them")
+	}
+	resp, err := tasksHTTPClient.Get(s.url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("cannot download %s: %s", s.url, resp.Status)
+	}
+	if err := extractVerifiedTar(resp.Body, "sha256:"+sum, dir); err != nil {
+		return "", fmt.Errorf("%s: %w", s.url, err)
+	}
+	return "sha256:" + sum, nil
+}
+
+// localTasksSource copies the tasks from a local directory.
+type localTasksSource struct {
+	dir string
+}
+
+func (s *localTasksSource) String() string {
+	return s.dir
+}
+
+func (s *localTasksSource) latest() (string, error) {
+	return "", nil
+}
+
+func (s *localTasksSource) fetch(dir string) (string, error) {
+	if !exists(s.dir, OPSFILE) {
+		return "", fmt.Errorf("no %s in %s", OPSFILE, s.dir)
+	}
+	return "", copyTasksDir(s.dir, dir)
+}
+
+func copyTasksDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(target, 0755)
+		}
+		if d.Type()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+// extractVerifiedTar saves r to a temporary file while hashing it, and
+// extracts it into dir only when its digest matches.
+func extractVerifiedTar(r io.Reader, digest, dir string) error {
+	tmp, err := os.CreateTemp("", "ops-tasks-*.tar")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), r); err != nil {
+		return err
+	}
+	if actual := "sha256:" + hex.EncodeToString(hash.Sum(nil)); actual != digest {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", digest, actual)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return extractTar(tmp, dir)
+}
+
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// linked tells if path, or one of its parents up to dir, is a symbolic link.
+func linked(dir, path string) bool {
+	for ; path != dir && isWithin(dir, path); path = filepath.Dir(path) {
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// linkWithin tells if the link name, relative to from, stays in dir step by
+// step, without going through other links.
+func linkWithin(dir, from, name string) bool {
+	path := from
+	segments := strings.Split(filepath.ToSlash(name), "/")
+	for i, segment := range segments {
+		path = filepath.Join(path, segment)
+		if !isWithin(dir, path) {
+			return false
+		}
+		if i < len(segments)-1 && linked(dir, path) {
+			return false
+		}
+	}
+	return true
+}
+
+// extractTar extracts a tar, optionally gzipped, into dir. A single top
+// level folder, as in the GitHub archives, is removed.
+func extractTar(r io.Reader, dir string) error {
+	br := bufio.NewReader(r)
+	var reader io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if !isWithin(dir, target) {
+			return fmt.Errorf("invalid path %q in the archive", hdr.Name)
+		}
+		// a link extracted before would lead the entry elsewhere
+		if linked(dir, target) {
+			return fmt.Errorf("invalid path %q in the archive, it goes through a link", hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, hdr.FileInfo().Mode().Perm())
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if filepath.IsAbs(hdr.Linkname) || !linkWithin(dir, filepath.Dir(target), hdr.Linkname) {
+				return fmt.Errorf("invalid link %q in the archive", hdr.Name)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		}
+	}
+	return flattenSingleDir(dir)
+}
+
+// flattenSingleDir moves up the content of the only folder in dir.
+func flattenSingleDir(dir string) error {
+	if exists(dir, OPSFILE) {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 || !entries[0].IsDir() {
+		return err
+	}
+	// the folder can contain an entry with its own name
+	tmp := joinpath(dir, ".ops-extract")
+	if err := os.Rename(joinpath(dir, entries[0].Name()), tmp); err != nil {
+		return err
+	}
+	children, err := os.ReadDir(tmp)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := os.Rename(joinpath(tmp, child.Name()), joinpath(dir, child.Name())); err != nil {
+			return err
+		}
+	}
+	return os.Remove(tmp)
+}
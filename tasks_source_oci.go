@@ -0,0 +1,268 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// The registry credentials, when it does not allow anonymous pulls.
+const (
+	ociUsernameEnv = "OPS_REPO_USERNAME"
+	ociPasswordEnv = "OPS_REPO_PASSWORD"
+)
+
+var ociManifestTypes = []string{
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type ociManifest struct {
+	Layers []ociDescriptor `json:"layers"`
+}
+
+// ociTasksSource pulls the tasks from the first tar layer of an OCI
+// artifact, e.g. pushed with oras push registry/tasks:0.1.0 olaris.tar.gz.
+type ociTasksSource struct {
+	ref string
+	// base is the registry API url, https://registry/v2/<repository>
+	base      string
+	reference string
+	// tags, when set, selects the newest version tag matching it
+	tags        string
+	prereleases bool
+	token       string
+}
+
+func newOCITasksSource(ref, branch string) (*ociTasksSource, error) {
+	rest := strings.TrimPrefix(ref, "oci://")
+	host, repository, ok := strings.Cut(rest, "/")
+	if !ok || host == "" || repository == "" {
+		return nil, fmt.Errorf("invalid OCI reference %q, expected oci://registry/repository[:tag]", ref)
+	}
+	reference := branch
+	if name, digest, ok := strings.Cut(repository, "@"); ok {
+		repository, reference = name, digest
+	} else if i := strings.LastIndex(repository, ":"); i >= 0 {
+		repository, reference = repository[:i], repository[i+1:]
+	}
+	if reference == "" {
+		return nil, fmt.Errorf("invalid OCI reference %q: no tag", ref)
+	}
+	// like docker, local registries are plain http
+	scheme := "https"
+	if hostname := strings.Split(host, ":")[0]; hostname == "localhost" || hostname == "127.0.0.1" {
+		scheme = "http"
+	}
+	source := &ociTasksSource{
+		ref:       ref,
+		base:      fmt.Sprintf("%s://%s/v2/%s", scheme, host, repository),
+		reference: reference,
+	}
+	if pattern, prereleases, ok := parseTagRef(reference); ok {
+		source.reference, source.tags, source.prereleases = "", pattern, prereleases
+	}
+	return source, nil
+}
+
+func (s *ociTasksSource) String() string {
+	return s.ref
+}
+
+// authorize gets a token for the Bearer challenge of a registry, see
+// https://distribution.github.io/distribution/spec/auth/token/
+func (s *ociTasksSource) authorize(challenge string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return nil
+	}
+	values := map[string]string{}
+	for _, param := range strings.Split(params, ",") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(param), "="); ok {
+			values[key] = strings.Trim(value, `"`)
+		}
+	}
+	if values["realm"] == "" {
+		return fmt.Errorf("invalid registry challenge %q", challenge)
+	}
+	query := url.Values{}
+	for _, key := range []string{"service", "scope"} {
+		if values[key] != "" {
+			query.Set(key, values[key])
+		}
+	}
+	req, err := http.NewRequest(http.MethodGet, values["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if user := os.Getenv(ociUsernameEnv); user != "" {
+		req.SetBasicAuth(user, os.Getenv(ociPasswordEnv))
+	}
+	resp, err := tasksHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry authentication failed: %s", resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return err
+	}
+	s.token = token.Token
+	if s.token == "" {
+		s.token = token.AccessToken
+	}
+	return nil
+}
+
+// get requests path from the registry, authenticating when challenged.
+func (s *ociTasksSource) get(path string, accept []string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(http.MethodGet, s.base+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(accept) > 0 {
+			req.Header.Set("Accept", strings.Join(accept, ", "))
+		}
+		if s.token != "" {
+			req.Header.Set("Authorization", "Bearer "+s.token)
+		} else if user := os.Getenv(ociUsernameEnv); user != "" {
+			req.SetBasicAuth(user, os.Getenv(ociPasswordEnv))
+		}
+		resp, err := tasksHTTPClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 && s.token == "" {
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+			if err := s.authorize(challenge); err != nil {
+				return nil, err
+			}
+			if s.token != "" {
+				continue
+			}
+			return nil, fmt.Errorf("%s%s: unauthorized, set %s and %s", s.base, path, ociUsernameEnv, ociPasswordEnv)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s%s: %s", s.base, path, resp.Status)
+		}
+		return resp, nil
+	}
+}
+
+// resolve finds the newest tag matching the tags pattern.
+func (s *ociTasksSource) resolve() (string, error) {
+	resp, err := s.get("/tags/list", nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var list struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return "", fmt.Errorf("invalid tag list of %s: %w", s.ref, err)
+	}
+	if tag := newestTag(list.Tags, s.tags, s.prereleases); tag != "" {
+		return tag, nil
+	}
+	return "", fmt.Errorf("no version tag matching %s in %s", s.tags, s.ref)
+}
+
+// manifest returns the manifest of the artifact and its digest.
+func (s *ociTasksSource) manifest() (*ociManifest, string, error) {
+	reference := s.reference
+	if s.tags != "" {
+		tag, err := s.resolve()
+		if err != nil {
+			return nil, "", err
+		}
+		reference = tag
+	}
+	resp, err := s.get("/manifests/"+reference, ociManifestTypes)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	if strings.HasPrefix(s.reference, "sha256:") && s.reference != digest {
+		return nil, "", fmt.Errorf("manifest digest mismatch: expected %s, got %s", s.reference, digest)
+	}
+	manifest := &ociManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, "", fmt.Errorf("invalid manifest of %s: %w", s.ref, err)
+	}
+	return manifest, digest, nil
+}
+
+func (s *ociTasksSource) latest() (string, error) {
+	_, digest, err := s.manifest()
+	return digest, err
+}
+
+func (s *ociTasksSource) fetch(dir string) (string, error) {
+	manifest, digest, err := s.manifest()
+	if err != nil {
+		return "", err
+	}
+	for _, layer := range manifest.Layers {
+		if !strings.Contains(layer.MediaType, "tar") {
+			continue
+		}
+		if !strings.HasPrefix(layer.Digest, "sha256:") {
+			return "", fmt.Errorf("unsupported digest %s in %s", layer.Digest, s.ref)
+		}
+		resp, err := s.get("/blobs/"+layer.Digest, nil)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if err := extractVerifiedTar(resp.Body, layer.Digest, dir); err != nil {
+			return "", fmt.Errorf("%s: %w", s.ref, err)
+		}
+		return digest, nil
+	}
+	return "", fmt.Errorf("no tar layer in %s", s.ref)
+}
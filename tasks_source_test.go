@@ -0,0 +1,264 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// tasksTarball returns a tar.gz of files under a top level folder, as in
+// the GitHub archives.
+func tasksTarball(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "olaris-main/", Typeflag: tar.TypeDir, Mode: 0755}))
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func validTasksFiles(version string) map[string]string {
+	return map[string]string{
+		"olaris-main/" + OPSFILE:        "version: '3'\n",
+		"olaris-main/" + OPSROOT:        fmt.Sprintf(`{"version": %q}`, version),
+		"olaris-main/olaris/" + OPSFILE: "version: '3'\n",
+	}
+}
+
+func TestNewTasksSource(t *testing.T) {
+	source, err := newTasksSource("https://github.com/apache/openserverless-task", "main")
+	require.NoError(t, err)
+	require.Equal(t, &gitTasksSource{url: "https://github.com/apache/openserverless-task", branch: "main"}, source)
+
+	source, err = newTasksSource("https://artifacts.example.com/olaris.tar.gz#sha256=ABC", "main")
+	require.NoError(t, err)
+	require.Equal(t, &tarballTasksSource{url: "https://artifacts.example.com/olaris.tar.gz", sha256: "abc"}, source)
+
+	source, err = newTasksSource("oci://registry.example.com/ops/tasks", "0.1.0")
+	require.NoError(t, err)
+	require.Equal(t, "https://registry.example.com/v2/ops/tasks", source.(*ociTasksSource).base)
+	require.Equal(t, "0.1.0", source.(*ociTasksSource).reference)
+	source, err = newTasksSource("oci://localhost:5000/tasks@sha256:1234", "0.1.0")
+	require.NoError(t, err)
+	require.Equal(t, "http://localhost:5000/v2/tasks", source.(*ociTasksSource).base)
+	require.Equal(t, "sha256:1234", source.(*ociTasksSource).reference)
+	_, err = newTasksSource("oci://registry.example.com", "main")
+	require.ErrorContains(t, err, "invalid OCI reference")
+
+	dir := t.TempDir()
+	source, err = newTasksSource("file://"+dir, "main")
+	require.NoError(t, err)
+	require.Equal(t, &localTasksSource{dir: dir}, source)
+
+	// a local git repository is cloned
+	src := newPluginRepo(t, "olaris")
+	source, err = newTasksSource(src.dir, "main")
+	require.NoError(t, err)
+	require.Equal(t, &gitTasksSource{url: src.dir, branch: "main"}, source)
+}
+
+func TestTarballTasksSource(t *testing.T) {
+	files := map[string][]byte{"/olaris.tar.gz": tasksTarball(t, validTasksFiles("0.1.0"))}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+	tarball := server.URL + "/olaris.tar.gz"
+
+	_, err := (&tarballTasksSource{url: tarball}).fetch(filepath.Join(t.TempDir(), "olaris"))
+	require.ErrorContains(t, err, "no checksum")
+
+	_, err = (&tarballTasksSource{url: tarball, sha256: strings.Repeat("0", 64)}).fetch(filepath.Join(t.TempDir(), "olaris"))
+	require.ErrorContains(t, err, "checksum mismatch")
+
+	files["/olaris.tar.gz.sha256"] = []byte(sha256Hex(files["/olaris.tar.gz"]) + "  olaris.tar.gz\n")
+	source, err := newTasksSource(tarball, "main")
+	require.NoError(t, err)
+	opsBranchDir := t.TempDir()
+	localDir := filepath.Join(opsBranchDir, "olaris")
+	id, err := fetchTasks(source, localDir)
+	require.NoError(t, err)
+	require.Equal(t, "sha256:"+sha256Hex(files["/olaris.tar.gz"]), id)
+	require.NoError(t, validateTasks(localDir))
+	require.FileExists(t, filepath.Join(localDir, "olaris", OPSFILE))
+	require.Equal(t, id, currentTasksID(localDir))
+
+	updated, err := updateTasks(opsBranchDir, source)
+	require.NoError(t, err)
+	require.False(t, updated)
+	require.False(t, tasksSourceNewer(source, localDir))
+
+	files["/olaris.tar.gz"] = tasksTarball(t, validTasksFiles("0.2.0"))
+	files["/olaris.tar.gz.sha256"] = []byte(sha256Hex(files["/olaris.tar.gz"]))
+	require.True(t, tasksSourceNewer(source, localDir))
+	updated, err = updateTasks(opsBranchDir, source)
+	require.NoError(t, err)
+	require.True(t, updated)
+	opsRoot, err := readOpsRootFile(localDir)
+	require.NoError(t, err)
+	require.Equal(t, "0.2.0", opsRoot.Version)
+}
+
+func TestExtractTarRejectsEscapes(t *testing.T) {
+	for _, hdr := range []*tar.Header{
+		{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../../etc/passwd"},
+		{Name: "abs", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+	} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		require.NoError(t, tw.WriteHeader(hdr))
+		require.NoError(t, tw.Close())
+		require.ErrorContains(t, extractTar(&buf, t.TempDir()), "invalid", hdr.Name)
+	}
+}
+
+func TestExtractTarRejectsLinkChains(t *testing.T) {
+	for _, headers := range [][]*tar.Header{
+		{
+			{Name: "x/", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "x/y", Typeflag: tar.TypeSymlink, Linkname: ".."},
+			{Name: "x/y/z", Typeflag: tar.TypeSymlink, Linkname: ".."},
+			{Name: "x/y/z/evil", Typeflag: tar.TypeReg, Mode: 0644},
+		},
+		{
+			{Name: "y", Typeflag: tar.TypeSymlink, Linkname: "x"},
+			{Name: "x/", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "y/../.."},
+		},
+		{
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "link", Typeflag: tar.TypeReg, Mode: 0644},
+		},
+	} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, hdr := range headers {
+			require.NoError(t, tw.WriteHeader(hdr))
+		}
+		require.NoError(t, tw.Close())
+		parent := t.TempDir()
+		dir := filepath.Join(parent, "staging")
+		require.ErrorContains(t, extractTar(&buf, dir), "invalid")
+		require.NoFileExists(t, filepath.Join(parent, "evil"))
+	}
+}
+
+func TestOCITasksSource(t *testing.T) {
+	layer := tasksTarball(t, validTasksFiles("0.1.0"))
+	layerDigest := "sha256:" + sha256Hex(layer)
+	manifest := []byte(fmt.Sprintf(`{"schemaVersion": 2, "layers": [
+		{"mediaType": "application/vnd.oci.image.config.v1+json", "digest": "sha256:00", "size": 2},
+		{"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip", "digest": %q, "size": %d}]}`, layerDigest, len(layer)))
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			require.Equal(t, "repository:tasks:pull", r.URL.Query().Get("scope"))
+			_, _ = w.Write([]byte(`{"token": "secret"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:tasks:pull"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/tasks/manifests/main":
+			require.Contains(t, r.Header.Get("Accept"), "application/vnd.oci.image.manifest.v1+json")
+			_, _ = w.Write(manifest)
+		case "/v2/tasks/blobs/" + layerDigest:
+			_, _ = w.Write(layer)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	ref := "oci://" + strings.TrimPrefix(server.URL, "http://") + "/tasks"
+
+	source, err := newTasksSource(ref, "main")
+	require.NoError(t, err)
+	dir := filepath.Join(t.TempDir(), "olaris")
+	id, err := fetchTasks(source, dir)
+	require.NoError(t, err)
+	require.Equal(t, "sha256:"+sha256Hex(manifest), id)
+	require.NoError(t, validateTasks(dir))
+
+	latest, err := source.latest()
+	require.NoError(t, err)
+	require.Equal(t, id, latest)
+
+	source, err = newTasksSource(ref+"@sha256:"+strings.Repeat("1", 64), "main")
+	require.NoError(t, err)
+	_, err = source.latest()
+	require.ErrorContains(t, err, "404")
+}
+
+func TestLocalTasksSource(t *testing.T) {
+	src := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(src, OPSFILE), []byte("version: '3'\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(src, OPSROOT), []byte(`{"version": "0.1.0"}`), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(src, ".git"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, ".git", "HEAD"), []byte("ref: refs/heads/main\n"), 0644))
+
+	source := &localTasksSource{dir: src}
+	opsBranchDir := t.TempDir()
+	localDir := filepath.Join(opsBranchDir, "olaris")
+	_, err := fetchTasks(source, localDir)
+	require.NoError(t, err)
+	require.FileExists(t, filepath.Join(localDir, OPSROOT))
+	require.NoDirExists(t, filepath.Join(localDir, ".git"))
+
+	// a directory cannot tell if it changed, so it is always copied again
+	updated, err := updateTasks(opsBranchDir, source)
+	require.NoError(t, err)
+	require.True(t, updated)
+
+	_, err = (&localTasksSource{dir: t.TempDir()}).fetch(filepath.Join(t.TempDir(), "olaris"))
+	require.ErrorContains(t, err, "no opsfile.yml")
+}
@@ -0,0 +1,220 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// tasksKeepEnv sets how many previous task checkouts ops -update keeps
+// for ops -update --rollback.
+const tasksKeepEnv = "OPS_UPDATE_KEEP"
+
+const defaultTasksKeep = 3
+
+// the previous checkouts are in <branch dir>/rollback, named by date and
+// commit so they sort oldest first
+const tasksRollbackDir = "rollback"
+
+func tasksKeep() int {
+	if keep, err := strconv.Atoi(os.Getenv(tasksKeepEnv)); err == nil && keep >= 0 {
+		return keep
+	}
+	return defaultTasksKeep
+}
+
+// validateTasks checks that the tasks in dir can be used: every opsfile.yml
+// parses, opsroot.json is valid and the prerequisites can be read.
+func validateTasks(dir string) error {
+	if !exists(dir, OPSFILE) {
+		return fmt.Errorf("no %s found", OPSFILE)
+	}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || d.Name() != OPSFILE {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var opsfile map[string]interface{}
+		if err := yaml.Unmarshal(data, &opsfile); err != nil {
+			rel, _ := filepath.Rel(dir, path)
+			return fmt.Errorf("invalid %s: %w", rel, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(joinpath(dir, OPSROOT))
+	if err != nil {
+		return err
+	}
+	var opsRoot OpsRootJSON
+	if err := json.Unmarshal(data, &opsRoot); err != nil {
+		return fmt.Errorf("invalid %s: %w", OPSROOT, err)
+	}
+
+	if exists(dir, PREREQ) {
+		data, err := os.ReadFile(joinpath(dir, PREREQ))
+		if err != nil {
+			return err
+		}
+		var prereq yaml.Node
+		if err := yaml.Unmarshal(data, &prereq); err != nil {
+			return fmt.Errorf("invalid %s: %w", PREREQ, err)
+		}
+		if len(prereq.Content) > 0 {
+			if _, _, err := loadPrereq(dir); err != nil {
+				return fmt.Errorf("invalid %s: %w", PREREQ, err)
+			}
+		}
+	}
+	return nil
+}
+
+// updateTasks fetches the tasks next to the current ones, validates them
+// and only then swaps them in, keeping the current checkout for a rollback.
+// It returns false when the tasks are already up to date.
+func updateTasks(opsBranchDir string, source tasksSource) (bool, error) {
+	localDir := joinpath(opsBranchDir, "olaris")
+	current := currentTasksID(localDir)
+	latest, err := source.latest()
+	if err != nil {
+		return false, err
+	}
+	if latest != "" && latest == current {
+		return false, nil
+	}
+
+	staging := joinpath(opsBranchDir, "olaris.new")
+	if err := os.RemoveAll(staging); err != nil {
+		return false, err
+	}
+	_, err = fetchTasks(source, staging)
+	if err == nil {
+		err = validateTasks(staging)
+	}
+	if err != nil {
+		//nolint:errcheck
+		os.RemoveAll(staging)
+		return false, fmt.Errorf("the new tasks cannot be used, keeping the current ones: %w", err)
+	}
+
+	rollbackDir := joinpath(opsBranchDir, tasksRollbackDir)
+	if err := os.MkdirAll(rollbackDir, 0755); err != nil {
+		return false, err
+	}
+	name := time.Now().UTC().Format("20060102-150405.000000000")
+	if current != "" {
+		name += "-" + shortTasksID(current)
+	}
+	backup := joinpath(rollbackDir, name)
+	if err := os.Rename(localDir, backup); err != nil {
+		return false, err
+	}
+	if err := os.Rename(staging, localDir); err != nil {
+		//nolint:errcheck
+		os.Rename(backup, localDir)
+		return false, err
+	}
+	pruneTaskBackups(rollbackDir, tasksKeep())
+	return true, nil
+}
+
+// taskBackups lists the previous checkouts, oldest first.
+func taskBackups(rollbackDir string) []string {
+	entries, err := os.ReadDir(rollbackDir)
+	if err != nil {
+		return nil
+	}
+	backups := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			backups = append(backups, joinpath(rollbackDir, entry.Name()))
+		}
+	}
+	sort.Strings(backups)
+	return backups
+}
+
+func pruneTaskBackups(rollbackDir string, keep int) {
+	backups := taskBackups(rollbackDir)
+	for len(backups) > keep {
+		if err := os.RemoveAll(backups[0]); err != nil {
+			warn("cannot remove old tasks", backups[0], err)
+		}
+		backups = backups[1:]
+	}
+}
+
+// rollbackTasks replaces the tasks with the most recent previous checkout,
+// for ops -update --rollback.
+func rollbackTasks(opsBranchDir string) (string, error) {
+	localDir := joinpath(opsBranchDir, "olaris")
+	backups := taskBackups(joinpath(opsBranchDir, tasksRollbackDir))
+	if len(backups) == 0 {
+		return "", errors.New("no previous tasks to roll back to")
+	}
+	previous := backups[len(backups)-1]
+	if err := validateTasks(previous); err != nil {
+		return "", fmt.Errorf("the previous tasks in %s cannot be used: %w", previous, err)
+	}
+
+	failed := joinpath(opsBranchDir, "olaris.failed")
+	if err := os.RemoveAll(failed); err != nil {
+		return "", err
+	}
+	if isDir(localDir) {
+		if err := os.Rename(localDir, failed); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Rename(previous, localDir); err != nil {
+		//nolint:errcheck
+		os.Rename(failed, localDir)
+		return "", err
+	}
+	//nolint:errcheck
+	os.RemoveAll(failed)
+
+	if id := currentTasksID(localDir); id != "" {
+		fmt.Printf("Tasks rolled back to %s\n", shortTasksID(id))
+	} else {
+		fmt.Println("Tasks rolled back")
+	}
+	return localDir, nil
+}
@@ -0,0 +1,116 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package openserverless
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTasksCheckout(t *testing.T) (string, *pluginRepo) {
+	t.Helper()
+	src := newPluginRepo(t, "olaris")
+	src.commit(OPSROOT, `{"version": "0.1.0"}`)
+	opsBranchDir := t.TempDir()
+	_, err := git.PlainClone(filepath.Join(opsBranchDir, "olaris"), false, &git.CloneOptions{URL: src.dir})
+	require.NoError(t, err)
+	return opsBranchDir, src
+}
+
+func tasksHead(t *testing.T, dir string) string {
+	t.Helper()
+	repo, err := git.PlainOpen(dir)
+	require.NoError(t, err)
+	return headCommit(repo)
+}
+
+func TestValidateTasks(t *testing.T) {
+	dir := t.TempDir()
+	require.ErrorContains(t, validateTasks(dir), "no opsfile.yml found")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, OPSFILE), []byte("version: '3'\n"), 0644))
+	require.ErrorContains(t, validateTasks(dir), OPSROOT)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, OPSROOT), []byte(`{"version": `), 0644))
+	require.ErrorContains(t, validateTasks(dir), "invalid opsroot.json")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, OPSROOT), []byte(`{"version": "0.1.0"}`), 0644))
+	require.NoError(t, validateTasks(dir))
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", OPSFILE), []byte("tasks: [\n"), 0644))
+	require.ErrorContains(t, validateTasks(dir), "invalid "+filepath.Join("sub", OPSFILE))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", OPSFILE), []byte("version: '3'\n"), 0644))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, PREREQ), []byte(""), 0644))
+	require.NoError(t, validateTasks(dir))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, PREREQ), []byte("tasks: {\n"), 0644))
+	require.ErrorContains(t, validateTasks(dir), "invalid "+PREREQ)
+}
+
+func TestUpdateTasksAndRollback(t *testing.T) {
+	t.Setenv(tasksKeepEnv, "")
+	opsBranchDir, src := setupTasksCheckout(t)
+	localDir := filepath.Join(opsBranchDir, "olaris")
+	branch := src.defaultBranch()
+	first := tasksHead(t, localDir)
+
+	updated, err := updateTasks(opsBranchDir, &gitTasksSource{url: src.dir, branch: branch})
+	require.NoError(t, err)
+	require.False(t, updated)
+
+	second := src.commit(OPSFILE, "version: '3'\n# second\n")
+	updated, err = updateTasks(opsBranchDir, &gitTasksSource{url: src.dir, branch: branch})
+	require.NoError(t, err)
+	require.True(t, updated)
+	require.Equal(t, second.String(), tasksHead(t, localDir))
+	require.Len(t, taskBackups(filepath.Join(opsBranchDir, tasksRollbackDir)), 1)
+
+	// a broken upstream commit leaves the current tasks alone
+	src.commit(OPSFILE, "tasks: [\n")
+	_, err = updateTasks(opsBranchDir, &gitTasksSource{url: src.dir, branch: branch})
+	require.ErrorContains(t, err, "keeping the current ones")
+	require.Equal(t, second.String(), tasksHead(t, localDir))
+	require.NoDirExists(t, filepath.Join(opsBranchDir, "olaris.new"))
+
+	out := captureStdout(t, func() {
+		dir, err := rollbackTasks(opsBranchDir)
+		require.NoError(t, err)
+		require.Equal(t, localDir, dir)
+	})
+	require.Equal(t, "Tasks rolled back to "+shortHash(first)+"\n", out)
+	require.Equal(t, first, tasksHead(t, localDir))
+	require.NoDirExists(t, filepath.Join(opsBranchDir, "olaris.failed"))
+
+	_, err = rollbackTasks(opsBranchDir)
+	require.ErrorContains(t, err, "no previous tasks")
+}
+
+func TestUpdateTasksKeepsLastCheckouts(t *testing.T) {
+	t.Setenv(tasksKeepEnv, "2")
+	opsBranchDir, src := setupTasksCheckout(t)
+	for i := 0; i < 4; i++ {
+		src.commit(OPSFILE, "version: '3'\n# "+string(rune('a'+i))+"\n")
+		updated, err := updateTasks(opsBranchDir, &gitTasksSource{url: src.dir, branch: src.defaultBranch()})
+		require.NoError(t, err)
+		require.True(t, updated)
+	}
+	require.Len(t, taskBackups(filepath.Join(opsBranchDir, tasksRollbackDir)), 2)
+}
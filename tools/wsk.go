@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/apache/openserverless-cli/config"
 	"github.com/apache/openwhisk-client-go/whisk"
 	goi18n "github.com/nicksnyder/go-i18n/i18n"
 	"github.com/sciabarracom/openwhisk-cli/commands"
@@ -54,6 +55,16 @@ func Wsk(command []string, args ...string) error {
 	// compose the command
 	os.Args = command
 	os.Args = append(os.Args, args...)
+	// the whisk client replaces the ops transport, and with it OPS_CA_FILE
+	// and the proxy, when it is insecure: disable the verification in the
+	// ops transport instead
+	var insecure bool
+	os.Args, insecure = wskInsecureArgs(os.Args)
+	if insecure {
+		if err := config.ConfigureHTTPTransport(true); err != nil {
+			return err
+		}
+	}
 	// cleanup
 	defer func() {
 		if r := recover(); r != nil {
@@ -63,3 +74,24 @@ func Wsk(command []string, args ...string) error {
 	}()
 	return commands.Execute()
 }
+
+// wskInsecureArgs removes the -i/--insecure flags from the wsk arguments,
+// skipping the values of parameters and annotations, and tells if there
+// were any.
+func wskInsecureArgs(args []string) ([]string, bool) {
+	result := []string{}
+	insecure := false
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-i", "--insecure":
+			insecure = true
+		case "-p", "--param", "-a", "--annotation":
+			end := min(i+3, len(args))
+			result = append(result, args[i:end]...)
+			i = end - 1
+		default:
+			result = append(result, args[i])
+		}
+	}
+	return result, insecure
+}
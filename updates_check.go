@@ -18,6 +18,7 @@
 package openserverless
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/fs"
@@ -55,12 +56,19 @@ func checkUpdated(base string, timeInterval time.Duration) {
 		// touch latest_check file ONLY if enough time has passed
 		touchLatestCheckFile(latest_check_path)
 
-		// check if remote olaris is newer
-		if checkRemoteOlarisNewer(olaris_path) {
-			fmt.Print("New tasks available! Use 'ops -update' to update.\n\n")
-		} else {
-			fmt.Print("Tasks up to date!\n\n")
+		// check the remote olaris and the plugins at the same time
+		ctx, cancel := context.WithTimeout(context.Background(), pluginCheckTimeout)
+		defer cancel()
+		olarisNewer := make(chan bool, 1)
+		go func() { olarisNewer <- checkRemoteOlarisNewer(ctx, olaris_path) }()
+		plugins := checkPluginUpdates(ctx)
+		newer := false
+		select {
+		case newer = <-olarisNewer:
+		case <-ctx.Done():
+			debug("olaris update check timed out")
 		}
+		printUpdatesSummary(newer, plugins)
 	}
 }
 
@@ -94,8 +102,19 @@ func touchLatestCheckFile(latest_check_path string) {
 	}
 }
 
-func checkRemoteOlarisNewer(olaris_path string) bool {
+// checkRemoteOlarisNewer tells if the tasks source has tasks newer than
+// those in olaris_path, giving up on the git remote when ctx is done.
+func checkRemoteOlarisNewer(ctx context.Context, olaris_path string) bool {
 	trace("checkRemoteOlarisNewer", olaris_path)
+	source, err := currentTasksSource()
+	if err != nil {
+		warn("failed to check the tasks source", err)
+		return false
+	}
+	// only a checkout of a branch can be compared with the remote head
+	if gitSource, isGit := source.(*gitTasksSource); !isGit || gitSource.tags != "" {
+		return tasksSourceNewer(source, olaris_path)
+	}
 	repo, err := git.PlainOpen(olaris_path)
 	if err != nil {
 		warn("failed to check olaris folder", err)
@@ -113,8 +132,8 @@ func checkRemoteOlarisNewer(olaris_path string) bool {
 		warn("failed to check remote olaris", err)
 		return false
 	}
-	_ = remote.Fetch(&git.FetchOptions{})
-	remoteRefs, err := remote.List(&git.ListOptions{})
+	_ = remote.FetchContext(ctx, &git.FetchOptions{})
+	remoteRefs, err := remote.ListContext(ctx, &git.ListOptions{})
 	if err != nil {
 		warn("failed to check remote olaris", err)
 		return false
@@ -18,10 +18,15 @@
 package openserverless
 
 import (
+	"net"
 	"os"
+	"path/filepath"
+	"testing"
 	"time"
 
 	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/stretchr/testify/require"
 )
 
 func changeLatestCheckTime(base string, t time.Duration) {
@@ -132,3 +137,42 @@ func Example_checkUpdated_outdated() {
 	// Checking for updates...
 	// New tasks available! Use 'ops -update' to update.
 }
+
+func TestCheckUpdatedIsBoundedByTheTimeout(t *testing.T) {
+	setPluginsHome(t)
+	// a remote that accepts connections and never answers
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	remote := "http://" + ln.Addr().String() + "/olaris.git"
+	t.Setenv("OPS_REPO", remote)
+
+	base := t.TempDir()
+	branchDir := joinpath(base, getOpsBranch())
+	olaris := newPluginRepo(t, "olaris")
+	require.NoError(t, os.MkdirAll(branchDir, 0755))
+	require.NoError(t, os.Rename(olaris.dir, filepath.Join(branchDir, "olaris")))
+	repo, err := git.PlainOpen(filepath.Join(branchDir, "olaris"))
+	require.NoError(t, err)
+	_, err = repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{remote}})
+	require.NoError(t, err)
+	createLatestCheckFile(branchDir)
+	changeLatestCheckTime(branchDir, -2*time.Second)
+
+	oldTimeout := pluginCheckTimeout
+	pluginCheckTimeout = 200 * time.Millisecond
+	defer func() { pluginCheckTimeout = oldTimeout }()
+
+	start := time.Now()
+	checkUpdated(base, time.Second)
+	require.Less(t, time.Since(start), 5*time.Second)
+}